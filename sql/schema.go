@@ -0,0 +1,9 @@
+// Package sql holds the database schema
+package sql
+
+import _ "embed"
+
+// Schema creates all tables of the bot, see database.Migrate
+//
+//go:embed sql.sql
+var Schema string
@@ -1,52 +1,85 @@
+-- tenant 列为租户标识, 对应配置中 [[Tenants]] 的 key, 单机器人部署时为 default
+
 -- 管理员用户表
 CREATE TABLE admin_users (
-    admin_id INTEGER PRIMARY KEY,
-    username VARCHAR(50) UNIQUE NOT NULL,
+    admin_id INTEGER AUTO_INCREMENT PRIMARY KEY,
+    tenant VARCHAR(32) NOT NULL DEFAULT 'default',
+    username VARCHAR(50) NOT NULL,
     full_name VARCHAR(100),
     position VARCHAR(100),
-    telegram_id BIGINT UNIQUE NOT NULL,
-    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+    telegram_id BIGINT NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE KEY idx_tenant_username (tenant, username),
+    UNIQUE KEY idx_tenant_telegram (tenant, telegram_id)
 );
 
 -- 普通用户表
 CREATE TABLE regular_users (
-    user_id INTEGER PRIMARY KEY,
+    user_id INTEGER AUTO_INCREMENT PRIMARY KEY,
+    tenant VARCHAR(32) NOT NULL DEFAULT 'default',
     user_group VARCHAR(50) NOT NULL,
-    telegram_id BIGINT UNIQUE NOT NULL,
-    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+    -- 通过邮件联系的用户没有 Telegram ID, 只有邮箱
+    telegram_id BIGINT NULL,
+    email VARCHAR(255) NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    blocked_at TIMESTAMP NULL,
+    source VARCHAR(64) NULL,
+    source_at TIMESTAMP NULL,
+    UNIQUE KEY idx_tenant_telegram (tenant, telegram_id),
+    UNIQUE KEY idx_tenant_email (tenant, email)
 );
 
 -- 工单表
 CREATE TABLE tickets (
-    ticket_id INTEGER PRIMARY KEY,
+    ticket_id INTEGER AUTO_INCREMENT PRIMARY KEY,
+    tenant VARCHAR(32) NOT NULL DEFAULT 'default',
     title VARCHAR(200) NOT NULL,
     description TEXT,
     status VARCHAR(20) DEFAULT 'open',
     priority VARCHAR(20) DEFAULT 'normal',
+    category VARCHAR(32) NOT NULL DEFAULT '',
+    -- 工单来源渠道: telegram 或 email, 决定回复发送到哪里
+    channel VARCHAR(10) NOT NULL DEFAULT 'telegram',
     created_by INTEGER,
     assigned_to INTEGER,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    -- 最近一次 SLA 预警的时间, 每段等待 Staff 回复的时间只预警一次
+    sla_warned_at TIMESTAMP NULL,
     FOREIGN KEY (created_by) REFERENCES regular_users(user_id),
-    FOREIGN KEY (assigned_to) REFERENCES admin_users(admin_id)
+    FOREIGN KEY (assigned_to) REFERENCES admin_users(admin_id),
+    INDEX idx_tenant_status (tenant, status)
 );
 
 -- 工单评论表
 CREATE TABLE ticket_comments (
-    comment_id INTEGER PRIMARY KEY,
+    comment_id INTEGER AUTO_INCREMENT PRIMARY KEY,
     ticket_id INTEGER,
     user_id INTEGER,
     admin_id INTEGER,
+    chat_id BIGINT NOT NULL DEFAULT 0,
+    message_id INTEGER NOT NULL DEFAULT 0,
     content TEXT NOT NULL,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    edited_at TIMESTAMP NULL,
     FOREIGN KEY (ticket_id) REFERENCES tickets(ticket_id),
     FOREIGN KEY (user_id) REFERENCES regular_users(user_id),
-    FOREIGN KEY (admin_id) REFERENCES admin_users(admin_id)
+    FOREIGN KEY (admin_id) REFERENCES admin_users(admin_id),
+    INDEX idx_chat_message (chat_id, message_id)
+);
+
+-- 工单评论编辑历史表 (保存评论编辑前的内容)
+CREATE TABLE ticket_comment_edits (
+    edit_id INTEGER AUTO_INCREMENT PRIMARY KEY,
+    comment_id INTEGER NOT NULL,
+    content TEXT NOT NULL,
+    edited_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (comment_id) REFERENCES ticket_comments(comment_id)
 );
 
 -- 工单历史记录表
 CREATE TABLE ticket_history (
-    history_id INTEGER PRIMARY KEY,
+    history_id INTEGER AUTO_INCREMENT PRIMARY KEY,
     ticket_id INTEGER,
     user_id INTEGER,
     admin_id INTEGER,
@@ -56,4 +89,109 @@ CREATE TABLE ticket_history (
     FOREIGN KEY (ticket_id) REFERENCES tickets(ticket_id),
     FOREIGN KEY (user_id) REFERENCES regular_users(user_id),
     FOREIGN KEY (admin_id) REFERENCES admin_users(admin_id)
-);
\ No newline at end of file
+);
+
+-- 工单论坛话题表
+CREATE TABLE ticket_topics (
+    ticket_id INTEGER PRIMARY KEY,
+    chat_id BIGINT NOT NULL,
+    thread_id INTEGER NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (chat_id, thread_id),
+    FOREIGN KEY (ticket_id) REFERENCES tickets(ticket_id)
+);
+
+-- 工单消息表 (通知消息用于直接回复评论工单, 工单卡片用于工单变更时刷新)
+CREATE TABLE ticket_messages (
+    tenant VARCHAR(32) NOT NULL DEFAULT 'default',
+    chat_id BIGINT NOT NULL,
+    message_id INTEGER NOT NULL,
+    ticket_id INTEGER NOT NULL,
+    kind VARCHAR(20) NOT NULL DEFAULT 'notification',
+    viewer_id BIGINT,
+    page INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (tenant, chat_id, message_id),
+    FOREIGN KEY (ticket_id) REFERENCES tickets(ticket_id)
+);
+
+-- 公告广播表
+CREATE TABLE broadcasts (
+    broadcast_id INTEGER AUTO_INCREMENT PRIMARY KEY,
+    tenant VARCHAR(32) NOT NULL DEFAULT 'default',
+    admin_id INTEGER,
+    audience VARCHAR(20) NOT NULL,
+    user_group VARCHAR(50),
+    content TEXT NOT NULL,
+    status VARCHAR(20) DEFAULT 'running',
+    total INTEGER DEFAULT 0,
+    sent INTEGER DEFAULT 0,
+    failed INTEGER DEFAULT 0,
+    blocked INTEGER DEFAULT 0,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    finished_at TIMESTAMP NULL,
+    FOREIGN KEY (admin_id) REFERENCES admin_users(admin_id)
+);
+
+-- 通知偏好表 (未设置的事件默认即时通知)
+CREATE TABLE notification_preferences (
+    tenant VARCHAR(32) NOT NULL DEFAULT 'default',
+    telegram_id BIGINT NOT NULL,
+    event VARCHAR(30) NOT NULL,
+    mode VARCHAR(10) NOT NULL,
+    PRIMARY KEY (tenant, telegram_id, event)
+);
+
+-- 免打扰时段表 (服务器时间, 整点)
+CREATE TABLE notification_quiet_hours (
+    tenant VARCHAR(32) NOT NULL DEFAULT 'default',
+    telegram_id BIGINT NOT NULL,
+    start_hour INTEGER NOT NULL,
+    end_hour INTEGER NOT NULL,
+    PRIMARY KEY (tenant, telegram_id)
+);
+
+-- 待发送通知表 (摘要通知及免打扰时段内暂缓的即时通知)
+CREATE TABLE pending_notifications (
+    notification_id INTEGER AUTO_INCREMENT PRIMARY KEY,
+    tenant VARCHAR(32) NOT NULL DEFAULT 'default',
+    telegram_id BIGINT NOT NULL,
+    event VARCHAR(30) NOT NULL,
+    ticket_id INTEGER,
+    content TEXT NOT NULL,
+    held BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    INDEX (tenant, telegram_id)
+);
+
+-- REST API 令牌表 (仅保存令牌的 SHA-256 哈希, scopes 以逗号分隔)
+CREATE TABLE api_tokens (
+    token_id INTEGER AUTO_INCREMENT PRIMARY KEY,
+    tenant VARCHAR(32) NOT NULL DEFAULT 'default',
+    name VARCHAR(64) NOT NULL,
+    token_hash CHAR(64) NOT NULL UNIQUE,
+    scopes VARCHAR(200) NOT NULL,
+    created_by INTEGER,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    last_used_at TIMESTAMP NULL,
+    revoked_at TIMESTAMP NULL,
+    INDEX (tenant),
+    FOREIGN KEY (created_by) REFERENCES admin_users(admin_id)
+);
+
+-- Webhook 投递记录表 (失败的投递按指数退避重试, 超过最大次数后标记为 failed)
+CREATE TABLE webhook_deliveries (
+    delivery_id INTEGER AUTO_INCREMENT PRIMARY KEY,
+    tenant VARCHAR(32) NOT NULL DEFAULT 'default',
+    url VARCHAR(500) NOT NULL,
+    event VARCHAR(30) NOT NULL,
+    payload TEXT NOT NULL,
+    status VARCHAR(10) NOT NULL DEFAULT 'pending',
+    attempts INTEGER NOT NULL DEFAULT 0,
+    response_code INTEGER NOT NULL DEFAULT 0,
+    last_error VARCHAR(500) NOT NULL DEFAULT '',
+    next_attempt_at TIMESTAMP NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    delivered_at TIMESTAMP NULL,
+    INDEX (tenant, status, next_attempt_at)
+);
@@ -0,0 +1,129 @@
+package api
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"telegram-tickets-bot/src/config"
+	"telegram-tickets-bot/src/database"
+
+	"gorm.io/gorm"
+)
+
+//go:embed openapi.json
+var openAPISpec []byte
+
+// Server is the REST API of all tenants. Every API token belongs to one tenant and only sees its data.
+type Server struct {
+	tenants map[string]*config.Tenant
+	mux     *http.ServeMux
+}
+
+// Handler of a request authenticated with token
+type tokenHandler func(w http.ResponseWriter, r *http.Request, token *database.APIToken)
+
+func NewServer(cfg *config.Config) *Server {
+	s := &Server{
+		tenants: make(map[string]*config.Tenant),
+		mux:     http.NewServeMux(),
+	}
+	for i := range cfg.Tenants {
+		s.tenants[cfg.Tenants[i].Key] = &cfg.Tenants[i]
+	}
+
+	s.mux.HandleFunc("GET /openapi.json", s.handleOpenAPI)
+	s.mux.HandleFunc("GET /api/v1/tickets", s.authorize(database.ScopeTicketsRead, s.handleListTickets))
+	s.mux.HandleFunc("POST /api/v1/tickets", s.authorize(database.ScopeTicketsWrite, s.handleCreateTicket))
+	s.mux.HandleFunc("GET /api/v1/tickets/{id}", s.authorize(database.ScopeTicketsRead, s.handleGetTicket))
+	s.mux.HandleFunc("POST /api/v1/tickets/{id}/comments", s.authorize(database.ScopeCommentsWrite, s.handleAddComment))
+	s.mux.HandleFunc("POST /api/v1/tickets/{id}/assign", s.authorize(database.ScopeTicketsWrite, s.handleAssignTicket))
+	s.mux.HandleFunc("POST /api/v1/tickets/{id}/status", s.authorize(database.ScopeTicketsWrite, s.handleSetStatus))
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// ListenAndServe serves the API on addr until the server fails
+func (s *Server) ListenAndServe(addr string) error {
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           s,
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       30 * time.Second,
+		WriteTimeout:      30 * time.Second,
+	}
+	slog.Info("REST API listening", "addr", addr)
+	return server.ListenAndServe()
+}
+
+// Authenticate the request's bearer token and check that it was granted scope
+func (s *Server) authorize(scope string, next tokenHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		secret, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || secret == "" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+			writeError(w, http.StatusUnauthorized, "missing bearer token")
+			return
+		}
+
+		db, err := database.InitializeDB()
+		if err != nil {
+			writeServerError(w, fmt.Errorf("[ERROR] Failed to get database connection: %v", err))
+			return
+		}
+
+		token, err := database.GetAPITokenByHash(db, database.HashAPIToken(secret))
+		if err == gorm.ErrRecordNotFound {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="api", error="invalid_token"`)
+			writeError(w, http.StatusUnauthorized, "invalid or revoked token")
+			return
+		} else if err != nil {
+			writeServerError(w, fmt.Errorf("[ERROR] Failed to get API token: %v", err))
+			return
+		}
+		if _, ok := s.tenants[token.Tenant]; !ok {
+			// Token of a tenant that is no longer configured
+			writeError(w, http.StatusUnauthorized, "invalid or revoked token")
+			return
+		}
+		if !token.HasScope(scope) {
+			writeError(w, http.StatusForbidden, "token lacks scope "+scope)
+			return
+		}
+
+		if err := database.TouchAPIToken(db, token.TokenID); err != nil {
+			slog.Error("Failed to record API token use", "token_id", token.TokenID, "error", err)
+		}
+		next(w, r, token)
+	}
+}
+
+func (s *Server) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPISpec)
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		slog.Error("Failed to write API response", "error", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
+
+// Log the cause of an internal error without exposing it to the client
+func writeServerError(w http.ResponseWriter, err error) {
+	slog.Error("API request failed", "error", err)
+	writeError(w, http.StatusInternalServerError, "internal error")
+}
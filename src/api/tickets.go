@@ -0,0 +1,364 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"telegram-tickets-bot/src/config"
+	"telegram-tickets-bot/src/database"
+	"telegram-tickets-bot/src/tickets"
+
+	"gorm.io/gorm"
+)
+
+const (
+	defaultListLimit = 50
+	maxListLimit     = 200
+	// Maximum length of a ticket title, as stored in tickets.title
+	maxTitleLength = 200
+	// Maximum size of a request body
+	maxBodySize = 1 << 20
+)
+
+var (
+	ticketStatuses   = map[string]bool{"open": true, "closed": true}
+	ticketPriorities = map[string]bool{"low": true, "normal": true, "high": true, "urgent": true}
+)
+
+// Decode the JSON request body into value, rejecting unknown fields
+func decodeJSON(w http.ResponseWriter, r *http.Request, value interface{}) bool {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(value); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return false
+	}
+	return true
+}
+
+// Parse an optional integer query parameter
+func queryInt(r *http.Request, name string) (*int, error) {
+	raw := r.URL.Query().Get(name)
+	if raw == "" {
+		return nil, nil
+	}
+	value, err := strconv.Atoi(raw)
+	if err != nil || value < 0 {
+		return nil, fmt.Errorf("invalid %s", name)
+	}
+	return &value, nil
+}
+
+// Load the ticket named in the path if it belongs to the token's tenant, writing the error response otherwise
+func loadTicket(w http.ResponseWriter, r *http.Request, db *gorm.DB, token *database.APIToken) (*tickets.Ticket, bool) {
+	ticketID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid ticket id")
+		return nil, false
+	}
+
+	ticket, err := tickets.GetTicketByID(db, token.Tenant, ticketID)
+	if err == gorm.ErrRecordNotFound {
+		writeError(w, http.StatusNotFound, "ticket not found")
+		return nil, false
+	} else if err != nil {
+		writeServerError(w, fmt.Errorf("[ERROR] Failed to get ticket: %v", err))
+		return nil, false
+	}
+	return ticket, true
+}
+
+// Load an admin of the token's tenant, writing the error response otherwise
+func loadAdmin(w http.ResponseWriter, db *gorm.DB, token *database.APIToken, adminID int) (*database.AdminUser, bool) {
+	if adminID <= 0 {
+		writeError(w, http.StatusBadRequest, "admin_id is required")
+		return nil, false
+	}
+
+	admins, err := database.GetAdmins(db, token.Tenant)
+	if err != nil {
+		writeServerError(w, err)
+		return nil, false
+	}
+	for i := range admins {
+		if admins[i].AdminID == adminID {
+			return &admins[i], true
+		}
+	}
+	writeError(w, http.StatusBadRequest, "unknown admin_id")
+	return nil, false
+}
+
+func (s *Server) handleListTickets(w http.ResponseWriter, r *http.Request, token *database.APIToken) {
+	query := r.URL.Query()
+	filter := tickets.TicketFilter{
+		Status:   query.Get("status"),
+		Priority: query.Get("priority"),
+		Category: query.Get("category"),
+		Query:    query.Get("q"),
+	}
+
+	var err error
+	if filter.AssignedTo, err = queryInt(r, "assigned_to"); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if filter.CreatedBy, err = queryInt(r, "created_by"); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	offset, err := queryInt(r, "offset")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	limit, err := queryInt(r, "limit")
+	if err != nil || (limit != nil && (*limit == 0 || *limit > maxListLimit)) {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxListLimit))
+		return
+	}
+	if offset == nil {
+		offset = new(int)
+	}
+	if limit == nil {
+		limit = new(int)
+		*limit = defaultListLimit
+	}
+
+	db, err := database.InitializeDB()
+	if err != nil {
+		writeServerError(w, fmt.Errorf("[ERROR] Failed to get database connection: %v", err))
+		return
+	}
+
+	list, err := tickets.ListTickets(db, token.Tenant, filter, *offset, *limit)
+	if err != nil {
+		writeServerError(w, fmt.Errorf("[ERROR] Failed to list tickets: %v", err))
+		return
+	}
+
+	result := make([]tickets.TicketJSON, 0, len(list))
+	for i := range list {
+		result = append(result, tickets.NewTicketJSON(&list[i]))
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"tickets": result})
+}
+
+func (s *Server) handleGetTicket(w http.ResponseWriter, r *http.Request, token *database.APIToken) {
+	db, err := database.InitializeDB()
+	if err != nil {
+		writeServerError(w, fmt.Errorf("[ERROR] Failed to get database connection: %v", err))
+		return
+	}
+
+	ticket, ok := loadTicket(w, r, db, token)
+	if !ok {
+		return
+	}
+
+	comments, err := tickets.GetTicketComments(db, ticket.TicketID)
+	if err != nil {
+		writeServerError(w, err)
+		return
+	}
+
+	result := tickets.NewTicketJSON(ticket)
+	result.Comments = make([]tickets.CommentJSON, 0, len(comments))
+	for i := range comments {
+		result.Comments = append(result.Comments, tickets.NewCommentJSON(&comments[i]))
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+type createTicketRequest struct {
+	TelegramID  int64  `json:"telegram_id"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Priority    string `json:"priority"`
+	Category    string `json:"category"`
+}
+
+// Create a ticket on behalf of the Telegram user, registering them if needed
+func (s *Server) handleCreateTicket(w http.ResponseWriter, r *http.Request, token *database.APIToken) {
+	var request createTicketRequest
+	if !decodeJSON(w, r, &request) {
+		return
+	}
+
+	request.Title = strings.TrimSpace(request.Title)
+	if request.Priority == "" {
+		request.Priority = "normal"
+	}
+	switch {
+	case request.TelegramID <= 0:
+		writeError(w, http.StatusBadRequest, "telegram_id is required")
+		return
+	case request.Title == "" || utf8.RuneCountInString(request.Title) > maxTitleLength:
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("title must have 1 to %d characters", maxTitleLength))
+		return
+	case !ticketPriorities[request.Priority]:
+		writeError(w, http.StatusBadRequest, "priority must be one of low, normal, high, urgent")
+		return
+	case request.Category != "" && !s.isCategory(token.Tenant, request.Category):
+		writeError(w, http.StatusBadRequest, "unknown category")
+		return
+	}
+
+	db, err := database.InitializeDB()
+	if err != nil {
+		writeServerError(w, fmt.Errorf("[ERROR] Failed to get database connection: %v", err))
+		return
+	}
+
+	ticket, err := tickets.CreateTicket(db, token.Tenant, request.TelegramID, request.Title, request.Description, request.Priority, request.Category)
+	if err != nil {
+		writeServerError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, tickets.NewTicketJSON(ticket))
+}
+
+func (s *Server) isCategory(tenant string, key string) bool {
+	// Categories may change with a configuration reload
+	for _, category := range config.CurrentTenant(tenant).Categories {
+		if category.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+type addCommentRequest struct {
+	// "user" for a comment by the ticket creator, "admin" for a staff reply
+	Author  string `json:"author"`
+	AdminID int    `json:"admin_id"`
+	Content string `json:"content"`
+}
+
+func (s *Server) handleAddComment(w http.ResponseWriter, r *http.Request, token *database.APIToken) {
+	var request addCommentRequest
+	if !decodeJSON(w, r, &request) {
+		return
+	}
+	if strings.TrimSpace(request.Content) == "" {
+		writeError(w, http.StatusBadRequest, "content is required")
+		return
+	}
+	if request.Author != "user" && request.Author != "admin" {
+		writeError(w, http.StatusBadRequest, "author must be user or admin")
+		return
+	}
+
+	db, err := database.InitializeDB()
+	if err != nil {
+		writeServerError(w, fmt.Errorf("[ERROR] Failed to get database connection: %v", err))
+		return
+	}
+
+	ticket, ok := loadTicket(w, r, db, token)
+	if !ok {
+		return
+	}
+	if ticket.Status == "closed" {
+		writeError(w, http.StatusConflict, "ticket is closed")
+		return
+	}
+
+	var comment *tickets.TicketComment
+	if request.Author == "admin" {
+		admin, ok := loadAdmin(w, db, token, request.AdminID)
+		if !ok {
+			return
+		}
+		if comment, err = tickets.AddAdminComment(db, ticket.TicketID, admin.AdminID, request.Content, 0, 0); err != nil {
+			writeServerError(w, err)
+			return
+		}
+	} else {
+		if comment, err = tickets.AddComment(db, ticket.TicketID, ticket.CreatedBy, request.Content, 0, 0); err != nil {
+			writeServerError(w, err)
+			return
+		}
+	}
+
+	writeJSON(w, http.StatusCreated, tickets.NewCommentJSON(comment))
+}
+
+type assignTicketRequest struct {
+	AdminID int `json:"admin_id"`
+}
+
+func (s *Server) handleAssignTicket(w http.ResponseWriter, r *http.Request, token *database.APIToken) {
+	var request assignTicketRequest
+	if !decodeJSON(w, r, &request) {
+		return
+	}
+
+	db, err := database.InitializeDB()
+	if err != nil {
+		writeServerError(w, fmt.Errorf("[ERROR] Failed to get database connection: %v", err))
+		return
+	}
+
+	ticket, ok := loadTicket(w, r, db, token)
+	if !ok {
+		return
+	}
+	admin, ok := loadAdmin(w, db, token, request.AdminID)
+	if !ok {
+		return
+	}
+
+	if err := tickets.AssignTicket(db, ticket.TicketID, admin.AdminID); err != nil {
+		writeServerError(w, err)
+		return
+	}
+	ticket.AssignedTo = &admin.AdminID
+
+	writeJSON(w, http.StatusOK, tickets.NewTicketJSON(ticket))
+}
+
+type setStatusRequest struct {
+	Status string `json:"status"`
+}
+
+func (s *Server) handleSetStatus(w http.ResponseWriter, r *http.Request, token *database.APIToken) {
+	var request setStatusRequest
+	if !decodeJSON(w, r, &request) {
+		return
+	}
+	if !ticketStatuses[request.Status] {
+		writeError(w, http.StatusBadRequest, "status must be open or closed")
+		return
+	}
+
+	db, err := database.InitializeDB()
+	if err != nil {
+		writeServerError(w, fmt.Errorf("[ERROR] Failed to get database connection: %v", err))
+		return
+	}
+
+	ticket, ok := loadTicket(w, r, db, token)
+	if !ok {
+		return
+	}
+	if ticket.Status == request.Status {
+		writeJSON(w, http.StatusOK, tickets.NewTicketJSON(ticket))
+		return
+	}
+
+	if err := tickets.SetTicketStatus(db, ticket.TicketID, request.Status, 0); err != nil {
+		writeServerError(w, err)
+		return
+	}
+	if ticket, ok = loadTicket(w, r, db, token); !ok {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, tickets.NewTicketJSON(ticket))
+}
@@ -0,0 +1,142 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"telegram-tickets-bot/src/config"
+	"telegram-tickets-bot/src/database"
+	"telegram-tickets-bot/src/telegram"
+
+	"gorm.io/gorm"
+)
+
+func runAdmin(args []string) error {
+	return runGroup("admin", args, []command{
+		{"add", "Register a Telegram user as admin", runAdminAdd},
+		{"remove", "Remove an admin", runAdminRemove},
+		{"list", "List the admins of a tenant", runAdminList},
+	})
+}
+
+func runAdminAdd(args []string) error {
+	flags := newFlagSet("admin add", "[flags]")
+	tenantKey := flags.String("tenant", config.DefaultTenant, "Tenant key")
+	telegramID := flags.Int64("telegram-id", 0, "Telegram user ID of the admin (required)")
+	username := flags.String("username", "", "Unique username of the admin within the tenant (required)")
+	fullName := flags.String("name", "", "Name shown to users and in the forum topic, the username if empty")
+	position := flags.String("position", "", "Position of the admin, e.g. Support")
+	if err := parseFlags(flags, args, 0); err != nil {
+		return err
+	}
+	if *telegramID <= 0 || *username == "" {
+		flags.Usage()
+		return errUsage
+	}
+	if *fullName == "" {
+		*fullName = *username
+	}
+
+	cfg, db, err := openDatabase()
+	if err != nil {
+		return err
+	}
+	tenant, err := findTenant(cfg, *tenantKey)
+	if err != nil {
+		return err
+	}
+
+	if _, err := database.GetAdminIDByTelegramID(db, tenant.Key, *telegramID); err == nil {
+		return fmt.Errorf("[ERROR] Telegram user %d is already an admin of tenant %s", *telegramID, tenant.Key)
+	} else if err != gorm.ErrRecordNotFound {
+		return fmt.Errorf("[ERROR] Failed to check admin status: %v", err)
+	}
+
+	admin := &database.AdminUser{
+		Tenant:     tenant.Key,
+		Username:   *username,
+		FullName:   *fullName,
+		Position:   *position,
+		TelegramID: *telegramID,
+	}
+	if err := database.CreateAdmin(db, admin); err != nil {
+		return err
+	}
+	fmt.Printf("Added admin %d (%s) to tenant %s\n", admin.AdminID, admin.Username, tenant.Key)
+	syncAdminCommands(tenant, *telegramID)
+	return nil
+}
+
+func runAdminRemove(args []string) error {
+	flags := newFlagSet("admin remove", "[flags]")
+	tenantKey := flags.String("tenant", config.DefaultTenant, "Tenant key")
+	telegramID := flags.Int64("telegram-id", 0, "Telegram user ID of the admin (required)")
+	if err := parseFlags(flags, args, 0); err != nil {
+		return err
+	}
+	if *telegramID <= 0 {
+		flags.Usage()
+		return errUsage
+	}
+
+	cfg, db, err := openDatabase()
+	if err != nil {
+		return err
+	}
+	tenant, err := findTenant(cfg, *tenantKey)
+	if err != nil {
+		return err
+	}
+
+	err = database.DeleteAdmin(db, tenant.Key, *telegramID)
+	if err == gorm.ErrRecordNotFound {
+		return fmt.Errorf("[ERROR] Telegram user %d is not an admin of tenant %s", *telegramID, tenant.Key)
+	} else if err != nil {
+		return fmt.Errorf("%v\nAdmins who replied to tickets or appear in their history are kept for the record.", err)
+	}
+	fmt.Printf("Removed admin %d from tenant %s; their tickets are unassigned\n", *telegramID, tenant.Key)
+	syncAdminCommands(tenant, *telegramID)
+	return nil
+}
+
+// Update the command menu of an added or removed admin right away instead of at the running bot's next
+// sync. The change itself is saved, so a failure is only reported.
+func syncAdminCommands(tenant *config.Tenant, telegramID int64) {
+	bot, err := telegram.NewBot(tenant)
+	if err == nil {
+		err = bot.SyncAdminCommands(telegramID)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to update the command menu, the bot updates it within 10 minutes: %v\n", err)
+	}
+}
+
+func runAdminList(args []string) error {
+	flags := newFlagSet("admin list", "[flags]")
+	tenantKey := flags.String("tenant", config.DefaultTenant, "Tenant key")
+	if err := parseFlags(flags, args, 0); err != nil {
+		return err
+	}
+
+	cfg, db, err := openDatabase()
+	if err != nil {
+		return err
+	}
+	tenant, err := findTenant(cfg, *tenantKey)
+	if err != nil {
+		return err
+	}
+
+	admins, err := database.GetAdmins(db, tenant.Key)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tTELEGRAM ID\tUSERNAME\tNAME\tPOSITION")
+	for _, admin := range admins {
+		fmt.Fprintf(w, "%d\t%d\t%s\t%s\t%s\n", admin.AdminID, admin.TelegramID, admin.Username, admin.FullName, admin.Position)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,32 @@
+package cli
+
+import (
+	"fmt"
+
+	"telegram-tickets-bot/src/webhooks"
+)
+
+// Validate the configuration without connecting to anything
+func runCheckConfig(args []string) error {
+	flags := newFlagSet("check-config", "")
+	if err := parseFlags(flags, args, 0); err != nil {
+		return err
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	for i := range cfg.Tenants {
+		// Webhook event names are checked by the dispatcher
+		if _, err := webhooks.NewDispatcher(&cfg.Tenants[i]); err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("Configuration is valid: %d tenant(s)\n", len(cfg.Tenants))
+	for _, tenant := range cfg.Tenants {
+		fmt.Printf("  %s: %d categories, %d webhooks\n", tenant.Key, len(tenant.Categories), len(tenant.Webhooks))
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+// Package cli implements the subcommands of the telegram-tickets-bot binary
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"telegram-tickets-bot/src/config"
+	"telegram-tickets-bot/src/database"
+	"telegram-tickets-bot/src/logging"
+
+	"gorm.io/gorm"
+)
+
+// A subcommand; run receives the arguments after its name
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) error
+}
+
+var commands []command
+
+func init() {
+	commands = []command{
+		{"serve", "Run the bots and the enabled servers (default)", runServe},
+		{"migrate", "Create missing database tables and upgrade existing ones", runMigrate},
+		{"admin", "Add, remove or list admins", runAdmin},
+		{"ticket", "List, show or close tickets", runTicket},
+		{"export", "Write tickets, comments and users to a JSON file", runExport},
+		{"import", "Load a JSON file written by export", runImport},
+		{"check-config", "Validate the configuration and exit", runCheckConfig},
+	}
+}
+
+// errUsage reports bad arguments whose usage was already printed
+var errUsage = fmt.Errorf("invalid arguments")
+
+// Main runs the subcommand named by args[0], serve if there is none, and returns the exit code
+func Main(args []string) int {
+	if len(args) > 0 && (args[0] == "help" || args[0] == "-h" || args[0] == "--help") {
+		usage(os.Stdout)
+		return 0
+	}
+	// Without a command, e.g. with only --config, the bot is served
+	name := "serve"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+
+	for _, command := range commands {
+		if command.name != name {
+			continue
+		}
+		if err := command.run(args); err == flag.ErrHelp {
+			return 0
+		} else if err == errUsage {
+			return 2
+		} else if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		return 0
+	}
+
+	fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", name)
+	usage(os.Stderr)
+	return 2
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: telegram-tickets-bot <command> [arguments]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	for _, command := range commands {
+		fmt.Fprintf(w, "  %-14s %s\n", command.name, command.summary)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, `Run "telegram-tickets-bot <command> -h" for the arguments of a command.`)
+	fmt.Fprintln(w, "Settings of the configuration file can be overridden with TTB_* environment variables, see config.example.toml.")
+}
+
+// Run the subcommand of a command group like "admin add", printing the group's usage for unknown ones
+func runGroup(group string, args []string, subcommands []command) error {
+	if len(args) > 0 {
+		for _, subcommand := range subcommands {
+			if subcommand.name == args[0] {
+				return subcommand.run(args[1:])
+			}
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "Usage: telegram-tickets-bot %s <command> [arguments]\n\nCommands:\n", group)
+	for _, subcommand := range subcommands {
+		fmt.Fprintf(os.Stderr, "  %-8s %s\n", subcommand.name, subcommand.summary)
+	}
+	return errUsage
+}
+
+// Configuration file given with --config; empty for TTB_CONFIG or config.toml
+var configPath string
+
+// Return a flag set of the command whose usage lists its flags after the argument synopsis.
+// Every command accepts --config.
+func newFlagSet(name string, synopsis string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.StringVar(&configPath, "config", "", "Configuration file (default $TTB_CONFIG or "+config.DefaultPath+")")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: telegram-tickets-bot %s %s\n", name, synopsis)
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+// Parse the command's arguments, which must leave exactly positional arguments behind
+func parseFlags(flags *flag.FlagSet, args []string, positional int) error {
+	if err := flags.Parse(args); err == flag.ErrHelp {
+		return err
+	} else if err != nil {
+		// The flag package already printed the error and usage
+		return errUsage
+	}
+	if flags.NArg() != positional {
+		flags.Usage()
+		return errUsage
+	}
+	return nil
+}
+
+// Load and validate the configuration and set up logging, as every command does first
+func loadConfig() (*config.Config, error) {
+	cfg, err := config.InitializationConfig(configPath)
+	if err != nil {
+		return nil, err
+	}
+	logging.Setup(cfg.Logging)
+	return &cfg, nil
+}
+
+// Load the configuration and connect to the database
+func openDatabase() (*config.Config, *gorm.DB, error) {
+	cfg, err := loadConfig()
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := database.InitializeAndPrintDBInfo(cfg); err != nil {
+		return nil, nil, fmt.Errorf("[ERROR] Failed to initialize database: %v", err)
+	}
+	db, err := database.InitializeDB()
+	if err != nil {
+		return nil, nil, err
+	}
+	return cfg, db, nil
+}
+
+// Return the configured tenant with the key
+func findTenant(cfg *config.Config, key string) (*config.Tenant, error) {
+	var keys []string
+	for i := range cfg.Tenants {
+		if cfg.Tenants[i].Key == key {
+			return &cfg.Tenants[i], nil
+		}
+		keys = append(keys, cfg.Tenants[i].Key)
+	}
+	return nil, fmt.Errorf("[ERROR] Unknown tenant %q, configured tenants: %s", key, strings.Join(keys, ", "))
+}
@@ -0,0 +1,205 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"telegram-tickets-bot/src/database"
+	"telegram-tickets-bot/src/tickets"
+
+	"gorm.io/gorm"
+)
+
+// Version of the export format, raised when it changes incompatibly
+const exportVersion = 1
+
+// Export holds the support data of one or all tenants: admins, users and their tickets with comments
+// and history. Comments keep the chat and message they were written in, so that edits of those messages
+// still reach them. Forum topics, ticket cards, notifications and their settings, API tokens and webhook
+// deliveries are bound to the running installation and not exported.
+type export struct {
+	Version      int                         `json:"version"`
+	Tenant       string                      `json:"tenant,omitempty"`
+	ExportedAt   time.Time                   `json:"exported_at"`
+	Admins       []database.AdminUser        `json:"admins"`
+	Users        []database.RegularUser      `json:"users"`
+	Tickets      []tickets.Ticket            `json:"tickets"`
+	Comments     []tickets.TicketComment     `json:"comments"`
+	CommentEdits []tickets.TicketCommentEdit `json:"comment_edits"`
+	History      []tickets.TicketHistory     `json:"history"`
+}
+
+func runExport(args []string) error {
+	flags := newFlagSet("export", "[flags]")
+	tenantKey := flags.String("tenant", "", "Tenant key; all tenants if empty")
+	output := flags.String("output", "-", "File to write, - for standard output")
+	if err := parseFlags(flags, args, 0); err != nil {
+		return err
+	}
+
+	cfg, db, err := openDatabase()
+	if err != nil {
+		return err
+	}
+	if *tenantKey != "" {
+		if _, err := findTenant(cfg, *tenantKey); err != nil {
+			return err
+		}
+	}
+
+	data, err := exportData(db, *tenantKey)
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "-" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return fmt.Errorf("[ERROR] Failed to create export file: %v", err)
+		}
+		defer file.Close()
+		w = file
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(data); err != nil {
+		return fmt.Errorf("[ERROR] Failed to write export: %v", err)
+	}
+	if *output != "-" {
+		fmt.Fprintf(os.Stderr, "Exported %d tickets, %d comments and %d users to %s\n",
+			len(data.Tickets), len(data.Comments), len(data.Users), *output)
+	}
+	return nil
+}
+
+// Read the rows of the tenant, or of all tenants if tenant is empty
+func exportData(db *gorm.DB, tenant string) (*export, error) {
+	data := &export{Version: exportVersion, Tenant: tenant, ExportedAt: time.Now()}
+
+	// Tables without a tenant column are selected through their ticket
+	byTenant, byTicket := db, db
+	if tenant != "" {
+		byTenant = db.Where("tenant = ?", tenant)
+		byTicket = db.Where("ticket_id IN (?)", db.Model(&tickets.Ticket{}).Select("ticket_id").Where("tenant = ?", tenant))
+	}
+
+	queries := []struct {
+		table string
+		run   func() error
+	}{
+		{"admin_users", func() error { return byTenant.Session(&gorm.Session{}).Order("admin_id").Find(&data.Admins).Error }},
+		{"regular_users", func() error { return byTenant.Session(&gorm.Session{}).Order("user_id").Find(&data.Users).Error }},
+		{"tickets", func() error { return byTenant.Session(&gorm.Session{}).Order("ticket_id").Find(&data.Tickets).Error }},
+		{"ticket_comments", func() error {
+			return byTicket.Session(&gorm.Session{}).Order("comment_id").Find(&data.Comments).Error
+		}},
+		{"ticket_comment_edits", func() error {
+			tx := db.Order("edit_id")
+			if tenant != "" {
+				tx = tx.Where("comment_id IN (?)", byTicket.Session(&gorm.Session{}).Model(&tickets.TicketComment{}).Select("comment_id"))
+			}
+			return tx.Find(&data.CommentEdits).Error
+		}},
+		{"ticket_history", func() error {
+			return byTicket.Session(&gorm.Session{}).Order("history_id").Find(&data.History).Error
+		}},
+	}
+	for _, query := range queries {
+		if err := query.run(); err != nil {
+			return nil, fmt.Errorf("[ERROR] Failed to export %s: %v", query.table, err)
+		}
+	}
+	return data, nil
+}
+
+func runImport(args []string) error {
+	flags := newFlagSet("import", "<file>")
+	if err := parseFlags(flags, args, 1); err != nil {
+		return err
+	}
+
+	file, err := os.Open(flags.Arg(0))
+	if err != nil {
+		return fmt.Errorf("[ERROR] Failed to open import file: %v", err)
+	}
+	defer file.Close()
+
+	var data export
+	if err := json.NewDecoder(file).Decode(&data); err != nil {
+		return fmt.Errorf("[ERROR] Failed to parse import file: %v", err)
+	}
+	if data.Version != exportVersion {
+		return fmt.Errorf("[ERROR] Unsupported export version %d, expected %d", data.Version, exportVersion)
+	}
+
+	cfg, db, err := openDatabase()
+	if err != nil {
+		return err
+	}
+	// Rows of tenants missing from the configuration would be invisible to the bot
+	tenants := make(map[string]bool)
+	for _, admin := range data.Admins {
+		tenants[admin.Tenant] = true
+	}
+	for _, user := range data.Users {
+		tenants[user.Tenant] = true
+	}
+	for _, ticket := range data.Tickets {
+		tenants[ticket.Tenant] = true
+	}
+	for tenant := range tenants {
+		if _, err := findTenant(cfg, tenant); err != nil {
+			return err
+		}
+	}
+
+	// Users reached only by email have no Telegram ID and are inserted without one, so that they do not
+	// collide in the unique (tenant, telegram_id) index
+	var telegramUsers, emailUsers []database.RegularUser
+	for _, user := range data.Users {
+		if user.TelegramID == 0 {
+			emailUsers = append(emailUsers, user)
+		} else {
+			telegramUsers = append(telegramUsers, user)
+		}
+	}
+
+	// IDs are kept so the rows keep referring to each other; rows that exist already make the whole import fail
+	err = db.Transaction(func(tx *gorm.DB) error {
+		rows := []struct {
+			table string
+			count int
+			value interface{}
+			omit  []string
+		}{
+			{"admin_users", len(data.Admins), &data.Admins, nil},
+			{"regular_users", len(telegramUsers), &telegramUsers, nil},
+			{"regular_users", len(emailUsers), &emailUsers, []string{"telegram_id"}},
+			{"tickets", len(data.Tickets), &data.Tickets, nil},
+			{"ticket_comments", len(data.Comments), &data.Comments, nil},
+			{"ticket_comment_edits", len(data.CommentEdits), &data.CommentEdits, nil},
+			{"ticket_history", len(data.History), &data.History, nil},
+		}
+		for _, row := range rows {
+			if row.count == 0 {
+				continue
+			}
+			if err := tx.Omit(row.omit...).CreateInBatches(row.value, 100).Error; err != nil {
+				return fmt.Errorf("[ERROR] Failed to import %s: %v", row.table, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Imported %d tickets, %d comments, %d users and %d admins\n",
+		len(data.Tickets), len(data.Comments), len(data.Users), len(data.Admins))
+	return nil
+}
@@ -0,0 +1,33 @@
+package cli
+
+import (
+	"fmt"
+
+	"telegram-tickets-bot/sql"
+	"telegram-tickets-bot/src/database"
+)
+
+// Create the tables of sql/sql.sql that are missing from the database and upgrade the tables of older versions
+func runMigrate(args []string) error {
+	flags := newFlagSet("migrate", "")
+	if err := parseFlags(flags, args, 0); err != nil {
+		return err
+	}
+
+	_, db, err := openDatabase()
+	if err != nil {
+		return err
+	}
+
+	changes, err := database.Migrate(db, sql.Schema)
+	for _, change := range changes {
+		fmt.Println(change)
+	}
+	if err != nil {
+		return err
+	}
+	if len(changes) == 0 {
+		fmt.Println("The database is up to date, nothing to do.")
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package cli
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"telegram-tickets-bot/src/config"
+	"telegram-tickets-bot/src/logging"
+)
+
+// How often the configuration file is checked for changes
+const configPollInterval = 5 * time.Second
+
+// Reload the configuration when its file changes or the process receives SIGHUP
+func watchConfig() {
+	path, _ := config.ResolvePath(configPath)
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+
+	ticker := time.NewTicker(configPollInterval)
+	defer ticker.Stop()
+
+	// The file is compared by content, as editors and mounted ConfigMaps do not reliably update its mtime
+	hash := fileHash(path)
+	for {
+		select {
+		case <-hangup:
+			slog.Info("Received SIGHUP, reloading configuration")
+		case <-ticker.C:
+			next := fileHash(path)
+			if bytes.Equal(next, hash) {
+				continue
+			}
+			slog.Info("Configuration file changed, reloading", "path", path)
+		}
+		hash = fileHash(path)
+		reloadConfig()
+	}
+}
+
+// Return the hash of the file's content, nil if it cannot be read
+func fileHash(path string) []byte {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	sum := sha256.Sum256(content)
+	return sum[:]
+}
+
+func reloadConfig() {
+	result, err := config.Reload(configPath)
+	if err != nil {
+		slog.Error("Rejected invalid configuration, keeping the current one", "error", err)
+		return
+	}
+	logging.Apply(config.Current().Logging)
+
+	if len(result.Applied) > 0 {
+		slog.Info("Configuration reloaded", "applied", result.Applied)
+	} else {
+		slog.Info("Configuration reloaded, no reloadable settings changed")
+	}
+	if len(result.NeedRestart) > 0 {
+		slog.Warn("Changed settings take effect after a restart", "settings", result.NeedRestart)
+	}
+}
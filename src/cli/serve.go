@@ -0,0 +1,133 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"telegram-tickets-bot/src/api"
+	"telegram-tickets-bot/src/email"
+	"telegram-tickets-bot/src/metrics"
+	"telegram-tickets-bot/src/telegram"
+	"telegram-tickets-bot/src/tickets"
+	"telegram-tickets-bot/src/web"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Run the bots of all tenants and the enabled servers until the process is stopped
+func runServe(args []string) error {
+	flags := newFlagSet("serve", "")
+	if err := parseFlags(flags, args, 0); err != nil {
+		return err
+	}
+
+	// Initialize configuration, logging and the database
+	cfg, _, err := openDatabase()
+	if err != nil {
+		return err
+	}
+
+	// Apply changes of texts, categories, SLA and notification settings without a restart
+	go watchConfig()
+
+	// Expose metrics for Prometheus
+	if cfg.Metrics.Listen != "" {
+		go func() {
+			fatal("Metrics endpoint stopped", "error", metrics.ListenAndServe(cfg.Metrics.Listen))
+		}()
+	}
+
+	// Create one Bot instance per tenant
+	bots := make([]*telegram.Bot, 0, len(cfg.Tenants))
+	for i := range cfg.Tenants {
+		bot, err := telegram.NewBot(&cfg.Tenants[i])
+		if err != nil {
+			return fmt.Errorf("[ERROR] Failed to create bot of tenant %s: %v", cfg.Tenants[i].Key, err)
+		}
+		bots = append(bots, bot)
+	}
+
+	// Serve the REST API of all tenants
+	if cfg.API.Listen != "" {
+		server := api.NewServer(cfg)
+		go func() {
+			fatal("REST API stopped", "error", server.ListenAndServe(cfg.API.Listen))
+		}()
+	}
+
+	// Receive the mail of tenants with an email channel
+	for i := range cfg.Tenants {
+		if cfg.Tenants[i].Email.Listen == "" {
+			continue
+		}
+		server := email.NewServer(&cfg.Tenants[i])
+		go func(tenant string) {
+			fatal("Email channel stopped", "tenant", tenant, "error", server.ListenAndServe())
+		}(cfg.Tenants[i].Key)
+	}
+
+	// Serve the admin dashboard of tenants that enable it
+	for i, bot := range bots {
+		if cfg.Tenants[i].Web.Listen == "" {
+			continue
+		}
+		server, err := web.NewServer(&cfg.Tenants[i], bot.Username())
+		if err != nil {
+			return err
+		}
+		go func(tenant string) {
+			fatal("Admin dashboard stopped", "tenant", tenant, "error", server.ListenAndServe())
+		}(cfg.Tenants[i].Key)
+	}
+
+	// Stop polling on SIGINT or SIGTERM and let the bots finish the updates in progress
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var wg sync.WaitGroup
+	for _, bot := range bots {
+		// Register command menus for users and admins, re-syncing as admins change
+		bot.StartCommandSync(10 * time.Minute)
+
+		// Deliver notification digests and notifications held during quiet hours
+		bot.StartNotificationDigests()
+
+		// Send the scheduled daily and weekly admin digests
+		bot.StartAdminDigests()
+
+		// Deliver ticket events to webhooks, retrying failed deliveries
+		bot.StartWebhookDeliveries()
+
+		// Set update configuration
+		u := tgbotapi.NewUpdate(0)
+		u.Timeout = 60
+
+		// Get update channel
+		updates := bot.GetUpdatesChan(ctx, u)
+
+		// Handle updates
+		wg.Add(1)
+		go func(bot *telegram.Bot) {
+			defer wg.Done()
+			bot.HandleUpdates(updates)
+		}(bot)
+	}
+	wg.Wait()
+
+	// Let the subscribers handle the events of the last updates
+	tickets.Flush()
+	slog.Info("Stopped")
+	return nil
+}
+
+// Log an error that the bot cannot run on with and exit
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
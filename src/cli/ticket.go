@@ -0,0 +1,192 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"telegram-tickets-bot/src/config"
+	"telegram-tickets-bot/src/database"
+	"telegram-tickets-bot/src/telegram"
+	"telegram-tickets-bot/src/tickets"
+
+	"gorm.io/gorm"
+)
+
+func runTicket(args []string) error {
+	return runGroup("ticket", args, []command{
+		{"list", "List the tickets of a tenant", runTicketList},
+		{"show", "Show a ticket with its comments and history", runTicketShow},
+		{"close", "Close a ticket", runTicketClose},
+	})
+}
+
+func runTicketList(args []string) error {
+	flags := newFlagSet("ticket list", "[flags]")
+	tenantKey := flags.String("tenant", config.DefaultTenant, "Tenant key")
+	status := flags.String("status", "open", "Ticket status: open, closed or all")
+	priority := flags.String("priority", "", "Only tickets of this priority")
+	category := flags.String("category", "", "Only tickets of this category")
+	query := flags.String("q", "", "Ticket ID or keyword in the title or description")
+	limit := flags.Int("limit", 50, "Maximum number of tickets listed")
+	if err := parseFlags(flags, args, 0); err != nil {
+		return err
+	}
+
+	cfg, db, err := openDatabase()
+	if err != nil {
+		return err
+	}
+	tenant, err := findTenant(cfg, *tenantKey)
+	if err != nil {
+		return err
+	}
+
+	filter := tickets.TicketFilter{Priority: *priority, Category: *category, Query: *query}
+	if *status != "all" {
+		filter.Status = *status
+	}
+	found, err := tickets.ListTickets(db, tenant.Key, filter, 0, *limit)
+	if err != nil {
+		return fmt.Errorf("[ERROR] Failed to list tickets: %v", err)
+	}
+	admins, err := database.GetAdmins(db, tenant.Key)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tSTATUS\tPRIORITY\tCATEGORY\tASSIGNEE\tUPDATED\tTITLE")
+	for _, ticket := range found {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t%s\n", ticket.TicketID, ticket.Status, ticket.Priority, ticket.Category,
+			adminName(admins, ticket.AssignedTo), ticket.UpdatedAt.Format("2006-01-02 15:04"), ticket.Title)
+	}
+	return w.Flush()
+}
+
+func adminName(admins []database.AdminUser, adminID *int) string {
+	if adminID == nil {
+		return "-"
+	}
+	for _, admin := range admins {
+		if admin.AdminID == *adminID {
+			return admin.FullName
+		}
+	}
+	return fmt.Sprintf("#%d", *adminID)
+}
+
+// Parse the ticket ID argument and load the ticket of the tenant
+func loadTicket(db *gorm.DB, tenant string, arg string) (*tickets.Ticket, error) {
+	ticketID, err := strconv.Atoi(arg)
+	if err != nil {
+		return nil, fmt.Errorf("[ERROR] Invalid ticket ID %q", arg)
+	}
+	ticket, err := tickets.GetTicketByID(db, tenant, ticketID)
+	if err == gorm.ErrRecordNotFound {
+		return nil, fmt.Errorf("[ERROR] Ticket %d does not exist in tenant %s", ticketID, tenant)
+	} else if err != nil {
+		return nil, fmt.Errorf("[ERROR] Failed to get ticket: %v", err)
+	}
+	return ticket, nil
+}
+
+func runTicketShow(args []string) error {
+	flags := newFlagSet("ticket show", "[flags] <ticket ID>")
+	tenantKey := flags.String("tenant", config.DefaultTenant, "Tenant key")
+	if err := parseFlags(flags, args, 1); err != nil {
+		return err
+	}
+
+	cfg, db, err := openDatabase()
+	if err != nil {
+		return err
+	}
+	tenant, err := findTenant(cfg, *tenantKey)
+	if err != nil {
+		return err
+	}
+	ticket, err := loadTicket(db, tenant.Key, flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	admins, err := database.GetAdmins(db, tenant.Key)
+	if err != nil {
+		return err
+	}
+	comments, err := tickets.GetTicketComments(db, ticket.TicketID)
+	if err != nil {
+		return err
+	}
+	history, err := tickets.GetTicketHistory(db, ticket.TicketID)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Ticket #%d: %s\n", ticket.TicketID, ticket.Title)
+	fmt.Printf("Status: %s  Priority: %s  Category: %s  Channel: %s\n", ticket.Status, ticket.Priority, ticket.Category, ticket.Channel)
+	fmt.Printf("Created by user %d at %s, assigned to %s\n", ticket.CreatedBy, ticket.CreatedAt.Format("2006-01-02 15:04"), adminName(admins, ticket.AssignedTo))
+	fmt.Printf("\n%s\n", ticket.Description)
+
+	fmt.Printf("\nComments (%d):\n", len(comments))
+	for _, comment := range comments {
+		author := "user"
+		if comment.AdminID != nil {
+			author = "staff " + adminName(admins, comment.AdminID)
+		}
+		edited := ""
+		if comment.EditedAt != nil {
+			edited = " (edited)"
+		}
+		fmt.Printf("\n[%s] %s%s:\n%s\n", comment.CreatedAt.Format("2006-01-02 15:04"), author, edited, comment.Content)
+	}
+
+	if len(history) > 0 {
+		fmt.Printf("\nHistory:\n")
+		for _, entry := range history {
+			fmt.Printf("  %s  %s %s\n", entry.CreatedAt.Format("2006-01-02 15:04"), entry.Action, entry.Details)
+		}
+	}
+	return nil
+}
+
+func runTicketClose(args []string) error {
+	flags := newFlagSet("ticket close", "[flags] <ticket ID>")
+	tenantKey := flags.String("tenant", config.DefaultTenant, "Tenant key")
+	if err := parseFlags(flags, args, 1); err != nil {
+		return err
+	}
+
+	cfg, db, err := openDatabase()
+	if err != nil {
+		return err
+	}
+	tenant, err := findTenant(cfg, *tenantKey)
+	if err != nil {
+		return err
+	}
+	ticket, err := loadTicket(db, tenant.Key, flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	if ticket.Status == "closed" {
+		fmt.Printf("Ticket #%d is already closed\n", ticket.TicketID)
+		return nil
+	}
+
+	// The tenant's bot announces the change in Telegram like any other, and queues its webhook deliveries
+	// for the running bot to deliver
+	if _, err := telegram.NewBot(tenant); err != nil {
+		return fmt.Errorf("[ERROR] Failed to connect the bot of tenant %s: %v", tenant.Key, err)
+	}
+
+	if err := tickets.CloseTicket(db, ticket.TicketID, 0); err != nil {
+		return err
+	}
+	// Wait for the history entry, the announcement and the queued webhook deliveries
+	tickets.Flush()
+	fmt.Printf("Closed ticket #%d\n", ticket.TicketID)
+	return nil
+}
@@ -1,16 +1,113 @@
 package config
 
 import (
+	"errors"
 	"fmt"
+	"io/fs"
+	"os"
 	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
 
 	"github.com/BurntSushi/toml"
 )
 
-type Config struct {
+// Key of the tenant configured by the top-level bot settings of a single-bot deployment
+const DefaultTenant = "default"
+
+// Ticket category that users can pick, e.g. through a new_<key> start link
+type Category struct {
+	Key  string `toml:"key"`
+	Name string `toml:"name"`
+}
+
+// Webhook subscribes a URL to ticket events. Deliveries are signed with the secret.
+type Webhook struct {
+	URL    string `toml:"url"`
+	Secret string `toml:"secret"`
+	// Events delivered to the URL, e.g. "ticket.created"; empty for all events
+	Events []string `toml:"events"`
+}
+
+// Category and tenant keys are used in start parameters, callback data and database columns
+var keyPattern = regexp.MustCompile(`^[a-z0-9-]{1,32}$`)
+
+// Tenant is one bot with its own token, admins, categories and texts. All tenants share one database
+// and every row belongs to exactly one tenant.
+type Tenant struct {
+	Key      string `toml:"key"`
 	Telegram struct {
-		BotToken string `toml:"bot_token"`
+		BotToken            string `toml:"bot_token"`
+		ConversationTimeout int    `toml:"conversation_timeout"`
 	} `toml:"Telegram"`
+	Forum struct {
+		ChatID          int64  `toml:"chat_id"`
+		OpenIconEmoji   string `toml:"open_icon_emoji"`
+		ClosedIconEmoji string `toml:"closed_icon_emoji"`
+	} `toml:"Forum"`
+	DeepLink struct {
+		Secret string `toml:"secret"`
+	} `toml:"DeepLink"`
+	Notifications struct {
+		// Minutes between digests of notifications set to digest mode
+		DigestInterval int `toml:"digest_interval"`
+	} `toml:"Notifications"`
+	Digest struct {
+		// Server time (HH:MM) of the daily admin digest, empty to disable it
+		DailyTime string `toml:"daily_time"`
+		// Weekday and time of the weekly admin digest, which replaces that day's daily digest
+		WeeklyDay  string `toml:"weekly_day"`
+		WeeklyTime string `toml:"weekly_time"`
+		// Hours a ticket may wait on staff before it counts as an SLA breach
+		SLAHours int `toml:"sla_hours"`
+	} `toml:"Digest"`
+	Email struct {
+		// Address the inbound SMTP listener binds to, e.g. ":2525"; empty disables the email channel
+		Listen string `toml:"listen"`
+		// Support address that inbound mail is accepted for and replies are sent from
+		Address string `toml:"address"`
+		// Server that replies are sent through
+		SMTPHost     string `toml:"smtp_host"`
+		SMTPPort     int    `toml:"smtp_port"`
+		SMTPUsername string `toml:"smtp_username"`
+		SMTPPassword string `toml:"smtp_password"`
+	} `toml:"Email"`
+	Web struct {
+		// Address the admin dashboard listens on, e.g. "127.0.0.1:8081"; empty disables it
+		Listen string `toml:"listen"`
+		// Hours an admin stays signed in to the dashboard
+		SessionHours int `toml:"session_hours"`
+	} `toml:"Web"`
+	Categories []Category `toml:"Categories"`
+	Webhooks   []Webhook  `toml:"Webhooks"`
+	// Overrides of the bot's texts by name, e.g. "help"
+	Texts map[string]string `toml:"Texts"`
+}
+
+// Logging configures the structured log output
+type Logging struct {
+	// Least severe level logged: debug, info, warn or error
+	Level string `toml:"level"`
+	// Output format: text or json
+	Format string `toml:"format"`
+	// Log the contents of messages and comments instead of redacting them, for debugging only
+	LogContent bool `toml:"log_content"`
+}
+
+type Config struct {
+	// Bot of a single-bot deployment; ignored when Tenants are configured
+	Tenant
+	Tenants []Tenant `toml:"Tenants"`
+	API     struct {
+		// Address the REST API listens on, e.g. "127.0.0.1:8080"; empty disables the API
+		Listen string `toml:"listen"`
+	} `toml:"API"`
+	Metrics struct {
+		// Address /metrics is served on for Prometheus, e.g. "127.0.0.1:9090"; empty disables it
+		Listen string `toml:"listen"`
+	} `toml:"Metrics"`
+	Logging  Logging `toml:"Logging"`
 	Database struct {
 		Host     string `toml:"host"`
 		Port     int    `toml:"port"`
@@ -20,21 +117,72 @@ type Config struct {
 	} `toml:"Database"`
 }
 
-func InitializationConfig() (Config, error) {
+// Path of the configuration file when neither --config nor TTB_CONFIG name one
+const DefaultPath = "config.toml"
+
+// InitializationConfig loads the configuration file at path, applies the TTB_* environment variables
+// on top and validates the result, which then is the configuration in effect (see Current). An empty path
+// means TTB_CONFIG or config.toml, which may be missing when the environment configures everything.
+func InitializationConfig(path string) (Config, error) {
+	config, err := load(path)
+	if err != nil {
+		return config, err
+	}
+	Store(config)
+	return config, nil
+}
+
+// ResolvePath returns the path of the configuration file and whether it must exist
+func ResolvePath(path string) (string, bool) {
+	if path == "" {
+		path = os.Getenv(envPrefix + "_CONFIG")
+	}
+	if path == "" {
+		return DefaultPath, false
+	}
+	return path, true
+}
+
+func load(path string) (Config, error) {
 	var config Config
-	configPath, err := filepath.Abs("config.toml")
+
+	path, required := ResolvePath(path)
+	configPath, err := filepath.Abs(path)
 	if err != nil {
 		return config, fmt.Errorf("[ERROR] Failed to get config file path: %w", err)
 	}
 
-	_, err = toml.DecodeFile(configPath, &config)
-	if err != nil {
+	if _, err := toml.DecodeFile(configPath, &config); errors.Is(err, fs.ErrNotExist) && !required {
+		// Configured by the environment alone
+	} else if err != nil {
 		return config, fmt.Errorf("[ERROR] Failed to parse config file: %w", err)
 	}
 
-	if config.Telegram.BotToken == "" {
-		return config, fmt.Errorf("[ERROR] Telegram Bot Token not set in config file")
+	if err := applyEnv(&config); err != nil {
+		return config, err
+	}
+
+	if len(config.Tenants) == 0 {
+		config.Tenants = []Tenant{config.Tenant}
+		if config.Tenants[0].Key == "" {
+			config.Tenants[0].Key = DefaultTenant
+		}
+	} else if config.Telegram.BotToken != "" {
+		return config, fmt.Errorf("[ERROR] Set the bot token either in [Telegram] or in [[Tenants]], not both")
 	}
 
+	if err := config.validate(); err != nil {
+		return config, err
+	}
 	return config, nil
 }
+
+// ParseWeekday parses an English weekday name such as "monday"
+func ParseWeekday(name string) (time.Weekday, bool) {
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		if strings.EqualFold(day.String(), name) {
+			return day, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,160 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Prefix of the environment variables that override configuration settings
+const envPrefix = "TTB"
+
+// Apply the environment variables that override settings of the configuration file. Every setting has a
+// variable named after its path, e.g. TTB_DATABASE_PASSWORD for password in [Database]; entries of
+// [[Tenants]] and [[Categories]] are named by their key (TTB_TENANTS_SHOP_TELEGRAM_BOT_TOKEN), other
+// lists by their index (TTB_WEBHOOKS_0_SECRET). Entries beyond those of the file are added by index, up to
+// the highest one used (TTB_CATEGORIES_2_KEY adds a third category). A variable with the suffix _FILE names
+// a file to read the value from, for secrets mounted into a container. Lists of strings are separated by commas.
+func applyEnv(config *Config) error {
+	return applyEnvValue(envPrefix, reflect.ValueOf(config).Elem())
+}
+
+func applyEnvValue(name string, value reflect.Value) error {
+	switch value.Kind() {
+	case reflect.Struct:
+		for i := 0; i < value.NumField(); i++ {
+			field := value.Type().Field(i)
+			if field.Anonymous {
+				// Settings of the embedded single-bot tenant are top-level
+				if err := applyEnvValue(name, value.Field(i)); err != nil {
+					return err
+				}
+				continue
+			}
+			tag, _, _ := strings.Cut(field.Tag.Get("toml"), ",")
+			if tag == "" || tag == "-" {
+				continue
+			}
+			if err := applyEnvValue(name+"_"+envName(tag), value.Field(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case reflect.Slice:
+		if value.Type().Elem().Kind() == reflect.String {
+			raw, ok, err := lookupEnv(name)
+			if !ok || err != nil {
+				return err
+			}
+			var items []string
+			for _, item := range strings.Split(raw, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					items = append(items, item)
+				}
+			}
+			value.Set(reflect.ValueOf(items))
+			return nil
+		}
+		suffixes := make(map[string]bool)
+		for i := 0; i < value.Len(); i++ {
+			element := value.Index(i)
+			suffix := strconv.Itoa(i)
+			if key := element.FieldByName("Key"); key.IsValid() && key.String() != "" {
+				suffix = envName(key.String())
+			}
+			suffixes[suffix] = true
+			if err := applyEnvValue(name+"_"+suffix, element); err != nil {
+				return err
+			}
+		}
+		for i := value.Len(); i <= highestEnvIndex(name, suffixes); i++ {
+			value.Set(reflect.Append(value, reflect.New(value.Type().Elem()).Elem()))
+			if err := applyEnvValue(name+"_"+strconv.Itoa(i), value.Index(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case reflect.Map:
+		// Every variable under the map's prefix sets the entry of its lowercased rest, e.g. TTB_TEXTS_HELP
+		for _, entry := range os.Environ() {
+			key, _, _ := strings.Cut(entry, "=")
+			rest, ok := strings.CutPrefix(key, name+"_")
+			if !ok || rest == "" {
+				continue
+			}
+			rest, _ = strings.CutSuffix(rest, "_FILE")
+			raw, _, err := lookupEnv(name + "_" + rest)
+			if err != nil {
+				return err
+			}
+			if value.IsNil() {
+				value.Set(reflect.MakeMap(value.Type()))
+			}
+			value.SetMapIndex(reflect.ValueOf(strings.ToLower(rest)), reflect.ValueOf(raw))
+		}
+		return nil
+	}
+
+	raw, ok, err := lookupEnv(name)
+	if !ok || err != nil {
+		return err
+	}
+	switch value.Kind() {
+	case reflect.String:
+		value.SetString(raw)
+	case reflect.Int, reflect.Int64:
+		parsed, err := strconv.ParseInt(strings.TrimSpace(raw), 10, 64)
+		if err != nil {
+			return fmt.Errorf("[ERROR] Invalid %s %q: must be an integer", name, raw)
+		}
+		value.SetInt(parsed)
+	case reflect.Bool:
+		parsed, err := strconv.ParseBool(strings.TrimSpace(raw))
+		if err != nil {
+			return fmt.Errorf("[ERROR] Invalid %s %q: must be true or false", name, raw)
+		}
+		value.SetBool(parsed)
+	}
+	return nil
+}
+
+// Return the highest list index that variables under the prefix name use, e.g. 2 for TTB_WEBHOOKS_2_URL, or -1
+// if there is none. Suffixes of existing entries, such as keys that are numbers, are not indexes.
+func highestEnvIndex(name string, suffixes map[string]bool) int {
+	highest := -1
+	for _, entry := range os.Environ() {
+		key, _, _ := strings.Cut(entry, "=")
+		rest, ok := strings.CutPrefix(key, name+"_")
+		if !ok {
+			continue
+		}
+		suffix, _, _ := strings.Cut(rest, "_")
+		if index, err := strconv.Atoi(suffix); err == nil && index > highest && !suffixes[suffix] {
+			highest = index
+		}
+	}
+	return highest
+}
+
+// Return the value of the variable, or the content of the file named by its _FILE variant
+func lookupEnv(name string) (string, bool, error) {
+	if path, ok := os.LookupEnv(name + "_FILE"); ok {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return "", false, fmt.Errorf("[ERROR] Failed to read %s_FILE: %v", name, err)
+		}
+		// Files usually end with a newline that is not part of the secret
+		return strings.TrimRight(string(content), "\r\n"), true, nil
+	}
+	value, ok := os.LookupEnv(name)
+	return value, ok, nil
+}
+
+// Return the form of a setting name or key used in variable names, e.g. BOT_TOKEN for bot_token
+func envName(name string) string {
+	return strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
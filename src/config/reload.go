@@ -0,0 +1,141 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync/atomic"
+)
+
+// Configuration in effect, replaced as a whole by a reload so that readers always see consistent settings
+var current atomic.Pointer[Config]
+
+// Store makes the configuration the one in effect
+func Store(config Config) {
+	current.Store(&config)
+}
+
+// Current returns the configuration in effect. It must not be modified.
+func Current() *Config {
+	return current.Load()
+}
+
+// CurrentTenant returns the settings in effect of the tenant, nil if it is not configured
+func CurrentTenant(key string) *Tenant {
+	config := Current()
+	if config == nil {
+		return nil
+	}
+	for i := range config.Tenants {
+		if config.Tenants[i].Key == key {
+			return &config.Tenants[i]
+		}
+	}
+	return nil
+}
+
+// ReloadResult lists the settings that changed in a reload, named by their path in the file
+type ReloadResult struct {
+	// Settings now in effect
+	Applied []string
+	// Settings that keep their previous value until the process restarts
+	NeedRestart []string
+}
+
+// Reload loads the configuration again like InitializationConfig and applies the settings that can change at
+// runtime: the texts, categories, digest schedule, SLA hours and notification settings of every tenant and
+// the log level. An invalid configuration is rejected as a whole and the configuration in effect stays as it is.
+func Reload(path string) (*ReloadResult, error) {
+	loaded, err := load(path)
+	if err != nil {
+		return nil, err
+	}
+	previous := Current()
+	if previous == nil {
+		Store(loaded)
+		return &ReloadResult{}, nil
+	}
+
+	next := *previous
+	next.Tenants = make([]Tenant, len(previous.Tenants))
+	copy(next.Tenants, previous.Tenants)
+	for i := range next.Tenants {
+		for _, tenant := range loaded.Tenants {
+			if tenant.Key == next.Tenants[i].Key {
+				applyReloadable(&next.Tenants[i], &tenant)
+			}
+		}
+	}
+	next.Logging.Level = loaded.Logging.Level
+	next.Logging.LogContent = loaded.Logging.LogContent
+
+	result := &ReloadResult{}
+	diffSettings("", reflect.ValueOf(*previous), reflect.ValueOf(next), &result.Applied)
+	// What still differs from the file after applying is only picked up by a restart
+	diffSettings("", reflect.ValueOf(next), reflect.ValueOf(loaded), &result.NeedRestart)
+
+	current.Store(&next)
+	return result, nil
+}
+
+// Copy the settings that can change at runtime from the reloaded tenant
+func applyReloadable(tenant *Tenant, reloaded *Tenant) {
+	tenant.Texts = reloaded.Texts
+	tenant.Categories = reloaded.Categories
+	tenant.Digest = reloaded.Digest
+	tenant.Notifications = reloaded.Notifications
+}
+
+// Append the paths of the settings that differ between a and b, e.g. "Database.password" or
+// "Tenants.shop.Texts". Tenants are compared by key; the top-level bot settings are compared through
+// the tenant they configure.
+func diffSettings(name string, a reflect.Value, b reflect.Value, out *[]string) {
+	switch a.Kind() {
+	case reflect.Struct:
+		for i := 0; i < a.NumField(); i++ {
+			field := a.Type().Field(i)
+			tag, _, _ := strings.Cut(field.Tag.Get("toml"), ",")
+			if field.Anonymous || tag == "" || tag == "-" {
+				continue
+			}
+			diffSettings(joinPath(name, tag), a.Field(i), b.Field(i), out)
+		}
+		return
+
+	case reflect.Slice:
+		if name == "Tenants" {
+			if tenantKeys(a) != tenantKeys(b) {
+				*out = append(*out, fmt.Sprintf("Tenants (%s -> %s)", tenantKeys(a), tenantKeys(b)))
+				return
+			}
+			for i := 0; i < a.Len(); i++ {
+				// A single-bot deployment's settings are named like in its file
+				prefix := "Tenants." + a.Index(i).FieldByName("Key").String()
+				if a.Len() == 1 && a.Index(i).FieldByName("Key").String() == DefaultTenant {
+					prefix = ""
+				}
+				diffSettings(prefix, a.Index(i), b.Index(i), out)
+			}
+			return
+		}
+	}
+
+	if !reflect.DeepEqual(a.Interface(), b.Interface()) {
+		*out = append(*out, name)
+	}
+}
+
+func joinPath(prefix string, name string) string {
+	if prefix == "" {
+		return name
+	}
+	return prefix + "." + name
+}
+
+func tenantKeys(tenants reflect.Value) string {
+	keys := make([]string, tenants.Len())
+	for i := range keys {
+		keys[i] = tenants.Index(i).FieldByName("Key").String()
+	}
+	return strings.Join(keys, ",")
+}
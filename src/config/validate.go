@@ -0,0 +1,206 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Names of the bot texts that [Texts] can override
+var textNames = map[string]bool{"help": true, "unknown_message": true}
+
+// Longest webhook URL that webhook_deliveries.url holds
+const maxWebhookURLLength = 500
+
+// Collects the problems found in a configuration, so that all of them are reported at once
+type problems []string
+
+func (p *problems) add(format string, args ...interface{}) {
+	*p = append(*p, fmt.Sprintf(format, args...))
+}
+
+func (p problems) err() error {
+	if len(p) == 0 {
+		return nil
+	}
+	return fmt.Errorf("[ERROR] Invalid configuration:\n  - %s", strings.Join(p, "\n  - "))
+}
+
+// Check a listen address like "127.0.0.1:8080" or ":2525"; empty addresses disable their server
+func checkListen(p *problems, section string, address string) {
+	if address == "" {
+		return
+	}
+	if _, port, err := net.SplitHostPort(address); err != nil || port == "" {
+		p.add("%s invalid listen address %q, use host:port such as 127.0.0.1:8080", section, address)
+	}
+}
+
+// Check all sections and fill in defaults for optional settings
+func (c *Config) validate() error {
+	var p problems
+
+	if c.Database.Host == "" {
+		c.Database.Host = "127.0.0.1"
+	}
+	if c.Database.Port == 0 {
+		c.Database.Port = 3306
+	} else if c.Database.Port < 0 || c.Database.Port > 65535 {
+		p.add("[Database] port %d is out of range", c.Database.Port)
+	}
+	if c.Database.User == "" {
+		p.add("[Database] user is not set (TTB_DATABASE_USER)")
+	}
+	if c.Database.DBName == "" {
+		p.add("[Database] dbname is not set (TTB_DATABASE_DBNAME)")
+	}
+
+	checkListen(&p, "[API]", c.API.Listen)
+	checkListen(&p, "[Metrics]", c.Metrics.Listen)
+	c.Logging.validate(&p)
+
+	keys := make(map[string]bool)
+	tokens := make(map[string]bool)
+	for i := range c.Tenants {
+		tenant := &c.Tenants[i]
+		tenant.validate(&p)
+		if keys[tenant.Key] {
+			p.add("duplicate tenant key %q", tenant.Key)
+		}
+		if tokens[tenant.Telegram.BotToken] && tenant.Telegram.BotToken != "" {
+			p.add("tenant %q uses the bot token of another tenant", tenant.Key)
+		}
+		keys[tenant.Key] = true
+		tokens[tenant.Telegram.BotToken] = true
+	}
+
+	return p.err()
+}
+
+// Check the logging settings and fill in defaults
+func (l *Logging) validate(p *problems) {
+	l.Level = strings.ToLower(l.Level)
+	switch l.Level {
+	case "":
+		l.Level = "info"
+	case "debug", "info", "warn", "error":
+	default:
+		p.add("[Logging] invalid level %q, use debug, info, warn or error", l.Level)
+	}
+
+	l.Format = strings.ToLower(l.Format)
+	switch l.Format {
+	case "":
+		l.Format = "text"
+	case "text", "json":
+	default:
+		p.add("[Logging] invalid format %q, use text or json", l.Format)
+	}
+}
+
+// Check the tenant's settings and fill in defaults
+func (t *Tenant) validate(p *problems) {
+	if !keyPattern.MatchString(t.Key) {
+		p.add("invalid tenant key %q, use 1-32 lowercase letters, digits or dashes", t.Key)
+	}
+	// Problems of the tenants of a multi-bot deployment name the tenant
+	section := func(name string) string {
+		if t.Key == DefaultTenant {
+			return "[" + name + "]"
+		}
+		return fmt.Sprintf("tenant %q [%s]", t.Key, name)
+	}
+
+	if t.Telegram.BotToken == "" {
+		p.add("%s bot_token is not set", section("Telegram"))
+	} else if id, _, ok := strings.Cut(t.Telegram.BotToken, ":"); !ok || id == "" {
+		p.add("%s bot_token does not look like a token from @BotFather (<bot ID>:<secret>)", section("Telegram"))
+	}
+	if t.Telegram.ConversationTimeout <= 0 {
+		t.Telegram.ConversationTimeout = 10
+	}
+
+	if t.Forum.ChatID > 0 {
+		p.add("%s chat_id %d is not a supergroup ID, which is negative", section("Forum"), t.Forum.ChatID)
+	}
+
+	if t.Notifications.DigestInterval <= 0 {
+		t.Notifications.DigestInterval = 60
+	}
+
+	if t.Digest.SLAHours <= 0 {
+		t.Digest.SLAHours = 24
+	}
+	for _, clock := range []string{t.Digest.DailyTime, t.Digest.WeeklyTime} {
+		if _, err := time.Parse("15:04", clock); clock != "" && err != nil {
+			p.add("%s invalid time %q, use HH:MM", section("Digest"), clock)
+		}
+	}
+	if t.Digest.WeeklyDay != "" {
+		if _, ok := ParseWeekday(t.Digest.WeeklyDay); !ok || t.Digest.WeeklyTime == "" {
+			p.add("%s invalid weekly digest, set weekly_day (monday..sunday) and weekly_time", section("Digest"))
+		}
+	}
+
+	categories := make(map[string]bool)
+	for i := range t.Categories {
+		category := &t.Categories[i]
+		if !keyPattern.MatchString(category.Key) {
+			p.add("%s invalid key %q, use 1-32 lowercase letters, digits or dashes", section("Categories"), category.Key)
+		}
+		if categories[category.Key] {
+			p.add("%s duplicate key %q", section("Categories"), category.Key)
+		}
+		categories[category.Key] = true
+		if category.Name == "" {
+			category.Name = category.Key
+		}
+	}
+
+	checkListen(p, section("Email"), t.Email.Listen)
+	if t.Email.Listen != "" {
+		if address, err := mail.ParseAddress(t.Email.Address); err != nil {
+			p.add("%s invalid address %q: %v", section("Email"), t.Email.Address, err)
+		} else {
+			t.Email.Address = address.Address
+		}
+		if t.Email.SMTPHost == "" {
+			p.add("%s smtp_host is not set", section("Email"))
+		}
+		if t.Email.SMTPPort <= 0 {
+			t.Email.SMTPPort = 25
+		}
+	}
+
+	checkListen(p, section("Web"), t.Web.Listen)
+	if t.Web.SessionHours <= 0 {
+		t.Web.SessionHours = 12
+	}
+
+	urls := make(map[string]bool)
+	for _, webhook := range t.Webhooks {
+		parsed, err := url.Parse(webhook.URL)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			p.add("%s invalid url %q, use an http or https URL", section("Webhooks"), webhook.URL)
+		}
+		if len(webhook.URL) > maxWebhookURLLength {
+			p.add("%s url %q is longer than %d bytes", section("Webhooks"), webhook.URL, maxWebhookURLLength)
+		}
+		if webhook.Secret == "" {
+			p.add("%s webhook %q has no secret", section("Webhooks"), webhook.URL)
+		}
+		if urls[webhook.URL] {
+			p.add("%s duplicate url %q", section("Webhooks"), webhook.URL)
+		}
+		urls[webhook.URL] = true
+	}
+
+	for name := range t.Texts {
+		if !textNames[name] {
+			p.add("%s unknown text %q, use help or unknown_message", section("Texts"), name)
+		}
+	}
+}
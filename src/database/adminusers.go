@@ -8,10 +8,11 @@ import (
 
 type AdminUser struct {
 	AdminID    int    `gorm:"primaryKey;column:admin_id"`
-	Username   string `gorm:"column:username"`
+	Tenant     string `gorm:"column:tenant;uniqueIndex:idx_tenant_username,priority:1;uniqueIndex:idx_tenant_telegram,priority:1"`
+	Username   string `gorm:"column:username;uniqueIndex:idx_tenant_username,priority:2"`
 	FullName   string `gorm:"column:full_name"`
 	Position   string `gorm:"column:position"`
-	TelegramID int64  `gorm:"column:telegram_id"`
+	TelegramID int64  `gorm:"column:telegram_id;uniqueIndex:idx_tenant_telegram,priority:2"`
 }
 
 func (AdminUser) TableName() string {
@@ -26,28 +27,37 @@ func GetAdminByID(db *gorm.DB, adminID int) (*AdminUser, error) {
 	return &admin, nil
 }
 
-func IsUserAdmin(telegramID int64) (bool, error) {
+func IsUserAdmin(tenant string, telegramID int64) (bool, error) {
 	db, err := InitializeDB()
 	if err != nil {
 		return false, err
 	}
 
 	var count int64
-	if err := db.Model(&AdminUser{}).Where("telegram_id = ?", telegramID).Count(&count).Error; err != nil {
+	if err := db.Model(&AdminUser{}).Where("tenant = ? AND telegram_id = ?", tenant, telegramID).Count(&count).Error; err != nil {
 		return false, err
 	}
 
 	return count > 0, nil
 }
 
-func GetAdminIDByTelegramID(db *gorm.DB, telegramID int64) (int, error) {
+func GetAdminIDByTelegramID(db *gorm.DB, tenant string, telegramID int64) (int, error) {
 	var admin AdminUser
-	if err := db.Where("telegram_id = ?", telegramID).First(&admin).Error; err != nil {
+	if err := db.Where("tenant = ? AND telegram_id = ?", tenant, telegramID).First(&admin).Error; err != nil {
 		return 0, err
 	}
 	return admin.AdminID, nil
 }
 
+// GetAdmins returns the admins of the tenant
+func GetAdmins(db *gorm.DB, tenant string) ([]AdminUser, error) {
+	var admins []AdminUser
+	if err := db.Where("tenant = ?", tenant).Order("admin_id").Find(&admins).Error; err != nil {
+		return nil, fmt.Errorf("[ERROR] Failed to fetch admin users: %v", err)
+	}
+	return admins, nil
+}
+
 func GetTelegramIDByUserID(db *gorm.DB, userID int) (int64, error) {
 	var user RegularUser
 	result := db.Select("telegram_id").Where("user_id = ?", userID).First(&user)
@@ -56,3 +66,29 @@ func GetTelegramIDByUserID(db *gorm.DB, userID int) (int64, error) {
 	}
 	return user.TelegramID, nil
 }
+
+// CreateAdmin registers an admin of the tenant; the database assigns the admin ID
+func CreateAdmin(db *gorm.DB, admin *AdminUser) error {
+	if err := db.Create(admin).Error; err != nil {
+		return fmt.Errorf("[ERROR] Failed to create admin: %v", err)
+	}
+	return nil
+}
+
+// DeleteAdmin removes an admin of the tenant, unassigning their tickets first. Admins who wrote comments or
+// appear in a ticket's history cannot be removed, as those rows keep referring to them.
+func DeleteAdmin(db *gorm.DB, tenant string, telegramID int64) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		adminID, err := GetAdminIDByTelegramID(tx, tenant, telegramID)
+		if err != nil {
+			return err
+		}
+		if err := tx.Table("tickets").Where("assigned_to = ?", adminID).Update("assigned_to", nil).Error; err != nil {
+			return fmt.Errorf("[ERROR] Failed to unassign tickets: %v", err)
+		}
+		if err := tx.Delete(&AdminUser{}, adminID).Error; err != nil {
+			return fmt.Errorf("[ERROR] Failed to delete admin: %v", err)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,115 @@
+package database
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// API token scopes
+const (
+	ScopeTicketsRead   = "tickets:read"
+	ScopeTicketsWrite  = "tickets:write"
+	ScopeCommentsWrite = "comments:write"
+)
+
+var APIScopes = []string{ScopeTicketsRead, ScopeTicketsWrite, ScopeCommentsWrite}
+
+// APIToken grants access to one tenant's data through the REST API. Only the SHA-256 hash of the token is stored.
+type APIToken struct {
+	TokenID    int        `gorm:"primaryKey;column:token_id"`
+	Tenant     string     `gorm:"column:tenant"`
+	Name       string     `gorm:"column:name"`
+	TokenHash  string     `gorm:"column:token_hash"`
+	Scopes     string     `gorm:"column:scopes"`
+	CreatedBy  int        `gorm:"column:created_by"`
+	CreatedAt  time.Time  `gorm:"column:created_at"`
+	LastUsedAt *time.Time `gorm:"column:last_used_at"`
+	RevokedAt  *time.Time `gorm:"column:revoked_at"`
+}
+
+func (APIToken) TableName() string {
+	return "api_tokens"
+}
+
+// HasScope reports whether the token was granted scope
+func (t *APIToken) HasScope(scope string) bool {
+	for _, granted := range strings.Split(t.Scopes, ",") {
+		if granted == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Prefix of generated API tokens, which makes them recognisable e.g. to secret scanners
+const apiTokenPrefix = "ttb_"
+
+// NewAPIToken generates a random API token and returns it together with its hash
+func NewAPIToken() (string, string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", fmt.Errorf("[ERROR] Failed to generate API token: %v", err)
+	}
+	token := apiTokenPrefix + hex.EncodeToString(secret)
+	return token, HashAPIToken(token), nil
+}
+
+func HashAPIToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func CreateAPIToken(db *gorm.DB, tenant string, name string, tokenHash string, scopes []string, adminID int) (*APIToken, error) {
+	token := APIToken{
+		Tenant:    tenant,
+		Name:      name,
+		TokenHash: tokenHash,
+		Scopes:    strings.Join(scopes, ","),
+		CreatedBy: adminID,
+		CreatedAt: time.Now(),
+	}
+	if err := db.Create(&token).Error; err != nil {
+		return nil, fmt.Errorf("[ERROR] Failed to create API token: %v", err)
+	}
+	return &token, nil
+}
+
+// GetAPITokenByHash returns the token with the hash unless it was revoked
+func GetAPITokenByHash(db *gorm.DB, tokenHash string) (*APIToken, error) {
+	var token APIToken
+	if err := db.Where("token_hash = ? AND revoked_at IS NULL", tokenHash).First(&token).Error; err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// GetAPITokens returns the tenant's tokens that were not revoked
+func GetAPITokens(db *gorm.DB, tenant string) ([]APIToken, error) {
+	var tokens []APIToken
+	if err := db.Where("tenant = ? AND revoked_at IS NULL", tenant).Order("token_id").Find(&tokens).Error; err != nil {
+		return nil, fmt.Errorf("[ERROR] Failed to get API tokens: %v", err)
+	}
+	return tokens, nil
+}
+
+// RevokeAPIToken revokes the tenant's token, reporting whether there was such a token
+func RevokeAPIToken(db *gorm.DB, tenant string, tokenID int) (bool, error) {
+	result := db.Model(&APIToken{}).Where("tenant = ? AND token_id = ? AND revoked_at IS NULL", tenant, tokenID).Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return false, fmt.Errorf("[ERROR] Failed to revoke API token: %v", result.Error)
+	}
+	return result.RowsAffected > 0, nil
+}
+
+func TouchAPIToken(db *gorm.DB, tokenID int) error {
+	if err := db.Model(&APIToken{}).Where("token_id = ?", tokenID).Update("last_used_at", time.Now()).Error; err != nil {
+		return fmt.Errorf("[ERROR] Failed to update API token: %v", err)
+	}
+	return nil
+}
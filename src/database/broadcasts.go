@@ -0,0 +1,89 @@
+package database
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Broadcast audiences
+const (
+	AudienceAll         = "all"
+	AudienceGroup       = "group"
+	AudienceOpenTickets = "open_tickets"
+)
+
+type Broadcast struct {
+	BroadcastID int        `gorm:"primaryKey;column:broadcast_id"`
+	Tenant      string     `gorm:"column:tenant"`
+	AdminID     int        `gorm:"column:admin_id"`
+	Audience    string     `gorm:"column:audience"`
+	UserGroup   string     `gorm:"column:user_group"`
+	Content     string     `gorm:"column:content"`
+	Status      string     `gorm:"column:status"`
+	Total       int        `gorm:"column:total"`
+	Sent        int        `gorm:"column:sent"`
+	Failed      int        `gorm:"column:failed"`
+	Blocked     int        `gorm:"column:blocked"`
+	CreatedAt   time.Time  `gorm:"column:created_at"`
+	FinishedAt  *time.Time `gorm:"column:finished_at"`
+}
+
+func (Broadcast) TableName() string {
+	return "broadcasts"
+}
+
+func CreateBroadcast(db *gorm.DB, tenant string, adminID int, audience string, userGroup string, content string, total int) (*Broadcast, error) {
+	broadcast := Broadcast{
+		Tenant:    tenant,
+		AdminID:   adminID,
+		Audience:  audience,
+		UserGroup: userGroup,
+		Content:   content,
+		Status:    "running",
+		Total:     total,
+		CreatedAt: time.Now(),
+	}
+
+	if err := db.Create(&broadcast).Error; err != nil {
+		return nil, fmt.Errorf("[ERROR] Failed to create broadcast: %v", err)
+	}
+	return &broadcast, nil
+}
+
+// SaveBroadcastProgress stores the delivery counters and status of a broadcast
+func SaveBroadcastProgress(db *gorm.DB, broadcast *Broadcast) error {
+	updates := map[string]interface{}{
+		"status":      broadcast.Status,
+		"sent":        broadcast.Sent,
+		"failed":      broadcast.Failed,
+		"blocked":     broadcast.Blocked,
+		"finished_at": broadcast.FinishedAt,
+	}
+	if err := db.Model(&Broadcast{}).Where("broadcast_id = ?", broadcast.BroadcastID).Updates(updates).Error; err != nil {
+		return fmt.Errorf("[ERROR] Failed to save broadcast progress: %v", err)
+	}
+	return nil
+}
+
+// GetBroadcastRecipients returns the Telegram IDs of the tenant's audience, skipping users who blocked the bot
+func GetBroadcastRecipients(db *gorm.DB, tenant string, audience string, userGroup string) ([]int64, error) {
+	query := db.Model(&RegularUser{}).Where("regular_users.tenant = ? AND regular_users.blocked_at IS NULL AND regular_users.telegram_id IS NOT NULL", tenant)
+
+	switch audience {
+	case AudienceAll:
+	case AudienceGroup:
+		query = query.Where("regular_users.user_group = ?", userGroup)
+	case AudienceOpenTickets:
+		query = query.Where("EXISTS (SELECT 1 FROM tickets WHERE tickets.created_by = regular_users.user_id AND tickets.status <> ?)", "closed")
+	default:
+		return nil, fmt.Errorf("[ERROR] Unknown broadcast audience: %s", audience)
+	}
+
+	var telegramIDs []int64
+	if err := query.Order("regular_users.user_id").Pluck("regular_users.telegram_id", &telegramIDs).Error; err != nil {
+		return nil, fmt.Errorf("[ERROR] Failed to get broadcast recipients: %v", err)
+	}
+	return telegramIDs, nil
+}
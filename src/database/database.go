@@ -2,7 +2,7 @@ package database
 
 import (
 	"fmt"
-	"log"
+	"log/slog"
 	"sync"
 	"telegram-tickets-bot/src/config"
 
@@ -28,6 +28,10 @@ func ConnectDatabase(cfg *config.Config) (*gorm.DB, error) {
 		return nil, err
 	}
 
+	if err := registerMetricsCallbacks(db); err != nil {
+		return nil, err
+	}
+
 	sqlDB, err := db.DB()
 	if err != nil {
 		return nil, err
@@ -54,8 +58,8 @@ func InitializeAndPrintDBInfo(cfg *config.Config) error {
 		}
 
 		stats := sqlDB.Stats()
-		log.Printf("[INFO] Database connection successful. Connection info: Max open connections: %d, Idle connections: %d, In-use connections: %d",
-			stats.MaxOpenConnections, stats.Idle, stats.InUse)
+		slog.Info("Database connection successful",
+			"max_open_connections", stats.MaxOpenConnections, "idle_connections", stats.Idle, "in_use_connections", stats.InUse)
 	})
 
 	return err
@@ -0,0 +1,261 @@
+package database
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+var createTablePattern = regexp.MustCompile(`(?i)^\s*CREATE\s+TABLE\s+(\w+)`)
+
+// A change to tables created by an earlier version of the schema. Upgrades check the database before they
+// run, so they are applied exactly once however often Migrate runs.
+type upgrade struct {
+	table       string
+	description string
+	needed      func(db *gorm.DB) (bool, error)
+	statement   string
+	// The statement changes a column that foreign keys refer to, which MySQL only allows with their checks off
+	referenced bool
+}
+
+// Add a column that the table lacks; the definition is the column's line of sql/sql.sql
+func addColumn(table string, column string, definition string) upgrade {
+	return upgrade{
+		table:       table,
+		description: "add column " + column,
+		needed: func(db *gorm.DB) (bool, error) {
+			return !db.Migrator().HasColumn(table, column), nil
+		},
+		statement: fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition),
+	}
+}
+
+// Allow NULL in a column that was NOT NULL
+func allowNull(table string, column string, definition string) upgrade {
+	return upgrade{
+		table:       table,
+		description: "allow NULL in " + column,
+		needed: func(db *gorm.DB) (bool, error) {
+			columnType, err := findColumnType(db, table, column)
+			if err != nil {
+				return false, err
+			}
+			nullable, _ := columnType.Nullable()
+			return !nullable, nil
+		},
+		statement: fmt.Sprintf("ALTER TABLE %s MODIFY %s %s", table, column, definition),
+	}
+}
+
+// Add an index, e.g. "UNIQUE KEY idx_tenant_telegram (tenant, telegram_id)"
+func addIndex(table string, name string, definition string) upgrade {
+	return upgrade{
+		table:       table,
+		description: "add index " + name,
+		needed: func(db *gorm.DB) (bool, error) {
+			return !db.Migrator().HasIndex(table, name), nil
+		},
+		statement: fmt.Sprintf("ALTER TABLE %s ADD %s", table, definition),
+	}
+}
+
+func dropIndex(table string, name string) upgrade {
+	return upgrade{
+		table:       table,
+		description: "drop index " + name,
+		needed: func(db *gorm.DB) (bool, error) {
+			return db.Migrator().HasIndex(table, name), nil
+		},
+		statement: fmt.Sprintf("ALTER TABLE %s DROP INDEX %s", table, name),
+	}
+}
+
+// Let the database assign the IDs of a table. Allocating them as MAX+1 makes concurrent inserts collide.
+func autoIncrement(table string, column string) upgrade {
+	return upgrade{
+		table:       table,
+		description: "auto-increment " + column,
+		needed: func(db *gorm.DB) (bool, error) {
+			columnType, err := findColumnType(db, table, column)
+			if err != nil {
+				return false, err
+			}
+			increments, _ := columnType.AutoIncrement()
+			return !increments, nil
+		},
+		statement:  fmt.Sprintf("ALTER TABLE %s MODIFY %s INTEGER NOT NULL AUTO_INCREMENT", table, column),
+		referenced: true,
+	}
+}
+
+func findColumnType(db *gorm.DB, table string, column string) (gorm.ColumnType, error) {
+	columnTypes, err := db.Migrator().ColumnTypes(table)
+	if err != nil {
+		return nil, err
+	}
+	for _, columnType := range columnTypes {
+		if columnType.Name() == column {
+			return columnType, nil
+		}
+	}
+	return nil, fmt.Errorf("column %s.%s does not exist", table, column)
+}
+
+// Upgrades of the tables of older versions, in the order they are applied. Columns come before the
+// indexes that use them.
+var upgrades = []upgrade{
+	// Multiple tenants, whose bots write concurrently
+	autoIncrement("admin_users", "admin_id"),
+	addColumn("admin_users", "tenant", "VARCHAR(32) NOT NULL DEFAULT 'default' AFTER admin_id"),
+	dropIndex("admin_users", "username"),
+	dropIndex("admin_users", "telegram_id"),
+	addIndex("admin_users", "idx_tenant_username", "UNIQUE KEY idx_tenant_username (tenant, username)"),
+	addIndex("admin_users", "idx_tenant_telegram", "UNIQUE KEY idx_tenant_telegram (tenant, telegram_id)"),
+
+	autoIncrement("regular_users", "user_id"),
+	addColumn("regular_users", "tenant", "VARCHAR(32) NOT NULL DEFAULT 'default' AFTER user_id"),
+	// Users reached by email have no Telegram ID
+	allowNull("regular_users", "telegram_id", "BIGINT NULL"),
+	addColumn("regular_users", "email", "VARCHAR(255) NULL AFTER telegram_id"),
+	addColumn("regular_users", "blocked_at", "TIMESTAMP NULL"),
+	addColumn("regular_users", "source", "VARCHAR(64) NULL"),
+	addColumn("regular_users", "source_at", "TIMESTAMP NULL"),
+	dropIndex("regular_users", "telegram_id"),
+	addIndex("regular_users", "idx_tenant_telegram", "UNIQUE KEY idx_tenant_telegram (tenant, telegram_id)"),
+	addIndex("regular_users", "idx_tenant_email", "UNIQUE KEY idx_tenant_email (tenant, email)"),
+
+	addColumn("tickets", "tenant", "VARCHAR(32) NOT NULL DEFAULT 'default' AFTER ticket_id"),
+	addColumn("tickets", "category", "VARCHAR(32) NOT NULL DEFAULT '' AFTER priority"),
+	addColumn("tickets", "channel", "VARCHAR(10) NOT NULL DEFAULT 'telegram' AFTER category"),
+	addIndex("tickets", "idx_tenant_status", "INDEX idx_tenant_status (tenant, status)"),
+
+	// Messages that comments were written in, and their edits
+	addColumn("ticket_comments", "chat_id", "BIGINT NOT NULL DEFAULT 0 AFTER admin_id"),
+	addColumn("ticket_comments", "message_id", "INTEGER NOT NULL DEFAULT 0 AFTER chat_id"),
+	addColumn("ticket_comments", "edited_at", "TIMESTAMP NULL AFTER created_at"),
+	addIndex("ticket_comments", "idx_chat_message", "INDEX idx_chat_message (chat_id, message_id)"),
+
+	autoIncrement("broadcasts", "broadcast_id"),
+
+	// Comment page of ticket cards
+	addColumn("ticket_messages", "page", "INTEGER NOT NULL DEFAULT 0 AFTER viewer_id"),
+
+	// Notifications queued for several recipients at once, and SLA warnings
+	autoIncrement("pending_notifications", "notification_id"),
+	addColumn("tickets", "sla_warned_at", "TIMESTAMP NULL AFTER updated_at"),
+
+	// Tickets, comments and tokens created concurrently through the HTTP API
+	autoIncrement("tickets", "ticket_id"),
+	autoIncrement("ticket_comments", "comment_id"),
+	autoIncrement("ticket_comment_edits", "edit_id"),
+	autoIncrement("ticket_history", "history_id"),
+	autoIncrement("api_tokens", "token_id"),
+	autoIncrement("webhook_deliveries", "delivery_id"),
+}
+
+// Migrate brings the database up to the schema and returns what it changed. The schema is a list of
+// CREATE TABLE statements like sql/sql.sql: missing tables are created from it, and the tables of older
+// versions get the columns and indexes added since.
+func Migrate(db *gorm.DB, schema string) ([]string, error) {
+	var changes []string
+	for _, statement := range schemaStatements(schema) {
+		match := createTablePattern.FindStringSubmatch(statement)
+		if match == nil {
+			return changes, fmt.Errorf("[ERROR] Unsupported schema statement: %.60s", statement)
+		}
+		table := match[1]
+		if db.Migrator().HasTable(table) {
+			continue
+		}
+		if err := db.Exec(statement).Error; err != nil {
+			return changes, fmt.Errorf("[ERROR] Failed to create table %s: %v", table, err)
+		}
+		changes = append(changes, "create table "+table)
+	}
+
+	for _, upgrade := range upgrades {
+		needed, err := upgrade.needed(db)
+		if err != nil {
+			return changes, fmt.Errorf("[ERROR] Failed to check %s: %s: %v", upgrade.table, upgrade.description, err)
+		}
+		if !needed {
+			continue
+		}
+		if err := upgrade.apply(db); err != nil {
+			return changes, fmt.Errorf("[ERROR] Failed to %s of %s: %v", upgrade.description, upgrade.table, err)
+		}
+		changes = append(changes, upgrade.table+": "+upgrade.description)
+	}
+	return changes, nil
+}
+
+func (u upgrade) apply(db *gorm.DB) error {
+	if !u.referenced {
+		return db.Exec(u.statement).Error
+	}
+	// The setting is per connection, so all statements run on the same one
+	return db.Connection(func(conn *gorm.DB) error {
+		if err := conn.Exec("SET FOREIGN_KEY_CHECKS = 0").Error; err != nil {
+			return err
+		}
+		defer conn.Exec("SET FOREIGN_KEY_CHECKS = 1")
+		return conn.Exec(u.statement).Error
+	})
+}
+
+// Split a schema into its statements at the semicolons outside of quotes and comments, dropping the comments
+func schemaStatements(schema string) []string {
+	var statements []string
+	var statement strings.Builder
+	var quote byte
+	for i := 0; i < len(schema); i++ {
+		c := schema[i]
+		switch {
+		case quote != 0:
+			// A quote is escaped by a backslash or by doubling it
+			if c == '\\' && i+1 < len(schema) {
+				statement.WriteByte(c)
+				i++
+				c = schema[i]
+			} else if c == quote {
+				if i+1 < len(schema) && schema[i+1] == quote {
+					statement.WriteByte(c)
+					i++
+				} else {
+					quote = 0
+				}
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == '-' && strings.HasPrefix(schema[i:], "--"), c == '#':
+			end := strings.IndexByte(schema[i:], '\n')
+			if end < 0 {
+				end = len(schema) - i
+			}
+			i += end - 1
+			continue
+		case c == '/' && strings.HasPrefix(schema[i:], "/*"):
+			end := strings.Index(schema[i+2:], "*/")
+			if end < 0 {
+				end = len(schema) - i - 2
+			}
+			i += end + 3
+			statement.WriteByte(' ')
+			continue
+		case c == ';':
+			if text := strings.TrimSpace(statement.String()); text != "" {
+				statements = append(statements, text)
+			}
+			statement.Reset()
+			continue
+		}
+		statement.WriteByte(c)
+	}
+	if text := strings.TrimSpace(statement.String()); text != "" {
+		statements = append(statements, text)
+	}
+	return statements
+}
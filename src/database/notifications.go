@@ -0,0 +1,167 @@
+package database
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Notification events
+const (
+	EventNewTicket    = "new_ticket"
+	EventAssignment   = "assignment"
+	EventComment      = "comment"
+	EventStatusChange = "status_change"
+	EventSLAWarning   = "sla_warning"
+)
+
+// Notification delivery modes
+const (
+	NotifyInstant = "instant"
+	NotifyDigest  = "digest"
+	NotifyOff     = "off"
+)
+
+// NotificationPreference is how a person wants to receive one event; events without a row are instant
+type NotificationPreference struct {
+	Tenant     string `gorm:"primaryKey;column:tenant"`
+	TelegramID int64  `gorm:"primaryKey;column:telegram_id"`
+	Event      string `gorm:"primaryKey;column:event"`
+	Mode       string `gorm:"column:mode"`
+}
+
+func (NotificationPreference) TableName() string {
+	return "notification_preferences"
+}
+
+// QuietHours is the daily period (server time, whole hours) in which a person receives no instant notifications.
+// StartHour may be greater than EndHour for periods spanning midnight.
+type QuietHours struct {
+	Tenant     string `gorm:"primaryKey;column:tenant"`
+	TelegramID int64  `gorm:"primaryKey;column:telegram_id"`
+	StartHour  int    `gorm:"column:start_hour"`
+	EndHour    int    `gorm:"column:end_hour"`
+}
+
+func (QuietHours) TableName() string {
+	return "notification_quiet_hours"
+}
+
+// Contains reports whether t falls into the quiet hours
+func (q *QuietHours) Contains(t time.Time) bool {
+	hour := t.Hour()
+	if q.StartHour <= q.EndHour {
+		return hour >= q.StartHour && hour < q.EndHour
+	}
+	return hour >= q.StartHour || hour < q.EndHour
+}
+
+// PendingNotification waits for the recipient's next digest. Held notifications were meant to be instant
+// but arrived during quiet hours; they are delivered as soon as the quiet hours end.
+type PendingNotification struct {
+	NotificationID int       `gorm:"primaryKey;column:notification_id"`
+	Tenant         string    `gorm:"column:tenant"`
+	TelegramID     int64     `gorm:"column:telegram_id"`
+	Event          string    `gorm:"column:event"`
+	TicketID       int       `gorm:"column:ticket_id"`
+	Content        string    `gorm:"column:content"`
+	Held           bool      `gorm:"column:held"`
+	CreatedAt      time.Time `gorm:"column:created_at"`
+}
+
+func (PendingNotification) TableName() string {
+	return "pending_notifications"
+}
+
+// GetNotificationModes returns the person's delivery mode of every event they changed
+func GetNotificationModes(db *gorm.DB, tenant string, telegramID int64) (map[string]string, error) {
+	var preferences []NotificationPreference
+	if err := db.Where("tenant = ? AND telegram_id = ?", tenant, telegramID).Find(&preferences).Error; err != nil {
+		return nil, fmt.Errorf("[ERROR] Failed to get notification preferences: %v", err)
+	}
+
+	modes := make(map[string]string, len(preferences))
+	for _, preference := range preferences {
+		modes[preference.Event] = preference.Mode
+	}
+	return modes, nil
+}
+
+// GetNotificationMode returns how the person wants to receive the event
+func GetNotificationMode(db *gorm.DB, tenant string, telegramID int64, event string) (string, error) {
+	var preference NotificationPreference
+	err := db.Where("tenant = ? AND telegram_id = ? AND event = ?", tenant, telegramID, event).First(&preference).Error
+	if err == gorm.ErrRecordNotFound {
+		return NotifyInstant, nil
+	} else if err != nil {
+		return "", fmt.Errorf("[ERROR] Failed to get notification preference: %v", err)
+	}
+	return preference.Mode, nil
+}
+
+func SetNotificationMode(db *gorm.DB, tenant string, telegramID int64, event string, mode string) error {
+	preference := NotificationPreference{Tenant: tenant, TelegramID: telegramID, Event: event, Mode: mode}
+	if err := db.Save(&preference).Error; err != nil {
+		return fmt.Errorf("[ERROR] Failed to save notification preference: %v", err)
+	}
+	return nil
+}
+
+// GetQuietHours returns the person's quiet hours, or nil if they have none
+func GetQuietHours(db *gorm.DB, tenant string, telegramID int64) (*QuietHours, error) {
+	var quiet QuietHours
+	err := db.Where("tenant = ? AND telegram_id = ?", tenant, telegramID).First(&quiet).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("[ERROR] Failed to get quiet hours: %v", err)
+	}
+	return &quiet, nil
+}
+
+// SetQuietHours stores the person's quiet hours; nil removes them
+func SetQuietHours(db *gorm.DB, tenant string, telegramID int64, quiet *QuietHours) error {
+	var err error
+	if quiet == nil {
+		err = db.Where("tenant = ? AND telegram_id = ?", tenant, telegramID).Delete(&QuietHours{}).Error
+	} else {
+		quiet.Tenant = tenant
+		quiet.TelegramID = telegramID
+		err = db.Save(quiet).Error
+	}
+	if err != nil {
+		return fmt.Errorf("[ERROR] Failed to save quiet hours: %v", err)
+	}
+	return nil
+}
+
+// AddPendingNotification queues the notification; the database assigns its ID, as notifications of several
+// recipients are queued concurrently
+func AddPendingNotification(db *gorm.DB, notification *PendingNotification) error {
+	notification.CreatedAt = time.Now()
+	if err := db.Create(notification).Error; err != nil {
+		return fmt.Errorf("[ERROR] Failed to save pending notification: %v", err)
+	}
+	return nil
+}
+
+// GetPendingNotifications returns the tenant's pending notifications grouped by recipient, oldest first
+func GetPendingNotifications(db *gorm.DB, tenant string) ([]PendingNotification, error) {
+	var notifications []PendingNotification
+	err := db.Where("tenant = ?", tenant).Order("telegram_id").Order("notification_id").Find(&notifications).Error
+	if err != nil {
+		return nil, fmt.Errorf("[ERROR] Failed to get pending notifications: %v", err)
+	}
+	return notifications, nil
+}
+
+func DeletePendingNotifications(db *gorm.DB, notificationIDs []int) error {
+	if len(notificationIDs) == 0 {
+		return nil
+	}
+	if err := db.Where("notification_id IN ?", notificationIDs).Delete(&PendingNotification{}).Error; err != nil {
+		return fmt.Errorf("[ERROR] Failed to delete pending notifications: %v", err)
+	}
+	return nil
+}
@@ -8,26 +8,27 @@ import (
 )
 
 type RegularUser struct {
-	UserID     int       `gorm:"primaryKey;column:user_id"`
-	UserGroup  string    `gorm:"column:user_group"`
-	TelegramID int64     `gorm:"uniqueIndex;column:telegram_id"`
-	CreatedAt  time.Time `gorm:"column:created_at;type:datetime"`
+	UserID    int    `gorm:"primaryKey;column:user_id"`
+	Tenant    string `gorm:"column:tenant;uniqueIndex:idx_tenant_telegram,priority:1;uniqueIndex:idx_tenant_email,priority:1"`
+	UserGroup string `gorm:"column:user_group"`
+	// 0 for users who only contact support by email
+	TelegramID int64 `gorm:"column:telegram_id;uniqueIndex:idx_tenant_telegram,priority:2"`
+	// Address of users who contact support by email
+	Email     *string    `gorm:"column:email;uniqueIndex:idx_tenant_email,priority:2"`
+	CreatedAt time.Time  `gorm:"column:created_at;type:datetime"`
+	BlockedAt *time.Time `gorm:"column:blocked_at;type:datetime"`
+	Source    *string    `gorm:"column:source"`
+	SourceAt  *time.Time `gorm:"column:source_at;type:datetime"`
 }
 
 func (RegularUser) TableName() string {
 	return "regular_users"
 }
 
-func CreateRegularUser(db *gorm.DB, telegramID int64) error {
-	var maxUserID int
-	err := db.Model(&RegularUser{}).Select("COALESCE(MAX(user_id), 0)").Scan(&maxUserID).Error
-	if err != nil {
-		return fmt.Errorf("[ERROR] Failed to get max user_id: %v", err)
-	}
-
+func CreateRegularUser(db *gorm.DB, tenant string, telegramID int64) error {
 	// 创建新用户
 	user := RegularUser{
-		UserID:     maxUserID + 1,
+		Tenant:     tenant,
 		TelegramID: telegramID,
 		UserGroup:  "Default",
 		CreatedAt:  time.Now(),
@@ -37,26 +38,35 @@ func CreateRegularUser(db *gorm.DB, telegramID int64) error {
 	return result.Error
 }
 
-func GetRegularUserByTelegramID(db *gorm.DB, telegramID int64) (*RegularUser, error) {
+// GetUserTelegramIDs returns the Telegram IDs of the tenant's users, skipping those who only use email
+func GetUserTelegramIDs(db *gorm.DB, tenant string) ([]int64, error) {
+	var telegramIDs []int64
+	if err := db.Model(&RegularUser{}).Where("tenant = ? AND telegram_id IS NOT NULL AND telegram_id <> 0", tenant).Pluck("telegram_id", &telegramIDs).Error; err != nil {
+		return nil, fmt.Errorf("[ERROR] Failed to get users: %v", err)
+	}
+	return telegramIDs, nil
+}
+
+func GetRegularUserByTelegramID(db *gorm.DB, tenant string, telegramID int64) (*RegularUser, error) {
 	var user RegularUser
-	result := db.Where("telegram_id = ?", telegramID).First(&user)
+	result := db.Where("tenant = ? AND telegram_id = ?", tenant, telegramID).First(&user)
 	if result.Error != nil {
 		return nil, result.Error
 	}
 	return &user, nil
 }
 
-func CheckAndRegisterUser(db *gorm.DB, telegramID int64) (*RegularUser, error) {
-	user, err := GetRegularUserByTelegramID(db, telegramID)
+func CheckAndRegisterUser(db *gorm.DB, tenant string, telegramID int64) (*RegularUser, error) {
+	user, err := GetRegularUserByTelegramID(db, tenant, telegramID)
 	if err != nil {
 		if err == gorm.ErrRecordNotFound {
 			// 用户未注册，自动注册
-			err = CreateRegularUser(db, telegramID)
+			err = CreateRegularUser(db, tenant, telegramID)
 			if err != nil {
 				return nil, fmt.Errorf("[ERROR] Failed to create user: %v", err)
 			}
 			// 重新获取用户信息
-			user, err = GetRegularUserByTelegramID(db, telegramID)
+			user, err = GetRegularUserByTelegramID(db, tenant, telegramID)
 			if err != nil {
 				return nil, fmt.Errorf("[ERROR] Failed to get newly created user info: %v", err)
 			}
@@ -68,9 +78,40 @@ func CheckAndRegisterUser(db *gorm.DB, telegramID int64) (*RegularUser, error) {
 	return user, nil
 }
 
-func GetUserIDByTelegramID(db *gorm.DB, telegramID int64) (int, error) {
+func GetRegularUserByEmail(db *gorm.DB, tenant string, email string) (*RegularUser, error) {
 	var user RegularUser
-	result := db.Select("user_id").Where("telegram_id = ?", telegramID).First(&user)
+	result := db.Where("tenant = ? AND email = ?", tenant, email).First(&user)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &user, nil
+}
+
+// CheckAndRegisterEmailUser returns the user with the email address, registering them without a Telegram ID if needed
+func CheckAndRegisterEmailUser(db *gorm.DB, tenant string, email string) (*RegularUser, error) {
+	user, err := GetRegularUserByEmail(db, tenant, email)
+	if err == nil {
+		return user, nil
+	} else if err != gorm.ErrRecordNotFound {
+		return nil, fmt.Errorf("[ERROR] Failed to query user info: %v", err)
+	}
+
+	user = &RegularUser{
+		Tenant:    tenant,
+		Email:     &email,
+		UserGroup: "Default",
+		CreatedAt: time.Now(),
+	}
+	// Leave telegram_id NULL, so that email users do not collide in the unique (tenant, telegram_id) index
+	if err := db.Omit("telegram_id").Create(user).Error; err != nil {
+		return nil, fmt.Errorf("[ERROR] Failed to create user: %v", err)
+	}
+	return user, nil
+}
+
+func GetUserIDByTelegramID(db *gorm.DB, tenant string, telegramID int64) (int, error) {
+	var user RegularUser
+	result := db.Select("user_id").Where("tenant = ? AND telegram_id = ?", tenant, telegramID).First(&user)
 	if result.Error != nil {
 		return 0, result.Error
 	}
@@ -85,3 +126,48 @@ func GetRegularUserByID(db *gorm.DB, userID int) (*RegularUser, error) {
 	}
 	return &user, nil
 }
+
+// MarkUserBlocked records that the user has blocked the bot, so broadcasts skip them
+func MarkUserBlocked(db *gorm.DB, tenant string, telegramID int64) error {
+	err := db.Model(&RegularUser{}).Where("tenant = ? AND telegram_id = ?", tenant, telegramID).Update("blocked_at", time.Now()).Error
+	if err != nil {
+		return fmt.Errorf("[ERROR] Failed to mark user %d as blocked: %v", telegramID, err)
+	}
+	return nil
+}
+
+// MarkUserUnblocked clears the blocked flag once the user talks to the bot again. It is called for every
+// private message, so the flag is read first and only written when it is set.
+func MarkUserUnblocked(db *gorm.DB, tenant string, telegramID int64) error {
+	blocked := db.Model(&RegularUser{}).Where("tenant = ? AND telegram_id = ? AND blocked_at IS NOT NULL", tenant, telegramID)
+	var count int64
+	if err := blocked.Session(&gorm.Session{}).Count(&count).Error; err != nil {
+		return fmt.Errorf("[ERROR] Failed to check whether user %d is blocked: %v", telegramID, err)
+	}
+	if count == 0 {
+		return nil
+	}
+
+	if err := blocked.Update("blocked_at", nil).Error; err != nil {
+		return fmt.Errorf("[ERROR] Failed to mark user %d as unblocked: %v", telegramID, err)
+	}
+	return nil
+}
+
+// SetUserSource records where the user first came from; later sources do not overwrite it
+func SetUserSource(db *gorm.DB, tenant string, telegramID int64, source string) error {
+	err := db.Model(&RegularUser{}).Where("tenant = ? AND telegram_id = ? AND source IS NULL", tenant, telegramID).
+		Updates(map[string]interface{}{"source": source, "source_at": time.Now()}).Error
+	if err != nil {
+		return fmt.Errorf("[ERROR] Failed to set source of user %d: %v", telegramID, err)
+	}
+	return nil
+}
+
+func GetUserGroups(db *gorm.DB, tenant string) ([]string, error) {
+	var groups []string
+	if err := db.Model(&RegularUser{}).Where("tenant = ?", tenant).Distinct("user_group").Order("user_group").Pluck("user_group", &groups).Error; err != nil {
+		return nil, fmt.Errorf("[ERROR] Failed to get user groups: %v", err)
+	}
+	return groups, nil
+}
@@ -0,0 +1,104 @@
+package database
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Webhook delivery statuses
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// WebhookDelivery is one event sent, or still to be sent, to one webhook URL
+type WebhookDelivery struct {
+	DeliveryID int    `gorm:"primaryKey;column:delivery_id"`
+	Tenant     string `gorm:"column:tenant"`
+	URL        string `gorm:"column:url"`
+	Event      string `gorm:"column:event"`
+	Payload    string `gorm:"column:payload"`
+	Status     string `gorm:"column:status"`
+	Attempts   int    `gorm:"column:attempts"`
+	// HTTP status of the last attempt, 0 if no response was received
+	ResponseCode  int        `gorm:"column:response_code"`
+	LastError     string     `gorm:"column:last_error"`
+	NextAttemptAt *time.Time `gorm:"column:next_attempt_at"`
+	CreatedAt     time.Time  `gorm:"column:created_at"`
+	DeliveredAt   *time.Time `gorm:"column:delivered_at"`
+}
+
+func (WebhookDelivery) TableName() string {
+	return "webhook_deliveries"
+}
+
+// CreateWebhookDelivery queues the delivery for its first attempt right away. The database assigns its ID, as
+// every event is delivered to several webhooks concurrently.
+func CreateWebhookDelivery(db *gorm.DB, delivery *WebhookDelivery) error {
+	now := time.Now()
+	delivery.Status = DeliveryPending
+	delivery.NextAttemptAt = &now
+	delivery.CreatedAt = now
+	if err := db.Create(delivery).Error; err != nil {
+		return fmt.Errorf("[ERROR] Failed to create webhook delivery: %v", err)
+	}
+	return nil
+}
+
+// GetDueWebhookDeliveries returns up to limit pending deliveries of the tenant whose next attempt is due, oldest first
+func GetDueWebhookDeliveries(db *gorm.DB, tenant string, limit int) ([]WebhookDelivery, error) {
+	var deliveries []WebhookDelivery
+	err := db.Where("tenant = ? AND status = ? AND next_attempt_at <= ?", tenant, DeliveryPending, time.Now()).
+		Order("delivery_id").Limit(limit).Find(&deliveries).Error
+	if err != nil {
+		return nil, fmt.Errorf("[ERROR] Failed to get due webhook deliveries: %v", err)
+	}
+	return deliveries, nil
+}
+
+// GetRecentWebhookDeliveries returns the tenant's latest deliveries, newest first
+func GetRecentWebhookDeliveries(db *gorm.DB, tenant string, limit int) ([]WebhookDelivery, error) {
+	var deliveries []WebhookDelivery
+	if err := db.Where("tenant = ?", tenant).Order("delivery_id DESC").Limit(limit).Find(&deliveries).Error; err != nil {
+		return nil, fmt.Errorf("[ERROR] Failed to get webhook deliveries: %v", err)
+	}
+	return deliveries, nil
+}
+
+func GetWebhookDelivery(db *gorm.DB, tenant string, deliveryID int) (*WebhookDelivery, error) {
+	var delivery WebhookDelivery
+	if err := db.Where("tenant = ? AND delivery_id = ?", tenant, deliveryID).First(&delivery).Error; err != nil {
+		return nil, err
+	}
+	return &delivery, nil
+}
+
+// SaveWebhookDeliveryAttempt records the outcome of an attempt stored in the delivery
+func SaveWebhookDeliveryAttempt(db *gorm.DB, delivery *WebhookDelivery) error {
+	updates := map[string]interface{}{
+		"status":          delivery.Status,
+		"attempts":        delivery.Attempts,
+		"response_code":   delivery.ResponseCode,
+		"last_error":      delivery.LastError,
+		"next_attempt_at": delivery.NextAttemptAt,
+		"delivered_at":    delivery.DeliveredAt,
+	}
+	if err := db.Model(&WebhookDelivery{}).Where("delivery_id = ?", delivery.DeliveryID).Updates(updates).Error; err != nil {
+		return fmt.Errorf("[ERROR] Failed to save webhook delivery: %v", err)
+	}
+	return nil
+}
+
+// RetryWebhookDelivery queues a failed delivery of the tenant for another round of attempts,
+// reporting whether there was such a delivery
+func RetryWebhookDelivery(db *gorm.DB, tenant string, deliveryID int) (bool, error) {
+	updates := map[string]interface{}{"status": DeliveryPending, "attempts": 0, "next_attempt_at": time.Now()}
+	result := db.Model(&WebhookDelivery{}).Where("tenant = ? AND delivery_id = ? AND status = ?", tenant, deliveryID, DeliveryFailed).Updates(updates)
+	if result.Error != nil {
+		return false, fmt.Errorf("[ERROR] Failed to retry webhook delivery: %v", result.Error)
+	}
+	return result.RowsAffected > 0, nil
+}
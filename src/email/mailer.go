@@ -0,0 +1,97 @@
+package email
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+
+	"telegram-tickets-bot/src/config"
+	"telegram-tickets-bot/src/tickets"
+)
+
+// Mailer sends mail about email tickets from the tenant's support address
+type Mailer struct {
+	cfg *config.Tenant
+}
+
+// NewMailer returns the tenant's mailer, or nil if the email channel is disabled
+func NewMailer(cfg *config.Tenant) *Mailer {
+	if cfg.Email.Listen == "" {
+		return nil
+	}
+	return &Mailer{cfg: cfg}
+}
+
+// SendTicketCreated confirms a new email ticket to its creator
+func (m *Mailer) SendTicketCreated(to string, ticket *tickets.Ticket) error {
+	subject := ticketSubject(m.cfg, ticket.TicketID, ticket.Title)
+	body := fmt.Sprintf("您的工单已创建，工单ID: %d。\n\n直接回复此邮件即可补充信息，请保留主题中的 [#%d-%s]。", ticket.TicketID, ticket.TicketID, replyToken(m.cfg, ticket.TicketID))
+	return m.send(to, subject, body, true)
+}
+
+// SendReply sends a staff reply on an email ticket to its creator
+func (m *Mailer) SendReply(to string, ticket *tickets.Ticket, content string) error {
+	return m.send(to, "Re: "+ticketSubject(m.cfg, ticket.TicketID, ticket.Title), content, false)
+}
+
+// SendStatusChange tells the creator of an email ticket about its new status
+func (m *Mailer) SendStatusChange(to string, ticket *tickets.Ticket) error {
+	body := fmt.Sprintf("工单 #%d 状态已变更为: %s", ticket.TicketID, ticket.Status)
+	if ticket.Status == "closed" {
+		body += "\n\n如问题仍未解决，请发送新邮件创建新的工单。"
+	}
+	return m.send(to, "Re: "+ticketSubject(m.cfg, ticket.TicketID, ticket.Title), body, true)
+}
+
+// SendRejection explains to a sender why their mail was not added to a ticket. Only send it to verified
+// senders, as rejecting forged mail would send it to whoever was impersonated.
+func (m *Mailer) SendRejection(to string, subject string, reason string) error {
+	return m.send(to, "Re: "+subject, reason, true)
+}
+
+// Send a plain text mail. Automatic mail is marked as such so that auto-responders do not answer it.
+func (m *Mailer) send(to string, subject string, body string, automatic bool) error {
+	from := m.cfg.Email.Address
+	domain := from[strings.LastIndex(from, "@")+1:]
+
+	messageID := make([]byte, 12)
+	if _, err := rand.Read(messageID); err != nil {
+		return fmt.Errorf("[ERROR] Failed to generate Message-ID: %v", err)
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", (&mail.Address{Address: from}).String())
+	fmt.Fprintf(&msg, "To: %s\r\n", (&mail.Address{Address: to}).String())
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "Message-ID: <%s@%s>\r\n", hex.EncodeToString(messageID), domain)
+	if automatic {
+		msg.WriteString("Auto-Submitted: auto-replied\r\n")
+	}
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	msg.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+
+	writer := quotedprintable.NewWriter(&msg)
+	writer.Write([]byte(strings.ReplaceAll(body, "\n", "\r\n")))
+	writer.Close()
+
+	var auth smtp.Auth
+	if m.cfg.Email.SMTPUsername != "" {
+		auth = smtp.PlainAuth("", m.cfg.Email.SMTPUsername, m.cfg.Email.SMTPPassword, m.cfg.Email.SMTPHost)
+	}
+	addr := net.JoinHostPort(m.cfg.Email.SMTPHost, strconv.Itoa(m.cfg.Email.SMTPPort))
+	if err := smtp.SendMail(addr, auth, from, []string{to}, msg.Bytes()); err != nil {
+		return fmt.Errorf("[ERROR] Failed to send mail to %s: %v", to, err)
+	}
+	return nil
+}
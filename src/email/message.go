@@ -0,0 +1,151 @@
+package email
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"telegram-tickets-bot/src/config"
+)
+
+// Length of the hex encoded reply token in the ticket reference of a subject
+const replyTokenLength = 16
+
+// Ticket reference in a subject with its reply token, e.g. "Re: [#42-1f2e3d4c5b6a7980] Cannot log in"
+var ticketRefPattern = regexp.MustCompile(`\[#(\d+)(?:-([0-9a-f]+))?\]`)
+
+// Lines that introduce the quoted original in a reply; everything from them on is dropped
+var quoteHeaderPattern = regexp.MustCompile(`^(On .+ wrote:|在.+写道[:：]|-+ ?Original Message ?-+|-+ ?原始邮件 ?-+)$`)
+
+// Inbound mail reduced to what the email channel needs
+type inboundMessage struct {
+	From    string
+	Subject string
+	Body    string
+	// Set for automatic replies such as out-of-office notices, which must not be answered
+	AutoSubmitted bool
+}
+
+func parseMessage(data []byte) (*inboundMessage, error) {
+	msg, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("invalid message: %v", err)
+	}
+
+	from, err := mail.ParseAddress(msg.Header.Get("From"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid From header: %v", err)
+	}
+
+	decoder := new(mime.WordDecoder)
+	subject, err := decoder.DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil {
+		subject = msg.Header.Get("Subject")
+	}
+
+	body, err := textBody(msg.Header.Get("Content-Type"), msg.Header.Get("Content-Transfer-Encoding"), msg.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	autoSubmitted := msg.Header.Get("Auto-Submitted")
+	return &inboundMessage{
+		From:          strings.ToLower(from.Address),
+		Subject:       strings.TrimSpace(subject),
+		Body:          stripQuotedReply(body),
+		AutoSubmitted: (autoSubmitted != "" && !strings.EqualFold(autoSubmitted, "no")) || msg.Header.Get("X-Autoreply") != "",
+	}, nil
+}
+
+// Return the first text/plain part of a body, decoded
+func textBody(contentType string, transferEncoding string, body io.Reader) (string, error) {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if contentType == "" || err != nil {
+		mediaType = "text/plain"
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		reader := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := reader.NextPart()
+			if err == io.EOF {
+				return "", nil
+			} else if err != nil {
+				return "", fmt.Errorf("invalid multipart body: %v", err)
+			}
+			text, err := textBody(part.Header.Get("Content-Type"), part.Header.Get("Content-Transfer-Encoding"), part)
+			if err != nil {
+				return "", err
+			}
+			if text != "" {
+				return text, nil
+			}
+		}
+	}
+	if mediaType != "text/plain" {
+		return "", nil
+	}
+
+	switch strings.ToLower(transferEncoding) {
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	}
+	text, err := io.ReadAll(body)
+	if err != nil {
+		return "", fmt.Errorf("invalid body: %v", err)
+	}
+	return strings.ReplaceAll(string(text), "\r\n", "\n"), nil
+}
+
+// Remove the quoted original from a reply, keeping only what the sender wrote
+func stripQuotedReply(body string) string {
+	var kept []string
+	for _, line := range strings.Split(body, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if quoteHeaderPattern.MatchString(trimmed) {
+			break
+		}
+		if strings.HasPrefix(trimmed, ">") {
+			continue
+		}
+		kept = append(kept, strings.TrimRight(line, " \t"))
+	}
+	return strings.TrimSpace(strings.Join(kept, "\n"))
+}
+
+// Return the ticket referenced in a subject and the reply token given with it, or 0 if there is none
+func ticketRef(subject string) (int, string) {
+	match := ticketRefPattern.FindStringSubmatch(subject)
+	if match == nil {
+		return 0, ""
+	}
+	ticketID, _ := strconv.Atoi(match[1])
+	return ticketID, match[2]
+}
+
+// The token only mail sent to the ticket's creator carries, so that replies by mail cannot be forged by
+// putting the creator's address in the From header. Its key is derived from the bot token, so it needs no storage.
+func replyToken(cfg *config.Tenant, ticketID int) string {
+	key := sha256.Sum256([]byte("email-reply:" + cfg.Telegram.BotToken))
+	mac := hmac.New(sha256.New, key[:])
+	mac.Write([]byte(strconv.Itoa(ticketID)))
+	return hex.EncodeToString(mac.Sum(nil))[:replyTokenLength]
+}
+
+// Subject of mail about a ticket, which replies keep so they find their way back to it
+func ticketSubject(cfg *config.Tenant, ticketID int, title string) string {
+	return fmt.Sprintf("[#%d-%s] %s", ticketID, replyToken(cfg, ticketID), title)
+}
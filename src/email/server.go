@@ -0,0 +1,261 @@
+package email
+
+import (
+	"crypto/hmac"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/textproto"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"telegram-tickets-bot/src/config"
+	"telegram-tickets-bot/src/database"
+	"telegram-tickets-bot/src/logging"
+	"telegram-tickets-bot/src/tickets"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// Largest message accepted by the SMTP listener
+	maxMessageSize = 10 << 20
+	// Time a client may take for each command
+	commandTimeout = 5 * time.Minute
+	// Maximum length of a ticket title, as stored in tickets.title
+	maxTitleLength = 200
+)
+
+// Server receives the mail of one tenant's support address over SMTP. A mail whose subject references one of
+// the sender's tickets with its reply token, e.g. "Re: [#42-1f2e3d4c5b6a7980] ...", is added to it as a comment;
+// any other mail creates a ticket. Mail whose From header differs from the envelope sender is dropped.
+type Server struct {
+	cfg    *config.Tenant
+	mailer *Mailer
+}
+
+func NewServer(cfg *config.Tenant) *Server {
+	return &Server{cfg: cfg, mailer: NewMailer(cfg)}
+}
+
+// ListenAndServe accepts SMTP connections on the tenant's listen address until the listener fails
+func (s *Server) ListenAndServe() error {
+	listener, err := net.Listen("tcp", s.cfg.Email.Listen)
+	if err != nil {
+		return err
+	}
+	slog.Info("Receiving mail", "tenant", s.cfg.Key, "address", s.cfg.Email.Address, "addr", s.cfg.Email.Listen)
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go s.serve(conn)
+	}
+}
+
+// Speak the subset of SMTP that mail servers and local test tools use to hand over a message
+func (s *Server) serve(conn net.Conn) {
+	defer conn.Close()
+	text := textproto.NewConn(conn)
+	hostname := s.cfg.Email.Address[strings.LastIndex(s.cfg.Email.Address, "@")+1:]
+
+	reply := func(format string, args ...interface{}) bool {
+		return text.PrintfLine(format, args...) == nil
+	}
+
+	if !reply("220 %s ESMTP ready", hostname) {
+		return
+	}
+
+	var from string
+	var accepted bool
+	for {
+		conn.SetDeadline(time.Now().Add(commandTimeout))
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+
+		verb, arg, _ := strings.Cut(line, " ")
+		ok := true
+		switch strings.ToUpper(verb) {
+		case "HELO":
+			ok = reply("250 %s", hostname)
+		case "EHLO":
+			ok = reply("250-%s", hostname) && reply("250-8BITMIME") && reply("250 SIZE %d", maxMessageSize)
+		case "MAIL":
+			address, valid := pathArg(arg, "FROM:")
+			if !valid {
+				ok = reply("501 Syntax: MAIL FROM:<address>")
+				break
+			}
+			from, accepted = address, false
+			ok = reply("250 OK")
+		case "RCPT":
+			address, valid := pathArg(arg, "TO:")
+			switch {
+			case from == "":
+				ok = reply("503 MAIL first")
+			case !valid:
+				ok = reply("501 Syntax: RCPT TO:<address>")
+			case !strings.EqualFold(address, s.cfg.Email.Address):
+				ok = reply("550 No such user here")
+			default:
+				accepted = true
+				ok = reply("250 OK")
+			}
+		case "DATA":
+			if !accepted {
+				ok = reply("503 RCPT first")
+				break
+			}
+			if !reply("354 End data with <CR><LF>.<CR><LF>") {
+				return
+			}
+			dot := text.DotReader()
+			data, err := io.ReadAll(io.LimitReader(dot, maxMessageSize+1))
+			if err != nil {
+				return
+			}
+			sender := from
+			from, accepted = "", false
+			if len(data) > maxMessageSize {
+				// Read the rest of the message, so that its lines are not taken for commands
+				if _, err := io.Copy(io.Discard, dot); err != nil {
+					return
+				}
+				ok = reply("552 Message too large")
+				break
+			}
+			if err := s.receive(sender, data); err != nil {
+				slog.Error("Failed to process inbound mail", "tenant", s.cfg.Key, "error", err)
+				ok = reply("451 Requested action aborted: local error in processing")
+				break
+			}
+			ok = reply("250 OK")
+		case "RSET":
+			from, accepted = "", false
+			ok = reply("250 OK")
+		case "NOOP":
+			ok = reply("250 OK")
+		case "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			ok = reply("502 Command not implemented")
+		}
+		if !ok {
+			return
+		}
+	}
+}
+
+// Parse the address of a MAIL FROM:<address> or RCPT TO:<address> argument, ignoring ESMTP parameters
+func pathArg(arg string, prefix string) (string, bool) {
+	if len(arg) < len(prefix) || !strings.EqualFold(arg[:len(prefix)], prefix) {
+		return "", false
+	}
+	path := strings.TrimSpace(arg[len(prefix):])
+	if !strings.HasPrefix(path, "<") {
+		return "", false
+	}
+	end := strings.Index(path, ">")
+	if end < 0 {
+		return "", false
+	}
+	return path[1:end], true
+}
+
+// Turn a mail received from the envelope sender into a ticket or a comment
+func (s *Server) receive(sender string, data []byte) error {
+	msg, err := parseMessage(data)
+	if err != nil {
+		// A malformed message cannot be processed any better by retrying
+		slog.Warn("Dropping malformed inbound mail", "tenant", s.cfg.Key, "error", err)
+		return nil
+	}
+	if msg.AutoSubmitted || strings.EqualFold(msg.From, s.cfg.Email.Address) {
+		slog.Info("Ignoring automatic mail", "tenant", s.cfg.Key, "from", msg.From)
+		return nil
+	}
+	// Bounces have no envelope sender and are dropped here too
+	if !strings.EqualFold(sender, msg.From) {
+		slog.Warn("Dropping mail whose From header differs from its sender", "tenant", s.cfg.Key, "from", msg.From, "sender", sender)
+		return nil
+	}
+
+	db, err := database.InitializeDB()
+	if err != nil {
+		return fmt.Errorf("[ERROR] Failed to get database connection: %v", err)
+	}
+
+	if ticketID, token := ticketRef(msg.Subject); ticketID != 0 {
+		return s.addComment(db, ticketID, token, msg)
+	}
+
+	title := msg.Subject
+	if title == "" {
+		title = "(无主题)"
+	}
+	if utf8.RuneCountInString(title) > maxTitleLength {
+		title = string([]rune(title)[:maxTitleLength])
+	}
+
+	ticket, err := tickets.CreateEmailTicket(db, s.cfg.Key, msg.From, title, msg.Body)
+	if err != nil {
+		return err
+	}
+	slog.Info("Ticket created from mail", "tenant", s.cfg.Key, "ticket_id", ticket.TicketID)
+
+	if err := s.mailer.SendTicketCreated(msg.From, ticket); err != nil {
+		slog.Error("Failed to confirm ticket by mail", "tenant", s.cfg.Key, "ticket_id", ticket.TicketID, "error", err)
+	}
+	return nil
+}
+
+// Add a reply by mail to the ticket it references, if the sender created it. Replies that fail the checks
+// are dropped without an answer, as their sender may be forged; only the verified creator of a closed ticket
+// is told that their reply was not added.
+func (s *Server) addComment(db *gorm.DB, ticketID int, token string, msg *inboundMessage) error {
+	if !hmac.Equal([]byte(token), []byte(replyToken(s.cfg, ticketID))) {
+		slog.Warn("Dropping mail reply without a valid reply token", "tenant", s.cfg.Key, "ticket_id", ticketID, "from", msg.From)
+		return nil
+	}
+
+	ticket, err := tickets.GetTicketByID(db, s.cfg.Key, ticketID)
+	if err == gorm.ErrRecordNotFound {
+		slog.Warn("Dropping mail reply to a missing ticket", "tenant", s.cfg.Key, "ticket_id", ticketID, "from", msg.From)
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("[ERROR] Failed to get ticket: %v", err)
+	}
+
+	user, err := database.GetRegularUserByID(db, ticket.CreatedBy)
+	if err != nil {
+		return fmt.Errorf("[ERROR] Failed to get ticket creator: %v", err)
+	}
+	if user.Email == nil || !strings.EqualFold(*user.Email, msg.From) {
+		slog.Warn("Dropping mail reply from someone other than the ticket's creator", "tenant", s.cfg.Key, "ticket_id", ticketID, "from", msg.From)
+		return nil
+	}
+	if ticket.Status == "closed" {
+		reason := fmt.Sprintf("工单 #%d 已关闭，回复未添加。如问题仍未解决，请发送新邮件创建新的工单。", ticketID)
+		if err := s.mailer.SendRejection(msg.From, msg.Subject, reason); err != nil {
+			slog.Error("Failed to send rejection mail", "tenant", s.cfg.Key, "ticket_id", ticketID, "error", err)
+		}
+		return nil
+	}
+	if msg.Body == "" {
+		return nil
+	}
+
+	if _, err := tickets.AddComment(db, ticketID, user.UserID, msg.Body, 0, 0); err != nil {
+		return err
+	}
+	slog.Info("Comment added from mail", "tenant", s.cfg.Key, "ticket_id", ticketID, logging.Content("content", msg.Body))
+	return nil
+}
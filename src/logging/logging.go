@@ -0,0 +1,48 @@
+package logging
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"sync/atomic"
+	"unicode/utf8"
+
+	"telegram-tickets-bot/src/config"
+)
+
+var (
+	level      = new(slog.LevelVar)
+	logContent atomic.Bool
+)
+
+// Setup makes the configured logger the default one. Output of the standard log package goes through it as well.
+func Setup(cfg config.Logging) {
+	options := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler
+	if cfg.Format == "json" {
+		handler = slog.NewJSONHandler(os.Stderr, options)
+	} else {
+		handler = slog.NewTextHandler(os.Stderr, options)
+	}
+	slog.SetDefault(slog.New(handler))
+	Apply(cfg)
+}
+
+// Apply changes the level and redaction of the running logger; the format only changes with Setup
+func Apply(cfg config.Logging) {
+	var parsed slog.Level
+	if err := parsed.UnmarshalText([]byte(cfg.Level)); err != nil {
+		parsed = slog.LevelInfo
+	}
+	level.Set(parsed)
+	logContent.Store(cfg.LogContent)
+}
+
+// Content returns an attribute for text written by users or staff, such as a message or comment.
+// It is redacted unless log_content is enabled, so that logs do not leak ticket contents.
+func Content(key string, value string) slog.Attr {
+	if logContent.Load() {
+		return slog.String(key, value)
+	}
+	return slog.String(key, fmt.Sprintf("[redacted: %d characters]", utf8.RuneCountInString(value)))
+}
@@ -0,0 +1,47 @@
+package metrics
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// DefaultBuckets are the upper bounds, in seconds, of the latency histograms
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+var (
+	collectorsMu sync.Mutex
+	collectors   []func()
+)
+
+// OnCollect registers a function that refreshes gauges whose values are looked up, e.g. from the database,
+// right before each scrape
+func OnCollect(refresh func()) {
+	collectorsMu.Lock()
+	defer collectorsMu.Unlock()
+	collectors = append(collectors, refresh)
+}
+
+// Handler serves the metrics registered with promauto, and those of the Go runtime and the process, in the
+// Prometheus exposition format
+func Handler() http.Handler {
+	handler := promhttp.Handler()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		collectorsMu.Lock()
+		refreshers := append([]func(){}, collectors...)
+		collectorsMu.Unlock()
+
+		for _, refresh := range refreshers {
+			refresh()
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// ListenAndServe exposes the metrics on /metrics of addr
+func ListenAndServe(addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", Handler())
+	return http.ListenAndServe(addr, mux)
+}
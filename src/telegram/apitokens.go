@@ -0,0 +1,130 @@
+package telegram
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"telegram-tickets-bot/src/database"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"gorm.io/gorm"
+)
+
+// Maximum length of an API token name, as stored in api_tokens.name
+const apiTokenNameLength = 64
+
+func apiTokenUsage() string {
+	return "<b>API 令牌管理</b>\n" +
+		"/apitoken list - 查看令牌\n" +
+		"/apitoken create &lt;名称&gt; &lt;权限...&gt; - 创建令牌\n" +
+		"/apitoken revoke &lt;ID&gt; - 吊销令牌\n\n" +
+		"可用权限: " + escapeHTML(strings.Join(database.APIScopes, ", "))
+}
+
+// HandleAPITokenCommand lets admins create, list and revoke the tenant's REST API tokens in a private chat
+func (b *Bot) HandleAPITokenCommand(message *tgbotapi.Message) error {
+	chatID := message.Chat.ID
+
+	db, err := database.InitializeDB()
+	if err != nil {
+		return fmt.Errorf("[ERROR] Failed to get database connection: %v", err)
+	}
+
+	adminID, err := database.GetAdminIDByTelegramID(db, b.tenant, message.From.ID)
+	if err == gorm.ErrRecordNotFound {
+		return b.SendMessage(chatID, "对不起，只有管理员可以使用此命令。")
+	} else if err != nil {
+		return fmt.Errorf("[ERROR] Failed to get admin ID: %v", err)
+	}
+	if !message.Chat.IsPrivate() {
+		return b.SendMessage(chatID, "请在与机器人的私聊中管理 API 令牌。")
+	}
+
+	args := strings.Fields(message.CommandArguments())
+	if len(args) == 0 {
+		args = []string{"list"}
+	}
+
+	switch args[0] {
+	case "list":
+		return b.listAPITokens(db, chatID)
+	case "create":
+		if len(args) < 3 {
+			return b.SendHTMLMessage(chatID, apiTokenUsage())
+		}
+		return b.createAPIToken(db, chatID, adminID, args[1], args[2:])
+	case "revoke":
+		if len(args) != 2 {
+			return b.SendHTMLMessage(chatID, apiTokenUsage())
+		}
+		tokenID, err := strconv.Atoi(strings.TrimPrefix(args[1], "#"))
+		if err != nil {
+			return b.SendHTMLMessage(chatID, apiTokenUsage())
+		}
+		revoked, err := database.RevokeAPIToken(db, b.tenant, tokenID)
+		if err != nil {
+			return err
+		}
+		if !revoked {
+			return b.SendMessage(chatID, fmt.Sprintf("令牌 #%d 不存在或已吊销。", tokenID))
+		}
+		return b.SendMessage(chatID, fmt.Sprintf("令牌 #%d 已吊销。", tokenID))
+	default:
+		return b.SendHTMLMessage(chatID, apiTokenUsage())
+	}
+}
+
+func (b *Bot) listAPITokens(db *gorm.DB, chatID int64) error {
+	tokens, err := database.GetAPITokens(db, b.tenant)
+	if err != nil {
+		return err
+	}
+	if len(tokens) == 0 {
+		return b.SendHTMLMessage(chatID, "当前没有 API 令牌。\n\n"+apiTokenUsage())
+	}
+
+	var text strings.Builder
+	text.WriteString("<b>API 令牌</b>")
+	for _, token := range tokens {
+		lastUsed := "从未使用"
+		if token.LastUsedAt != nil {
+			lastUsed = "最近使用 " + token.LastUsedAt.Format("2006-01-02 15:04")
+		}
+		fmt.Fprintf(&text, "\n\n#%d %s\n权限: %s\n创建于 %s · %s",
+			token.TokenID, escapeHTML(token.Name), escapeHTML(token.Scopes),
+			token.CreatedAt.Format("2006-01-02 15:04"), lastUsed)
+	}
+	return b.SendHTMLMessage(chatID, text.String())
+}
+
+func (b *Bot) createAPIToken(db *gorm.DB, chatID int64, adminID int, name string, scopes []string) error {
+	if len(name) > apiTokenNameLength {
+		return b.SendMessage(chatID, fmt.Sprintf("令牌名称不能超过 %d 个字符。", apiTokenNameLength))
+	}
+
+	var granted []string
+	for _, scope := range scopes {
+		valid := false
+		for _, known := range database.APIScopes {
+			valid = valid || scope == known
+		}
+		if !valid {
+			return b.SendHTMLMessage(chatID, fmt.Sprintf("未知的权限 %s。\n\n%s", escapeHTML(scope), apiTokenUsage()))
+		}
+		granted = append(granted, scope)
+	}
+
+	secret, hash, err := database.NewAPIToken()
+	if err != nil {
+		return err
+	}
+	token, err := database.CreateAPIToken(db, b.tenant, name, hash, granted, adminID)
+	if err != nil {
+		return err
+	}
+
+	text := fmt.Sprintf("<b>API 令牌 #%d 已创建</b>\n%s\n%s\n\n<code>%s</code>\n\n令牌只显示这一次，请妥善保存。请求时使用请求头 Authorization: Bearer &lt;令牌&gt;。",
+		token.TokenID, htmlField("名称", token.Name), htmlField("权限", token.Scopes), secret)
+	return b.SendHTMLMessage(chatID, text)
+}
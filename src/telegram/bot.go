@@ -1,37 +1,158 @@
 package telegram
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
+	"net/http"
 	"strings"
+	"sync"
+	"time"
 
 	"telegram-tickets-bot/src/config"
 	"telegram-tickets-bot/src/database"
+	"telegram-tickets-bot/src/email"
 	"telegram-tickets-bot/src/tickets"
+	"telegram-tickets-bot/src/webhooks"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 )
 
+// Bot serves one tenant. Its conversation state is only touched on the goroutine running HandleUpdates.
 type Bot struct {
-	api *tgbotapi.BotAPI
+	api    *tgbotapi.BotAPI
+	tenant string
+	// Settings the bot was created with, used when the configuration in effect lacks the tenant
+	initial *config.Tenant
+	queue   *outboundQueue
+	// Logs with the tenant as context
+	logger *slog.Logger
+	// Delivers ticket events to the tenant's webhooks
+	webhooks *webhooks.Dispatcher
+	// Sends staff replies on email tickets; nil when the tenant has no email channel
+	mailer *email.Mailer
+
+	// Thread IDs of incoming forum topic messages, keyed by chat and message ID
+	topicThreads sync.Map
+
+	// Store user's current conversation state
+	userStates map[int64]string
+	ticketData map[int64]*tickets.TicketCreationData
+	// Store admins' broadcast drafts by chat
+	broadcastDrafts map[int64]*broadcastDraft
+	// Store when each chat's conversation was last advanced, so abandoned ones can expire
+	conversationTouchedAt map[int64]time.Time
 }
 
-// Initialize Telegram Bot
-func NewBot(cfg *config.Config) (*Bot, error) {
-	bot, err := tgbotapi.NewBotAPI(cfg.Telegram.BotToken)
+// Initialize the Telegram Bot of a tenant
+func NewBot(cfg *config.Tenant) (*Bot, error) {
+	client := &instrumentedClient{tenant: cfg.Key, client: &http.Client{}}
+	bot, err := tgbotapi.NewBotAPIWithClient(cfg.Telegram.BotToken, tgbotapi.APIEndpoint, client)
+	if err != nil {
+		return nil, err
+	}
+
+	logger := slog.With("tenant", cfg.Key)
+	logger.Info("Authorized on account", "account", bot.Self.UserName)
+
+	dispatcher, err := webhooks.NewDispatcher(cfg)
 	if err != nil {
 		return nil, err
 	}
 
-	log.Printf("[INFO] Authorized on account %s", bot.Self.UserName)
+	b := &Bot{
+		api:                   bot,
+		tenant:                cfg.Key,
+		initial:               cfg,
+		logger:                logger,
+		queue:                 newOutboundQueue(),
+		webhooks:              dispatcher,
+		mailer:                email.NewMailer(cfg),
+		userStates:            make(map[int64]string),
+		ticketData:            make(map[int64]*tickets.TicketCreationData),
+		broadcastDrafts:       make(map[int64]*broadcastDraft),
+		conversationTouchedAt: make(map[int64]time.Time),
+	}
+	tickets.Subscribe(dispatcher.HandleEvent)
+	tickets.Subscribe(b.handleTicketEvent)
+	return b, nil
+}
+
+// Return the tenant's settings in effect, which a configuration reload may replace at any time. Without a
+// configuration in effect, e.g. when the bot was built from a configuration that was never stored, the
+// settings the bot was created with are used.
+func (b *Bot) settings() *config.Tenant {
+	if settings := config.CurrentTenant(b.tenant); settings != nil {
+		return settings
+	}
+	return b.initial
+}
+
+// Return the tenant's override of the named text, or fallback when it has none
+func (b *Bot) text(name string, fallback string) string {
+	if text, ok := b.settings().Texts[name]; ok && text != "" {
+		return text
+	}
+	return fallback
+}
+
+// Maximum length of a text message accepted by Telegram, in UTF-16 code units
+const maxMessageLength = 4096
+
+// QueueText queues text in as many messages as needed, attaching the keyboard (if any) to the last one,
+// and returns without waiting. parseMode is either empty for plain text or tgbotapi.ModeHTML.
+// Once a part fails for good the remaining parts are not sent, so the chat never sees a text with a gap.
+func (b *Bot) QueueText(chatID int64, text string, parseMode string, keyboard interface{}) Delivery {
+	var chunks []string
+	if parseMode == tgbotapi.ModeHTML {
+		chunks = splitHTMLMessage(text, maxMessageLength)
+	} else {
+		chunks = splitText(text, maxMessageLength, false)
+	}
+
+	// The queue sends a chat's requests one after another and retries a part before the next one starts.
+	// failure only records final errors, so a part being retried is not mistaken for an earlier part that
+	// failed for good.
+	var failure error
+	delivery := make(Delivery, 0, len(chunks))
+	for i, chunk := range chunks {
+		msg := tgbotapi.NewMessage(chatID, chunk)
+		msg.ParseMode = parseMode
+		if i == len(chunks)-1 && keyboard != nil {
+			msg.ReplyMarkup = keyboard
+		}
+		send := func() (tgbotapi.Message, error) {
+			if failure != nil {
+				return tgbotapi.Message{}, fmt.Errorf("[ERROR] Part %d of %d not sent after an earlier part failed: %v", i+1, len(chunks), failure)
+			}
+			return b.api.Send(msg)
+		}
+		settled := func(err error) {
+			if failure == nil {
+				failure = err
+			}
+		}
+		delivery = append(delivery, b.queue.enqueueSettled(chatID, send, settled))
+	}
+	return delivery
+}
 
-	return &Bot{api: bot}, nil
+// Send text through the outbound queue and wait for it; the last sent message is returned
+func (b *Bot) sendText(chatID int64, text string, parseMode string, keyboard interface{}) (tgbotapi.Message, error) {
+	result := b.QueueText(chatID, text, parseMode, keyboard).Wait()
+	return result.Message, result.Err
 }
 
 // Send text message
 func (b *Bot) SendMessage(chatID int64, text string) error {
-	msg := tgbotapi.NewMessage(chatID, text)
-	_, err := b.api.Send(msg)
+	_, err := b.sendText(chatID, text, "", nil)
+	return err
+}
+
+// Send message formatted as Telegram HTML
+func (b *Bot) SendHTMLMessage(chatID int64, text string) error {
+	_, err := b.sendText(chatID, text, tgbotapi.ModeHTML, nil)
 	return err
 }
 
@@ -39,75 +160,237 @@ func (b *Bot) SendMessage(chatID int64, text string) error {
 func (b *Bot) SendPhoto(chatID int64, photoPath string, caption string) error {
 	photo := tgbotapi.NewPhoto(chatID, tgbotapi.FilePath(photoPath))
 	photo.Caption = caption
-	_, err := b.api.Send(photo)
+	_, err := b.send(chatID, photo)
 	return err
 }
 
-// Send message with inline keyboard
+// Send message with inline keyboard. Long texts are split and the keyboard is attached to the last part.
 func (b *Bot) SendMessageWithInlineKeyboard(chatID int64, text string, keyboard tgbotapi.InlineKeyboardMarkup) error {
-	msg := tgbotapi.NewMessage(chatID, text)
-	msg.ReplyMarkup = keyboard
-	_, err := b.api.Send(msg)
+	_, err := b.sendText(chatID, text, "", keyboard)
 	return err
 }
 
-func (b *Bot) GetUpdatesChan(config tgbotapi.UpdateConfig) tgbotapi.UpdatesChannel {
-	return b.api.GetUpdatesChan(config)
+// Send Telegram HTML message with inline keyboard
+func (b *Bot) SendHTMLWithInlineKeyboard(chatID int64, text string, keyboard tgbotapi.InlineKeyboardMarkup) error {
+	_, err := b.sendText(chatID, text, tgbotapi.ModeHTML, keyboard)
+	return err
 }
 
-func (b *Bot) NotifyAllAdmins(ticket *tickets.Ticket) error {
+// Send a ticket notification (Telegram HTML) and remember it, so that replying to it comments on the ticket
+func (b *Bot) SendTicketNotification(chatID int64, ticketID int, text string, keyboard tgbotapi.InlineKeyboardMarkup) error {
+	sent, err := b.sendText(chatID, text, tgbotapi.ModeHTML, keyboard)
+	if err != nil {
+		return err
+	}
+
 	db, err := database.InitializeDB()
 	if err != nil {
 		return fmt.Errorf("[ERROR] Failed to get database connection: %v", err)
 	}
+	return tickets.SaveTicketMessage(db, b.tenant, chatID, sent.MessageID, ticketID)
+}
+
+// Username returns the bot's Telegram username, without the @
+func (b *Bot) Username() string {
+	return b.api.Self.UserName
+}
+
+// Start long polling for updates until ctx is done, which closes the channel. Updates are fetched through
+// getUpdates directly so that fields unknown to tgbotapi (message_thread_id) can be captured as well.
+func (b *Bot) GetUpdatesChan(ctx context.Context, config tgbotapi.UpdateConfig) tgbotapi.UpdatesChannel {
+	ch := make(chan tgbotapi.Update, b.api.Buffer)
+
+	go func() {
+		defer close(ch)
+		for {
+			updates, err := b.pollUpdates(ctx, config)
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				b.logger.Error("Failed to get updates, retrying in 3 seconds", "error", err)
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(time.Second * 3):
+				}
+				continue
+			}
+
+			for _, update := range updates {
+				if update.UpdateID >= config.Offset {
+					config.Offset = update.UpdateID + 1
+					select {
+					case ch <- update:
+					case <-ctx.Done():
+						return
+					}
+				}
+			}
+		}
+	}()
+
+	return ch
+}
 
-	var admins []database.AdminUser
-	if err := db.Find(&admins).Error; err != nil {
-		return fmt.Errorf("[ERROR] Failed to fetch admin users: %v", err)
+// Fetch updates, giving up on the long poll in flight when ctx is done. The updates of an abandoned poll
+// are not confirmed, so Telegram delivers them again on the next start.
+func (b *Bot) pollUpdates(ctx context.Context, config tgbotapi.UpdateConfig) ([]tgbotapi.Update, error) {
+	type result struct {
+		updates []tgbotapi.Update
+		err     error
 	}
+	done := make(chan result, 1)
+	go func() {
+		updates, err := b.getUpdates(config)
+		done <- result{updates, err}
+	}()
 
-	for _, admin := range admins {
-		message := fmt.Sprintf("新工单已创建:\n工单ID: %d\n标题: %s\n描述: %s",
-			ticket.TicketID, ticket.Title, ticket.Description)
+	select {
+	case r := <-done:
+		return r.updates, r.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (b *Bot) getUpdates(config tgbotapi.UpdateConfig) ([]tgbotapi.Update, error) {
+	resp, err := b.api.Request(config)
+	if err != nil {
+		return nil, err
+	}
+
+	var incoming []incomingUpdate
+	if err := json.Unmarshal(resp.Result, &incoming); err != nil {
+		return nil, err
+	}
+
+	updates := make([]tgbotapi.Update, len(incoming))
+	for i, raw := range incoming {
+		updates[i] = raw.Update
+		if raw.Message == nil {
+			continue
+		}
+		updates[i].Message = &raw.Message.Message
+		if raw.Message.IsTopicMessage && raw.Message.Chat.ID == b.settings().Forum.ChatID {
+			b.topicThreads.Store(topicMessageKey{raw.Message.Chat.ID, raw.Message.MessageID}, raw.Message.MessageThreadID)
+		}
+	}
 
-		keyboard := tgbotapi.NewInlineKeyboardMarkup(
-			tgbotapi.NewInlineKeyboardRow(
-				tgbotapi.NewInlineKeyboardButtonData("分配工单", fmt.Sprintf("assign_ticket_%d", ticket.TicketID)),
-			),
-		)
+	return updates, nil
+}
 
-		if err := b.SendMessageWithInlineKeyboard(admin.TelegramID, message, keyboard); err != nil {
-			log.Printf("[ERROR] Failed to notify admin %d: %v", admin.AdminID, err)
+func (b *Bot) NotifyAllAdmins(ticket *tickets.Ticket) error {
+	// In forum mode the ticket gets its own topic in the admin group instead of private messages
+	if b.forumEnabled() {
+		err := b.OpenTicketTopic(ticket)
+		if err == nil {
+			return nil
 		}
+		b.logger.Error("Failed to open forum topic, falling back to private messages", "ticket_id", ticket.TicketID, "error", err)
+	}
+
+	db, err := database.InitializeDB()
+	if err != nil {
+		return fmt.Errorf("[ERROR] Failed to get database connection: %v", err)
+	}
+
+	admins, err := database.GetAdmins(db, b.tenant)
+	if err != nil {
+		return err
 	}
 
+	message := "<b>新工单已创建</b>\n" + ticketSummaryHTML(ticket)
+
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("分配工单", fmt.Sprintf("assign_ticket_%d", ticket.TicketID)),
+		),
+	)
+
+	recipients := make([]int64, 0, len(admins))
+	for _, admin := range admins {
+		recipients = append(recipients, admin.TelegramID)
+	}
+	b.NotifyAll(recipients, database.EventNewTicket, ticket.TicketID, message, keyboard)
+
 	return nil
 }
 
+func (b *Bot) markUserUnblocked(logger *slog.Logger, telegramID int64) {
+	db, err := database.InitializeDB()
+	if err != nil {
+		logger.Error("Failed to get database connection", "error", err)
+		return
+	}
+	if err := database.MarkUserUnblocked(db, b.tenant, telegramID); err != nil {
+		logger.Error("Failed to mark user unblocked", "error", err)
+	}
+}
+
+// HandleUpdates processes updates one at a time until the channel is closed. Abandoned conversations are
+// expired on the same goroutine, so the conversation state needs no locking.
 func (b *Bot) HandleUpdates(updates tgbotapi.UpdatesChannel) {
-	for update := range updates {
-		var err error
-		if update.Message != nil {
-			if update.Message.IsCommand() {
-				err = b.HandleCommand(update.Message)
-			} else {
-				err = b.HandleMessage(update.Message)
-			}
-		} else if update.CallbackQuery != nil {
-			if strings.HasPrefix(update.CallbackQuery.Data, "confirm_") || strings.HasPrefix(update.CallbackQuery.Data, "cancel_") {
-				err = b.HandleTicketConfirmation(update.CallbackQuery)
-			} else {
-				err = b.HandleCallbackQuery(update.CallbackQuery)
-			}
+	expiry := time.NewTicker(conversationExpiryInterval)
+	defer expiry.Stop()
 
-			callback := tgbotapi.NewCallback(update.CallbackQuery.ID, "")
-			if _, err := b.api.Request(callback); err != nil {
-				log.Printf("[ERROR] Error answering callback query: %v", err)
+	for {
+		select {
+		case <-expiry.C:
+			b.expireConversations()
+		case update, ok := <-updates:
+			if !ok {
+				return
 			}
+			b.handleUpdate(update)
 		}
+		b.recordConversations()
+	}
+}
+
+func (b *Bot) handleUpdate(update tgbotapi.Update) {
+	start := time.Now()
+	inForum := update.Message != nil && b.forumEnabled() && update.Message.Chat.ID == b.settings().Forum.ChatID
+	handler := handlerName(update, inForum)
+	logger := b.updateLogger(update, handler)
+	logger.Debug("Handling update", updateContent(update))
+
+	var err error
+	defer func() {
+		b.recordUpdate(update, handler, start, err)
+	}()
 
-		if err != nil {
-			log.Printf("[ERROR] Error handling update: %v", err)
+	if update.Message != nil && update.Message.Chat.IsPrivate() {
+		// A user writing to the bot has evidently unblocked it
+		b.markUserUnblocked(logger, update.Message.From.ID)
+	}
+
+	if update.Message != nil {
+		if inForum {
+			err = b.HandleForumMessage(update.Message)
+		} else if update.Message.IsCommand() {
+			err = b.HandleCommand(logger, update.Message)
+		} else {
+			err = b.HandleMessage(logger, update.Message)
+		}
+	} else if update.EditedMessage != nil {
+		err = b.HandleEditedMessage(logger, update.EditedMessage)
+	} else if update.InlineQuery != nil {
+		err = b.HandleInlineQuery(update.InlineQuery)
+	} else if update.CallbackQuery != nil {
+		if strings.HasPrefix(update.CallbackQuery.Data, "confirm_") || strings.HasPrefix(update.CallbackQuery.Data, "cancel_") {
+			err = b.HandleTicketConfirmation(logger, update.CallbackQuery)
+		} else {
+			err = b.HandleCallbackQuery(logger, update.CallbackQuery)
 		}
+
+		callback := tgbotapi.NewCallback(update.CallbackQuery.ID, "")
+		if _, err := b.api.Request(callback); err != nil {
+			logger.Error("Failed to answer callback query", "error", err)
+		}
+	}
+
+	if err != nil {
+		logger.Error("Failed to handle update", "error", err)
 	}
 }
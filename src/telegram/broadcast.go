@@ -0,0 +1,313 @@
+package telegram
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"telegram-tickets-bot/src/database"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	// Broadcast messages queued per second, leaving room in the global rate limit for regular traffic
+	broadcastBatchSize = 20
+	// Minimum time between progress reports to the admin
+	broadcastProgressInterval = 3 * time.Second
+	// Maximum size of callback data accepted by Telegram, in bytes
+	maxCallbackDataLength = 64
+)
+
+// Broadcast being composed by an admin
+type broadcastDraft struct {
+	Audience  string
+	UserGroup string
+	Content   string
+}
+
+func audienceLabel(draft *broadcastDraft) string {
+	switch draft.Audience {
+	case database.AudienceGroup:
+		return "用户组 " + draft.UserGroup
+	case database.AudienceOpenTickets:
+		return "有未关闭工单的用户"
+	default:
+		return "所有用户"
+	}
+}
+
+func broadcastText(content string) string {
+	return "<b>【公告】</b>\n\n" + htmlContent(content)
+}
+
+// Keyboard without buttons, used to remove the buttons of an edited message
+func emptyKeyboard() tgbotapi.InlineKeyboardMarkup {
+	return tgbotapi.InlineKeyboardMarkup{InlineKeyboard: [][]tgbotapi.InlineKeyboardButton{}}
+}
+
+// HandleBroadcastCommand starts the broadcast flow by asking for the audience
+func (b *Bot) HandleBroadcastCommand(message *tgbotapi.Message) error {
+	chatID := message.Chat.ID
+
+	isAdmin, err := database.IsUserAdmin(b.tenant, message.From.ID)
+	if err != nil {
+		return fmt.Errorf("[ERROR] Failed to check admin status: %v", err)
+	}
+	if !isAdmin {
+		return b.SendMessage(chatID, "对不起，只有管理员可以使用此命令。")
+	}
+
+	b.clearConversation(chatID)
+	b.broadcastDrafts[chatID] = &broadcastDraft{}
+	b.touchConversation(chatID)
+
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("所有用户", "broadcast_audience_all"),
+			tgbotapi.NewInlineKeyboardButtonData("按用户组", "broadcast_audience_group"),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("有未关闭工单的用户", "broadcast_audience_open"),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("取消", "broadcast_cancel"),
+		),
+	)
+
+	return b.SendMessageWithInlineKeyboard(chatID, "请选择公告的接收对象：", keyboard)
+}
+
+// HandleBroadcastCallback handles the audience selection, confirmation and cancellation buttons
+func (b *Bot) HandleBroadcastCallback(logger *slog.Logger, callbackQuery *tgbotapi.CallbackQuery) error {
+	chatID := callbackQuery.Message.Chat.ID
+	messageID := callbackQuery.Message.MessageID
+	data := callbackQuery.Data
+
+	isAdmin, err := database.IsUserAdmin(b.tenant, callbackQuery.From.ID)
+	if err != nil {
+		return fmt.Errorf("[ERROR] Failed to check admin status: %v", err)
+	}
+	if !isAdmin {
+		return b.SendMessage(chatID, "对不起，只有管理员可以使用此命令。")
+	}
+
+	draft, ok := b.broadcastDrafts[chatID]
+	if !ok {
+		_, err := b.ShowScreen(logger, chatID, messageID, "公告已失效，请重新使用 /broadcast 创建。", emptyKeyboard())
+		return err
+	}
+
+	switch {
+	case data == "broadcast_cancel":
+		b.clearConversation(chatID)
+		_, err := b.ShowScreen(logger, chatID, messageID, "公告已取消。", emptyKeyboard())
+		return err
+	case data == "broadcast_audience_all":
+		draft.Audience = database.AudienceAll
+	case data == "broadcast_audience_open":
+		draft.Audience = database.AudienceOpenTickets
+	case data == "broadcast_audience_group":
+		return b.showBroadcastGroups(logger, chatID, messageID)
+	case strings.HasPrefix(data, "broadcast_group_"):
+		draft.Audience = database.AudienceGroup
+		draft.UserGroup = strings.TrimPrefix(data, "broadcast_group_")
+	case data == "broadcast_confirm":
+		return b.StartBroadcast(logger, chatID, messageID, callbackQuery.From.ID)
+	default:
+		return b.SendMessage(chatID, "未知的选项。")
+	}
+
+	b.setUserState(chatID, StateWaitingForBroadcast)
+	text := fmt.Sprintf("接收对象：%s\n请输入公告内容：", audienceLabel(draft))
+	_, err = b.ShowScreen(logger, chatID, messageID, escapeHTML(text), emptyKeyboard())
+	return err
+}
+
+func (b *Bot) showBroadcastGroups(logger *slog.Logger, chatID int64, messageID int) error {
+	db, err := database.InitializeDB()
+	if err != nil {
+		return fmt.Errorf("[ERROR] Failed to get database connection: %v", err)
+	}
+
+	groups, err := database.GetUserGroups(db, b.tenant)
+	if err != nil {
+		return err
+	}
+
+	keyboard := tgbotapi.NewInlineKeyboardMarkup()
+	for _, group := range groups {
+		data := "broadcast_group_" + group
+		if len(data) > maxCallbackDataLength {
+			logger.Error("User group name too long for a button", "group", group)
+			continue
+		}
+		button := tgbotapi.NewInlineKeyboardButtonData(group, data)
+		keyboard.InlineKeyboard = append(keyboard.InlineKeyboard, tgbotapi.NewInlineKeyboardRow(button))
+	}
+	keyboard.InlineKeyboard = append(keyboard.InlineKeyboard, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("取消", "broadcast_cancel"),
+	))
+
+	_, err = b.ShowScreen(logger, chatID, messageID, "请选择用户组：", keyboard)
+	return err
+}
+
+// HandleBroadcastContent stores the announcement text and shows a preview for confirmation
+func (b *Bot) HandleBroadcastContent(chatID int64, content string) error {
+	delete(b.userStates, chatID)
+	b.touchConversation(chatID)
+
+	draft, ok := b.broadcastDrafts[chatID]
+	if !ok {
+		return b.SendMessage(chatID, "公告已失效，请重新使用 /broadcast 创建。")
+	}
+	draft.Content = content
+
+	db, err := database.InitializeDB()
+	if err != nil {
+		return fmt.Errorf("[ERROR] Failed to get database connection: %v", err)
+	}
+
+	recipients, err := database.GetBroadcastRecipients(db, b.tenant, draft.Audience, draft.UserGroup)
+	if err != nil {
+		return err
+	}
+
+	preview := fmt.Sprintf("<b>公告预览</b>\n%s\n%s\n\n%s",
+		htmlField("接收对象", audienceLabel(draft)),
+		htmlField("接收人数", fmt.Sprint(len(recipients))),
+		broadcastText(content))
+
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("确认发送", "broadcast_confirm"),
+			tgbotapi.NewInlineKeyboardButtonData("取消", "broadcast_cancel"),
+		),
+	)
+
+	return b.SendHTMLWithInlineKeyboard(chatID, preview, keyboard)
+}
+
+// StartBroadcast records the confirmed broadcast and delivers it in the background
+func (b *Bot) StartBroadcast(logger *slog.Logger, chatID int64, messageID int, telegramID int64) error {
+	draft := b.broadcastDrafts[chatID]
+	b.clearConversation(chatID)
+	if draft.Content == "" {
+		return b.SendMessage(chatID, "公告内容为空，请重新使用 /broadcast 创建。")
+	}
+
+	db, err := database.InitializeDB()
+	if err != nil {
+		return fmt.Errorf("[ERROR] Failed to get database connection: %v", err)
+	}
+
+	adminID, err := database.GetAdminIDByTelegramID(db, b.tenant, telegramID)
+	if err != nil {
+		return fmt.Errorf("[ERROR] Failed to get admin ID: %v", err)
+	}
+
+	recipients, err := database.GetBroadcastRecipients(db, b.tenant, draft.Audience, draft.UserGroup)
+	if err != nil {
+		return err
+	}
+
+	broadcast, err := database.CreateBroadcast(db, b.tenant, adminID, draft.Audience, draft.UserGroup, draft.Content, len(recipients))
+	if err != nil {
+		return err
+	}
+
+	progressMessageID, err := b.ShowScreen(logger, chatID, messageID, broadcastProgressText(broadcast), emptyKeyboard())
+	if err != nil {
+		return err
+	}
+
+	logger.Info("Broadcast started", "broadcast_id", broadcast.BroadcastID, "recipients", len(recipients))
+	go b.runBroadcast(chatID, progressMessageID, broadcast, recipients)
+	return nil
+}
+
+func broadcastProgressText(broadcast *database.Broadcast) string {
+	title := "正在发送公告"
+	if broadcast.Status == "finished" {
+		title = "公告发送完成"
+	}
+	return fmt.Sprintf("<b>%s #%d</b>\n进度: %d/%d\n成功: %d\n失败: %d\n已屏蔽机器人: %d",
+		title, broadcast.BroadcastID,
+		broadcast.Sent+broadcast.Failed+broadcast.Blocked, broadcast.Total,
+		broadcast.Sent, broadcast.Failed, broadcast.Blocked)
+}
+
+// Deliver a broadcast in throttled batches, reporting progress by editing the admin's progress message
+func (b *Bot) runBroadcast(adminChatID int64, progressMessageID int, broadcast *database.Broadcast, recipients []int64) {
+	db, err := database.InitializeDB()
+	if err != nil {
+		b.logger.Error("Failed to get database connection", "broadcast_id", broadcast.BroadcastID, "error", err)
+		return
+	}
+
+	text := broadcastText(broadcast.Content)
+	lastReport := time.Now()
+
+	for start := 0; start < len(recipients); start += broadcastBatchSize {
+		batchStart := time.Now()
+		end := start + broadcastBatchSize
+		if end > len(recipients) {
+			end = len(recipients)
+		}
+
+		deliveries := make([]Delivery, 0, end-start)
+		for _, telegramID := range recipients[start:end] {
+			deliveries = append(deliveries, b.QueueText(telegramID, text, tgbotapi.ModeHTML, nil))
+		}
+
+		for i, delivery := range deliveries {
+			result := delivery.Wait()
+			if result.Err == nil {
+				broadcast.Sent++
+				continue
+			}
+
+			var apiErr *tgbotapi.Error
+			if errors.As(result.Err, &apiErr) && apiErr.Code == 403 {
+				// Blocked by the user or the account was deleted
+				broadcast.Blocked++
+				if err := database.MarkUserBlocked(db, b.tenant, recipients[start+i]); err != nil {
+					b.logger.Error("Failed to mark user blocked", "chat_id", recipients[start+i], "error", err)
+				}
+			} else {
+				broadcast.Failed++
+				b.logger.Warn("Failed to deliver broadcast", "broadcast_id", broadcast.BroadcastID, "chat_id", recipients[start+i], "error", result.Err)
+			}
+		}
+
+		if err := database.SaveBroadcastProgress(db, broadcast); err != nil {
+			b.logger.Error("Failed to save broadcast progress", "broadcast_id", broadcast.BroadcastID, "error", err)
+		}
+		if time.Since(lastReport) >= broadcastProgressInterval {
+			lastReport = time.Now()
+			if _, err := b.ShowScreen(b.logger, adminChatID, progressMessageID, broadcastProgressText(broadcast), emptyKeyboard()); err != nil {
+				b.logger.Error("Failed to report broadcast progress", "broadcast_id", broadcast.BroadcastID, "error", err)
+			}
+		}
+
+		if elapsed := time.Since(batchStart); elapsed < time.Second {
+			time.Sleep(time.Second - elapsed)
+		}
+	}
+
+	now := time.Now()
+	broadcast.Status = "finished"
+	broadcast.FinishedAt = &now
+	if err := database.SaveBroadcastProgress(db, broadcast); err != nil {
+		b.logger.Error("Failed to save broadcast progress", "broadcast_id", broadcast.BroadcastID, "error", err)
+	}
+
+	if _, err := b.ShowScreen(b.logger, adminChatID, progressMessageID, broadcastProgressText(broadcast), emptyKeyboard()); err != nil {
+		b.logger.Error("Failed to report broadcast result", "broadcast_id", broadcast.BroadcastID, "error", err)
+	}
+	b.logger.Info("Broadcast finished", "broadcast_id", broadcast.BroadcastID,
+		"sent", broadcast.Sent, "failed", broadcast.Failed, "blocked", broadcast.Blocked)
+}
@@ -0,0 +1,159 @@
+package telegram
+
+import (
+	"fmt"
+	"time"
+
+	"telegram-tickets-bot/src/database"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"gorm.io/gorm"
+)
+
+// Command with its menu description per language code ("" is the default language)
+type menuCommand struct {
+	Command      string
+	Descriptions map[string]string
+}
+
+// Languages the command menus are registered for
+var menuLanguages = []string{"", "en"}
+
+var userMenuCommands = []menuCommand{
+	{"help", map[string]string{"": "显示帮助菜单", "en": "Show the help menu"}},
+	{"getme", map[string]string{"": "查看我的信息", "en": "Show my profile"}},
+	{"notifications", map[string]string{"": "通知设置", "en": "Notification settings"}},
+	{"cancel", map[string]string{"": "取消当前操作", "en": "Cancel the current operation"}},
+}
+
+// Admins get the user commands plus the admin-only ones
+var adminMenuCommands = append(append([]menuCommand{}, userMenuCommands...),
+	menuCommand{"tickets", map[string]string{"": "查看所有工单", "en": "List all tickets"}},
+	menuCommand{"digest", map[string]string{"": "查看工单摘要", "en": "Show the ticket digest"}},
+	menuCommand{"broadcast", map[string]string{"": "发送公告", "en": "Broadcast an announcement"}},
+	menuCommand{"apitoken", map[string]string{"": "管理 API 令牌", "en": "Manage API tokens"}},
+	menuCommand{"webhooks", map[string]string{"": "查看 Webhook 投递记录", "en": "Show webhook deliveries"}},
+)
+
+func botCommands(commands []menuCommand, language string) []tgbotapi.BotCommand {
+	result := make([]tgbotapi.BotCommand, 0, len(commands))
+	for _, command := range commands {
+		description, ok := command.Descriptions[language]
+		if !ok {
+			description = command.Descriptions[""]
+		}
+		result = append(result, tgbotapi.BotCommand{Command: command.Command, Description: description})
+	}
+	return result
+}
+
+func (b *Bot) setCommands(scope tgbotapi.BotCommandScope, commands []menuCommand) error {
+	for _, language := range menuLanguages {
+		config := tgbotapi.NewSetMyCommandsWithScopeAndLanguage(scope, language, botCommands(commands, language)...)
+		if _, err := b.api.Request(config); err != nil {
+			return fmt.Errorf("[ERROR] Failed to set commands for scope %s (language %q): %v", scope.Type, language, err)
+		}
+	}
+	return nil
+}
+
+func (b *Bot) deleteCommands(scope tgbotapi.BotCommandScope) error {
+	for _, language := range menuLanguages {
+		config := tgbotapi.NewDeleteMyCommandsWithScopeAndLanguage(scope, language)
+		if _, err := b.api.Request(config); err != nil {
+			return fmt.Errorf("[ERROR] Failed to delete commands for scope %s (language %q): %v", scope.Type, language, err)
+		}
+	}
+	return nil
+}
+
+// SyncCommands registers the regular user command menu and a per-chat admin menu for every admin.
+// registered holds the admins whose menus were set by the previous sync, nil for the first sync; the new
+// set is returned.
+func (b *Bot) SyncCommands(registered map[int64]bool) (map[int64]bool, error) {
+	db, err := database.InitializeDB()
+	if err != nil {
+		return registered, fmt.Errorf("[ERROR] Failed to get database connection: %v", err)
+	}
+
+	admins, err := database.GetAdmins(db, b.tenant)
+	if err != nil {
+		return registered, err
+	}
+
+	// The first sync cannot tell who was given the admin menu before the bot started, so every known user
+	// who is no longer an admin has theirs removed
+	previous := registered
+	if registered == nil {
+		if err := b.setCommands(tgbotapi.NewBotCommandScopeAllPrivateChats(), userMenuCommands); err != nil {
+			return registered, err
+		}
+		users, err := database.GetUserTelegramIDs(db, b.tenant)
+		if err != nil {
+			return registered, err
+		}
+		previous = make(map[int64]bool, len(users))
+		for _, telegramID := range users {
+			previous[telegramID] = true
+		}
+	}
+
+	current := make(map[int64]bool)
+	for _, admin := range admins {
+		if registered[admin.TelegramID] {
+			current[admin.TelegramID] = true
+			continue
+		}
+		if err := b.setCommands(tgbotapi.NewBotCommandScopeChat(admin.TelegramID), adminMenuCommands); err != nil {
+			b.logger.Error("Failed to register admin commands", "admin_id", admin.AdminID, "error", err)
+			continue
+		}
+		current[admin.TelegramID] = true
+	}
+
+	// Admins removed since the last sync fall back to the regular user menu
+	for telegramID := range previous {
+		if current[telegramID] {
+			continue
+		}
+		if err := b.deleteCommands(tgbotapi.NewBotCommandScopeChat(telegramID)); err != nil {
+			b.logger.Error("Failed to remove admin commands", "chat_id", telegramID, "error", err)
+			current[telegramID] = true
+		}
+	}
+
+	return current, nil
+}
+
+// SyncAdminCommands gives telegramID the admin menu if they are an admin of the tenant, and the regular
+// user menu otherwise
+func (b *Bot) SyncAdminCommands(telegramID int64) error {
+	db, err := database.InitializeDB()
+	if err != nil {
+		return fmt.Errorf("[ERROR] Failed to get database connection: %v", err)
+	}
+
+	scope := tgbotapi.NewBotCommandScopeChat(telegramID)
+	if _, err := database.GetAdminIDByTelegramID(db, b.tenant, telegramID); err == gorm.ErrRecordNotFound {
+		return b.deleteCommands(scope)
+	} else if err != nil {
+		return fmt.Errorf("[ERROR] Failed to check admin status: %v", err)
+	}
+	return b.setCommands(scope, adminMenuCommands)
+}
+
+// StartCommandSync registers the command menus now and re-syncs them periodically as admins change
+func (b *Bot) StartCommandSync(interval time.Duration) {
+	go func() {
+		var registered map[int64]bool
+		for {
+			synced, err := b.SyncCommands(registered)
+			if err != nil {
+				b.logger.Error("Failed to sync bot commands", "error", err)
+			} else {
+				registered = synced
+			}
+			time.Sleep(interval)
+		}
+	}()
+}
@@ -0,0 +1,84 @@
+package telegram
+
+import (
+	"fmt"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// How often abandoned conversations are looked for
+const conversationExpiryInterval = time.Minute
+
+// Mark the chat's conversation as active now
+func (b *Bot) touchConversation(chatID int64) {
+	b.conversationTouchedAt[chatID] = time.Now()
+}
+
+// Move the chat's conversation to state
+func (b *Bot) setUserState(chatID int64, state string) {
+	b.userStates[chatID] = state
+	b.touchConversation(chatID)
+}
+
+// Drop every piece of the chat's pending conversation
+func (b *Bot) clearConversation(chatID int64) {
+	delete(b.userStates, chatID)
+	delete(b.ticketData, chatID)
+	delete(b.broadcastDrafts, chatID)
+	delete(b.conversationTouchedAt, chatID)
+}
+
+func (b *Bot) hasConversation(chatID int64) bool {
+	_, drafting := b.broadcastDrafts[chatID]
+	return b.userStates[chatID] != StateNone || drafting
+}
+
+// Describe the pending conversation for reminders
+func (b *Bot) conversationLabel(chatID int64) string {
+	switch b.userStates[chatID] {
+	case StateWaitingForTitle, StateWaitingForDesc:
+		return "创建工单"
+	case StateWaitingForComment:
+		if data, ok := b.ticketData[chatID]; ok {
+			return fmt.Sprintf("回复工单 #%d", data.TicketID)
+		}
+		return "回复工单"
+	default:
+		return "发送公告"
+	}
+}
+
+// HandleCancelCommand abandons whatever multi-step operation the chat is in
+func (b *Bot) HandleCancelCommand(message *tgbotapi.Message) error {
+	chatID := message.Chat.ID
+	if !b.hasConversation(chatID) {
+		return b.SendMessage(chatID, "当前没有进行中的操作。")
+	}
+
+	label := b.conversationLabel(chatID)
+	b.clearConversation(chatID)
+	return b.SendMessage(chatID, fmt.Sprintf("已取消当前操作（%s）。", label))
+}
+
+// Remind a chat that issued another command in the middle of a conversation that it is still pending
+func (b *Bot) remindPendingConversation(chatID int64) error {
+	b.touchConversation(chatID)
+	return b.SendMessage(chatID, fmt.Sprintf("您仍有未完成的操作（%s），请继续输入，或发送 /cancel 取消。", b.conversationLabel(chatID)))
+}
+
+// Cancel conversations that saw no activity within the configured timeout and tell their chats
+func (b *Bot) expireConversations() {
+	timeout := time.Duration(b.settings().Telegram.ConversationTimeout) * time.Minute
+	now := time.Now()
+
+	for chatID, touchedAt := range b.conversationTouchedAt {
+		if now.Sub(touchedAt) < timeout {
+			continue
+		}
+		b.clearConversation(chatID)
+		if err := b.SendMessage(chatID, "由于长时间未操作，当前操作已取消。"); err != nil {
+			b.logger.Error("Failed to notify chat of expired conversation", "chat_id", chatID, "error", err)
+		}
+	}
+}
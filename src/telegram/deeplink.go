@@ -0,0 +1,177 @@
+package telegram
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"telegram-tickets-bot/src/database"
+	"telegram-tickets-bot/src/tickets"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"gorm.io/gorm"
+)
+
+// Length of the hex encoded signature at the end of a src_<source>_<signature> start parameter
+const sourceSignatureLength = 16
+
+// Sources fit into the 64 character start parameter together with the prefix and signature
+var sourcePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,43}$`)
+
+// SignSource returns the start parameter that attributes a user to source, signed with secret.
+// Links to the bot look like https://t.me/<bot>?start=<parameter>.
+func SignSource(secret string, source string) string {
+	return "src_" + source + "_" + sourceSignature(secret, source)
+}
+
+func sourceSignature(secret string, source string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(source))
+	return hex.EncodeToString(mac.Sum(nil))[:sourceSignatureLength]
+}
+
+// Extract the source of a signed src_ start parameter, reporting whether the signature is valid
+func (b *Bot) verifySource(payload string) (string, bool) {
+	if b.settings().DeepLink.Secret == "" {
+		return "", false
+	}
+
+	signed := strings.TrimPrefix(payload, "src_")
+	sep := len(signed) - sourceSignatureLength - 1
+	if sep < 1 || signed[sep] != '_' {
+		return "", false
+	}
+
+	source, signature := signed[:sep], signed[sep+1:]
+	if !sourcePattern.MatchString(source) {
+		return "", false
+	}
+	expected := sourceSignature(b.settings().DeepLink.Secret, source)
+	return source, hmac.Equal([]byte(signature), []byte(expected))
+}
+
+// Display name of a ticket category, falling back to its key when it is no longer configured
+func (b *Bot) categoryName(key string) string {
+	for _, category := range b.settings().Categories {
+		if category.Key == key {
+			return category.Name
+		}
+	}
+	return key
+}
+
+func (b *Bot) isCategory(key string) bool {
+	for _, category := range b.settings().Categories {
+		if category.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// Report whether the user may see the ticket: admins see every ticket, users only their own
+func (b *Bot) canAccessTicket(db *gorm.DB, ticket *tickets.Ticket, telegramID int64) (bool, error) {
+	isAdmin, err := database.IsUserAdmin(b.tenant, telegramID)
+	if err != nil {
+		return false, fmt.Errorf("[ERROR] Failed to check admin status: %v", err)
+	}
+	if isAdmin {
+		return true, nil
+	}
+
+	userID, err := database.GetUserIDByTelegramID(db, b.tenant, telegramID)
+	if err == gorm.ErrRecordNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("[ERROR] Failed to get user ID: %v", err)
+	}
+	return ticket.CreatedBy == userID, nil
+}
+
+// HandleStartCommand handles /start, following the deep-link parameter of t.me/<bot>?start=<payload> links
+func (b *Bot) HandleStartCommand(logger *slog.Logger, message *tgbotapi.Message) error {
+	payload := message.CommandArguments()
+	if payload == "" || !message.Chat.IsPrivate() {
+		return b.HandleHelpCommand(message)
+	}
+
+	switch {
+	case strings.HasPrefix(payload, "ticket_"):
+		ticketID, err := strconv.Atoi(strings.TrimPrefix(payload, "ticket_"))
+		if err != nil {
+			break
+		}
+		return b.openTicketLink(logger, message, ticketID)
+	case strings.HasPrefix(payload, "new_"):
+		return b.startCategoryTicket(message.Chat.ID, strings.TrimPrefix(payload, "new_"))
+	case strings.HasPrefix(payload, "src_"):
+		if err := b.recordSource(logger, message, payload); err != nil {
+			logger.Error("Failed to record user source", "error", err)
+		}
+	}
+
+	return b.HandleHelpCommand(message)
+}
+
+// Show the linked ticket if the user may access it
+func (b *Bot) openTicketLink(logger *slog.Logger, message *tgbotapi.Message, ticketID int) error {
+	db, err := database.InitializeDB()
+	if err != nil {
+		return fmt.Errorf("[ERROR] Failed to get database connection: %v", err)
+	}
+
+	ticket, err := tickets.GetTicketByID(db, b.tenant, ticketID)
+	if err == gorm.ErrRecordNotFound {
+		return b.SendMessage(message.Chat.ID, "工单不存在或您无权查看。")
+	} else if err != nil {
+		return fmt.Errorf("[ERROR] Failed to get ticket information: %v", err)
+	}
+
+	allowed, err := b.canAccessTicket(db, ticket, message.From.ID)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		logger.Info("User opened a link to a ticket without access", "ticket_id", ticketID)
+		return b.SendMessage(message.Chat.ID, "工单不存在或您无权查看。")
+	}
+
+	return b.ShowTicket(logger, message.Chat.ID, 0, message.From.ID, ticketID, 0)
+}
+
+// Start the ticket creation flow with the category preselected
+func (b *Bot) startCategoryTicket(chatID int64, category string) error {
+	if !b.isCategory(category) {
+		return b.SendMessage(chatID, "该工单类别不存在，请通过 /help 创建工单。")
+	}
+
+	b.clearConversation(chatID)
+	b.setUserState(chatID, StateWaitingForTitle)
+	b.ticketData[chatID] = &tickets.TicketCreationData{Category: category}
+
+	return b.SendMessage(chatID, fmt.Sprintf("工单类别：%s\n请输入工单标题：", b.categoryName(category)))
+}
+
+// Register the user and store the source of a signed src_ parameter
+func (b *Bot) recordSource(logger *slog.Logger, message *tgbotapi.Message, payload string) error {
+	source, ok := b.verifySource(payload)
+	if !ok {
+		logger.Info("Ignoring unsigned or invalid source parameter")
+		return nil
+	}
+
+	db, err := database.InitializeDB()
+	if err != nil {
+		return fmt.Errorf("[ERROR] Failed to get database connection: %v", err)
+	}
+
+	if _, err := database.CheckAndRegisterUser(db, b.tenant, message.From.ID); err != nil {
+		return err
+	}
+	return database.SetUserSource(db, b.tenant, message.From.ID, source)
+}
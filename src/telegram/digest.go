@@ -0,0 +1,262 @@
+package telegram
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"telegram-tickets-bot/src/config"
+	"telegram-tickets-bot/src/database"
+	"telegram-tickets-bot/src/tickets"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"gorm.io/gorm"
+)
+
+const (
+	// Tickets listed per digest section
+	digestListLimit = 10
+	// Ticket buttons attached to a digest
+	digestButtonLimit = 20
+	// Maximum length of a ticket title in a digest line
+	digestTitleLength = 40
+	// How often the digest schedule is checked
+	adminDigestCheckInterval = time.Minute
+)
+
+// Tickets shared by the digests of all admins
+type adminDigestData struct {
+	weekly     bool
+	unassigned []tickets.Ticket
+	waiting    []tickets.WaitingTicket
+	breaches   []tickets.WaitingTicket
+	created    int64
+	closed     int64
+}
+
+// Return the next scheduled admin digest after now and whether it is the weekly one; false if none is scheduled
+func (b *Bot) nextAdminDigest(now time.Time) (time.Time, bool, bool) {
+	digest := b.settings().Digest
+	weeklyDay, weekly := config.ParseWeekday(digest.WeeklyDay)
+
+	at := func(day time.Time, clock string) time.Time {
+		t, _ := time.Parse("15:04", clock)
+		return time.Date(day.Year(), day.Month(), day.Day(), t.Hour(), t.Minute(), 0, 0, day.Location())
+	}
+
+	for i := 0; i <= 7; i++ {
+		day := now.AddDate(0, 0, i)
+		if weekly && day.Weekday() == weeklyDay {
+			if next := at(day, digest.WeeklyTime); next.After(now) {
+				return next, true, true
+			}
+			continue
+		}
+		if digest.DailyTime != "" {
+			if next := at(day, digest.DailyTime); next.After(now) {
+				return next, false, true
+			}
+		}
+	}
+	return time.Time{}, false, false
+}
+
+// StartAdminDigests sends the daily and weekly admin digests on schedule in the background. The schedule is
+// read on every check, so digests enabled or moved by a configuration reload take effect right away.
+func (b *Bot) StartAdminDigests() {
+	go func() {
+		checked := time.Now()
+		ticker := time.NewTicker(adminDigestCheckInterval)
+		defer ticker.Stop()
+
+		for now := range ticker.C {
+			next, weekly, ok := b.nextAdminDigest(checked)
+			checked = now
+			if !ok || next.After(now) {
+				continue
+			}
+			if err := b.SendAdminDigests(weekly); err != nil {
+				b.logger.Error("Failed to send admin digests", "error", err)
+			}
+		}
+	}()
+}
+
+func (b *Bot) loadAdminDigestData(db *gorm.DB, weekly bool) (*adminDigestData, error) {
+	data := &adminDigestData{weekly: weekly}
+
+	var err error
+	if data.unassigned, err = tickets.GetUnassignedTickets(db, b.tenant); err != nil {
+		return nil, err
+	}
+	if data.waiting, err = tickets.GetTicketsWaitingOnStaff(db, b.tenant); err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(-time.Duration(b.settings().Digest.SLAHours) * time.Hour)
+	for _, ticket := range data.waiting {
+		if ticket.WaitingSince.Before(deadline) {
+			data.breaches = append(data.breaches, ticket)
+		}
+	}
+
+	if weekly {
+		since := time.Now().AddDate(0, 0, -7)
+		if data.created, err = tickets.CountTicketsCreatedSince(db, b.tenant, since); err != nil {
+			return nil, err
+		}
+		if data.closed, err = tickets.CountTicketsClosedSince(db, b.tenant, since); err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+// SendAdminDigests sends every admin of the tenant their digest
+func (b *Bot) SendAdminDigests(weekly bool) error {
+	db, err := database.InitializeDB()
+	if err != nil {
+		return fmt.Errorf("[ERROR] Failed to get database connection: %v", err)
+	}
+
+	admins, err := database.GetAdmins(db, b.tenant)
+	if err != nil {
+		return err
+	}
+
+	data, err := b.loadAdminDigestData(db, weekly)
+	if err != nil {
+		return err
+	}
+
+	for _, admin := range admins {
+		if err := b.sendAdminDigest(db, admin, data, false); err != nil {
+			b.logger.Error("Failed to send admin digest", "admin_id", admin.AdminID, "error", err)
+		}
+	}
+	return nil
+}
+
+// HandleDigestCommand sends the requesting admin their daily digest right away
+func (b *Bot) HandleDigestCommand(message *tgbotapi.Message) error {
+	db, err := database.InitializeDB()
+	if err != nil {
+		return fmt.Errorf("[ERROR] Failed to get database connection: %v", err)
+	}
+
+	adminID, err := database.GetAdminIDByTelegramID(db, b.tenant, message.From.ID)
+	if err == gorm.ErrRecordNotFound {
+		return b.SendMessage(message.Chat.ID, "对不起，只有管理员可以使用此命令。")
+	} else if err != nil {
+		return fmt.Errorf("[ERROR] Failed to get admin ID: %v", err)
+	}
+
+	admin, err := database.GetAdminByID(db, adminID)
+	if err != nil {
+		return err
+	}
+
+	data, err := b.loadAdminDigestData(db, false)
+	if err != nil {
+		return err
+	}
+	return b.sendAdminDigest(db, *admin, data, true)
+}
+
+// Send one admin's digest. Scheduled daily digests without any ticket to mention are skipped unless always is set.
+func (b *Bot) sendAdminDigest(db *gorm.DB, admin database.AdminUser, data *adminDigestData, always bool) error {
+	assigned, err := tickets.GetOpenTicketsAssignedTo(db, b.tenant, admin.AdminID)
+	if err != nil {
+		return err
+	}
+
+	empty := len(assigned) == 0 && len(data.unassigned) == 0 && len(data.waiting) == 0
+	if empty && !data.weekly && !always {
+		return nil
+	}
+
+	now := time.Now()
+	var text strings.Builder
+	var buttonTickets []int
+
+	if data.weekly {
+		fmt.Fprintf(&text, "<b>每周工单摘要</b> (%s)\n", now.Format("2006-01-02"))
+		fmt.Fprintf(&text, "%s\n%s", htmlField("本周新建", fmt.Sprint(data.created)), htmlField("本周关闭", fmt.Sprint(data.closed)))
+	} else {
+		fmt.Fprintf(&text, "<b>每日工单摘要</b> (%s)", now.Format("2006-01-02"))
+	}
+	if empty {
+		text.WriteString("\n\n当前没有待处理的工单。")
+	}
+
+	writeSection := func(title string, count int, line func(i int) (int, string)) {
+		if count == 0 {
+			return
+		}
+		fmt.Fprintf(&text, "\n\n<b>%s (%d)</b>", escapeHTML(title), count)
+		for i := 0; i < count && i < digestListLimit; i++ {
+			ticketID, entry := line(i)
+			text.WriteString("\n" + entry)
+			buttonTickets = append(buttonTickets, ticketID)
+		}
+		if count > digestListLimit {
+			fmt.Fprintf(&text, "\n…另有 %d 个", count-digestListLimit)
+		}
+	}
+
+	writeSection("SLA 超时", len(data.breaches), func(i int) (int, string) {
+		ticket := data.breaches[i]
+		return ticket.TicketID, digestLine(&ticket.Ticket, "已等待 "+formatAge(now.Sub(ticket.WaitingSince)))
+	})
+	writeSection("我负责的未关闭工单", len(assigned), func(i int) (int, string) {
+		return assigned[i].TicketID, digestLine(&assigned[i], "已创建 "+formatAge(now.Sub(assigned[i].CreatedAt)))
+	})
+	writeSection("未分配工单", len(data.unassigned), func(i int) (int, string) {
+		ticket := data.unassigned[i]
+		return ticket.TicketID, digestLine(&ticket, "已创建 "+formatAge(now.Sub(ticket.CreatedAt)))
+	})
+	writeSection("等待 Staff 回复", len(data.waiting), func(i int) (int, string) {
+		ticket := data.waiting[i]
+		return ticket.TicketID, digestLine(&ticket.Ticket, "已等待 "+formatAge(now.Sub(ticket.WaitingSince)))
+	})
+
+	return b.SendHTMLWithInlineKeyboard(admin.TelegramID, text.String(), digestKeyboard(buttonTickets))
+}
+
+func digestLine(ticket *tickets.Ticket, age string) string {
+	return fmt.Sprintf("#%d [%s] %s · %s",
+		ticket.TicketID, escapeHTML(ticket.Priority), escapeHTML(truncateText(ticket.Title, digestTitleLength)), escapeHTML(age))
+}
+
+// Buttons opening the listed tickets, two per row, each ticket once
+func digestKeyboard(ticketIDs []int) tgbotapi.InlineKeyboardMarkup {
+	keyboard := emptyKeyboard()
+	seen := make(map[int]bool)
+	var row []tgbotapi.InlineKeyboardButton
+	for _, ticketID := range ticketIDs {
+		if seen[ticketID] || len(seen) == digestButtonLimit {
+			continue
+		}
+		seen[ticketID] = true
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("#%d", ticketID), fmt.Sprintf("view_ticket_%d", ticketID)))
+		if len(row) == 2 {
+			keyboard.InlineKeyboard = append(keyboard.InlineKeyboard, row)
+			row = nil
+		}
+	}
+	if len(row) > 0 {
+		keyboard.InlineKeyboard = append(keyboard.InlineKeyboard, row)
+	}
+	return keyboard
+}
+
+func formatAge(d time.Duration) string {
+	switch {
+	case d >= 24*time.Hour:
+		return fmt.Sprintf("%d天", int(d/(24*time.Hour)))
+	case d >= time.Hour:
+		return fmt.Sprintf("%d小时", int(d/time.Hour))
+	default:
+		return fmt.Sprintf("%d分钟", int(d/time.Minute))
+	}
+}
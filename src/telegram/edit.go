@@ -0,0 +1,134 @@
+package telegram
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"unicode"
+
+	"telegram-tickets-bot/src/database"
+	"telegram-tickets-bot/src/tickets"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"gorm.io/gorm"
+)
+
+// Edits changing fewer characters than this (typo fixes) are applied without notifying anyone
+const significantEditLength = 3
+
+func editedMarker(comment tickets.TicketComment) string {
+	if comment.EditedAt == nil {
+		return ""
+	}
+	return " (已编辑)"
+}
+
+// Report whether an edit changes more than whitespace, letter case or a few characters
+func isSignificantEdit(previous string, content string) bool {
+	normalize := func(text string) []rune {
+		return []rune(strings.ToLower(strings.Join(strings.FieldsFunc(text, unicode.IsSpace), " ")))
+	}
+	before, after := normalize(previous), normalize(content)
+
+	// Only the middle part between the common prefix and suffix was changed
+	start := 0
+	for start < len(before) && start < len(after) && before[start] == after[start] {
+		start++
+	}
+	endBefore, endAfter := len(before), len(after)
+	for endBefore > start && endAfter > start && before[endBefore-1] == after[endAfter-1] {
+		endBefore--
+		endAfter--
+	}
+
+	return endBefore-start >= significantEditLength || endAfter-start >= significantEditLength
+}
+
+// HandleEditedMessage applies the edit of a message that was added to a ticket as a comment
+func (b *Bot) HandleEditedMessage(logger *slog.Logger, message *tgbotapi.Message) error {
+	if message.From == nil || message.From.IsBot || message.Text == "" {
+		return nil
+	}
+	if !message.Chat.IsPrivate() && !(b.forumEnabled() && message.Chat.ID == b.settings().Forum.ChatID) {
+		return nil
+	}
+
+	db, err := database.InitializeDB()
+	if err != nil {
+		return fmt.Errorf("[ERROR] Failed to get database connection: %v", err)
+	}
+
+	comment, err := tickets.GetCommentByMessage(db, b.tenant, message.Chat.ID, message.MessageID)
+	if err == gorm.ErrRecordNotFound {
+		// Not a comment, e.g. a ticket title or an ordinary message
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("[ERROR] Failed to get comment by message: %v", err)
+	}
+	if comment.Content == message.Text {
+		return nil
+	}
+
+	ticket, err := tickets.GetTicketByID(db, b.tenant, comment.TicketID)
+	if err != nil {
+		return fmt.Errorf("[ERROR] Failed to get ticket: %v", err)
+	}
+	if ticket.Status == "closed" {
+		if message.Chat.IsPrivate() {
+			return b.SendMessage(message.Chat.ID, fmt.Sprintf("工单 #%d 已关闭，编辑未同步。", ticket.TicketID))
+		}
+		return nil
+	}
+
+	if err := tickets.EditComment(db, comment, message.Text); err != nil {
+		return err
+	}
+	logger.Info("Comment edited", "ticket_id", ticket.TicketID, "comment_id", comment.CommentID)
+	return nil
+}
+
+// NotifyCommentEdited tells the other side of the conversation that a comment was changed.
+// inTopic reports whether the edit was made in the ticket's forum topic, which then needs no mirror.
+func (b *Bot) NotifyCommentEdited(ticket *tickets.Ticket, comment *tickets.TicketComment, previous string, inTopic bool) error {
+	db, err := database.InitializeDB()
+	if err != nil {
+		return fmt.Errorf("[ERROR] Failed to get database connection: %v", err)
+	}
+
+	text := fmt.Sprintf("<b>工单 #%d 的一条回复已被编辑：</b>\n<b>原内容:</b>\n%s\n<b>新内容:</b>\n%s",
+		ticket.TicketID, htmlQuote(previous), htmlContent(comment.Content))
+
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("查看工单历史", fmt.Sprintf("view_ticket_%d", ticket.TicketID)),
+			tgbotapi.NewInlineKeyboardButtonData("回复", fmt.Sprintf("reply_ticket_%d", ticket.TicketID)),
+		),
+	)
+
+	if !inTopic {
+		if err := b.MirrorToTicketTopic(ticket.TicketID, text); err != nil {
+			b.logger.Error("Failed to mirror comment edit to forum topic", "ticket_id", ticket.TicketID, "error", err)
+		}
+	}
+
+	if comment.AdminID != nil {
+		if ticket.Channel == tickets.ChannelEmail {
+			// The reply has already been mailed and cannot be changed
+			return nil
+		}
+		userTelegramID, err := database.GetTelegramIDByUserID(db, ticket.CreatedBy)
+		if err != nil {
+			return err
+		}
+		return b.Notify(userTelegramID, database.EventComment, ticket.TicketID, text, keyboard)
+	}
+
+	if ticket.AssignedTo == nil {
+		return nil
+	}
+	admin, err := database.GetAdminByID(db, *ticket.AssignedTo)
+	if err != nil {
+		return err
+	}
+	return b.Notify(admin.TelegramID, database.EventComment, ticket.TicketID, text, keyboard)
+}
@@ -0,0 +1,135 @@
+package telegram
+
+import (
+	"fmt"
+
+	"telegram-tickets-bot/src/database"
+	"telegram-tickets-bot/src/tickets"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// The bot subscribes to the ticket event bus to announce changes in Telegram, so a change looks the same
+// to users and admins wherever it was made: in the bot, the dashboard, the REST API or by email.
+
+// StartWebhookDeliveries delivers the tenant's ticket events to its webhooks in the background
+func (b *Bot) StartWebhookDeliveries() {
+	b.webhooks.Start()
+}
+
+// Announce the tenant's ticket events to the users, admins and forum topic concerned
+func (b *Bot) handleTicketEvent(event tickets.Event) {
+	if event.EventTicket().Tenant != b.tenant {
+		return
+	}
+
+	switch e := event.(type) {
+	case tickets.TicketCreated:
+		b.ticketCreated(e.Ticket)
+	case tickets.CommentAdded:
+		if e.Admin != nil {
+			b.adminCommentAdded(e.Ticket, e.Admin, e.Comment)
+		} else {
+			b.userCommentAdded(e.Ticket, e.Comment)
+		}
+	case tickets.CommentEdited:
+		b.commentEdited(e.Ticket, e.Comment, e.Previous)
+	case tickets.TicketAssigned:
+		b.ticketAssigned(e.Ticket, e.Admin)
+	case tickets.StatusChanged:
+		b.ticketStatusChanged(e.Ticket, e.ActorTelegramID)
+	}
+}
+
+// Announce a new ticket to the tenant's admins
+func (b *Bot) ticketCreated(ticket *tickets.Ticket) {
+	if err := b.NotifyAllAdmins(ticket); err != nil {
+		b.logger.Error("Failed to notify admins", "ticket_id", ticket.TicketID, "error", err)
+	}
+}
+
+// Tell the assigned admin and the forum topic about a user's comment
+func (b *Bot) userCommentAdded(ticket *tickets.Ticket, comment *tickets.TicketComment) {
+	if ticket.AssignedTo != nil {
+		if err := b.NotifyAssignedAdmin(ticket, comment); err != nil {
+			b.logger.Error("Failed to notify assigned admin", "ticket_id", ticket.TicketID, "error", err)
+		}
+	}
+
+	if err := b.MirrorToTicketTopic(ticket.TicketID, "<b>[用户] 新回复:</b>\n"+htmlContent(comment.Content)); err != nil {
+		b.logger.Error("Failed to mirror comment to forum topic", "ticket_id", ticket.TicketID, "error", err)
+	}
+
+	b.refreshTicketCards(ticket.TicketID)
+}
+
+// Tell the ticket creator and the forum topic about an admin's reply
+func (b *Bot) adminCommentAdded(ticket *tickets.Ticket, admin *database.AdminUser, comment *tickets.TicketComment) {
+	if err := b.NotifyTicketCreator(ticket, comment.Content); err != nil {
+		b.logger.Error("Failed to notify ticket creator", "ticket_id", ticket.TicketID, "error", err)
+	}
+
+	// Replies written in the ticket's topic are already there
+	if !b.writtenInTopic(comment) {
+		message := fmt.Sprintf("<b>[Staff] %s:</b>\n%s", escapeHTML(admin.FullName), htmlContent(comment.Content))
+		if err := b.MirrorToTicketTopic(ticket.TicketID, message); err != nil {
+			b.logger.Error("Failed to mirror admin comment to forum topic", "ticket_id", ticket.TicketID, "error", err)
+		}
+	}
+
+	b.refreshTicketCards(ticket.TicketID)
+}
+
+// Sync the ticket's cards with an edited comment and tell the other side about significant edits
+func (b *Bot) commentEdited(ticket *tickets.Ticket, comment *tickets.TicketComment, previous string) {
+	b.refreshTicketCards(ticket.TicketID)
+
+	if !isSignificantEdit(previous, comment.Content) {
+		return
+	}
+	if err := b.NotifyCommentEdited(ticket, comment, previous, b.writtenInTopic(comment)); err != nil {
+		b.logger.Error("Failed to notify comment edit", "ticket_id", ticket.TicketID, "error", err)
+	}
+}
+
+// Tell the admin that the ticket was assigned to them
+func (b *Bot) ticketAssigned(ticket *tickets.Ticket, admin *database.AdminUser) {
+	b.refreshTicketCards(ticket.TicketID)
+
+	message := "<b>工单已分配给您</b>\n" + ticketSummaryHTML(ticket)
+
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("查看工单", fmt.Sprintf("view_ticket_%d", ticket.TicketID)),
+		),
+	)
+
+	if err := b.Notify(admin.TelegramID, database.EventAssignment, ticket.TicketID, message, keyboard); err != nil {
+		b.logger.Error("Failed to notify assigned admin", "ticket_id", ticket.TicketID, "error", err)
+	}
+}
+
+// Sync the forum topic and the ticket's cards with its new status and notify everyone involved
+// except actorTelegramID, which is 0 for changes made outside Telegram
+func (b *Bot) ticketStatusChanged(ticket *tickets.Ticket, actorTelegramID int64) {
+	if err := b.UpdateTicketTopic(ticket); err != nil {
+		b.logger.Error("Failed to update forum topic", "ticket_id", ticket.TicketID, "error", err)
+	}
+
+	if err := b.NotifyStatusChange(ticket, actorTelegramID); err != nil {
+		b.logger.Error("Failed to notify status change", "ticket_id", ticket.TicketID, "error", err)
+	}
+
+	b.refreshTicketCards(ticket.TicketID)
+}
+
+// Whether the comment was written in the tenant's forum, i.e. in the ticket's topic
+func (b *Bot) writtenInTopic(comment *tickets.TicketComment) bool {
+	return b.forumEnabled() && comment.ChatID == b.settings().Forum.ChatID
+}
+
+func (b *Bot) refreshTicketCards(ticketID int) {
+	if err := b.RefreshTicketCards(ticketID); err != nil {
+		b.logger.Error("Failed to refresh ticket cards", "ticket_id", ticketID, "error", err)
+	}
+}
@@ -0,0 +1,237 @@
+package telegram
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"telegram-tickets-bot/src/database"
+	"telegram-tickets-bot/src/tickets"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"gorm.io/gorm"
+)
+
+// Maximum length of a forum topic name allowed by Telegram
+const maxTopicNameLength = 128
+
+type topicMessageKey struct {
+	chatID    int64
+	messageID int
+}
+
+// An incoming message with the forum topic fields that tgbotapi does not decode
+type topicMessage struct {
+	tgbotapi.Message
+	MessageThreadID int  `json:"message_thread_id"`
+	IsTopicMessage  bool `json:"is_topic_message"`
+}
+
+// An update decoded together with the topic fields of its message
+type incomingUpdate struct {
+	tgbotapi.Update
+	Message *topicMessage `json:"message"`
+}
+
+func (b *Bot) forumEnabled() bool {
+	return b.settings().Forum.ChatID != 0
+}
+
+// Return the forum topic thread ID of a message, or 0 if it was not sent in a topic
+func (b *Bot) messageThreadID(message *tgbotapi.Message) int {
+	threadID, ok := b.topicThreads.LoadAndDelete(topicMessageKey{message.Chat.ID, message.MessageID})
+	if !ok {
+		return 0
+	}
+	return threadID.(int)
+}
+
+func topicName(ticket *tickets.Ticket) string {
+	name := fmt.Sprintf("#%d %s", ticket.TicketID, ticket.Title)
+	if ticket.Status == "closed" {
+		name = "[已关闭] " + name
+	}
+
+	runes := []rune(name)
+	if len(runes) > maxTopicNameLength {
+		name = string(runes[:maxTopicNameLength-1]) + "…"
+	}
+	return name
+}
+
+func (b *Bot) topicIconEmoji(ticket *tickets.Ticket) string {
+	if ticket.Status == "closed" {
+		return b.settings().Forum.ClosedIconEmoji
+	}
+	return b.settings().Forum.OpenIconEmoji
+}
+
+// OpenTicketTopic creates a forum topic for a new ticket and posts the ticket details into it
+func (b *Bot) OpenTicketTopic(ticket *tickets.Ticket) error {
+	db, err := database.InitializeDB()
+	if err != nil {
+		return fmt.Errorf("[ERROR] Failed to get database connection: %v", err)
+	}
+
+	params := make(tgbotapi.Params)
+	params.AddNonZero64("chat_id", b.settings().Forum.ChatID)
+	params["name"] = topicName(ticket)
+	params.AddNonEmpty("icon_custom_emoji_id", b.topicIconEmoji(ticket))
+
+	resp, err := b.makeRequest(b.settings().Forum.ChatID, "createForumTopic", params)
+	if err != nil {
+		return fmt.Errorf("[ERROR] Failed to create forum topic: %v", err)
+	}
+
+	var topic struct {
+		MessageThreadID int `json:"message_thread_id"`
+	}
+	if err := json.Unmarshal(resp.Result, &topic); err != nil {
+		return fmt.Errorf("[ERROR] Failed to decode forum topic: %v", err)
+	}
+
+	if err := tickets.SaveTicketTopic(db, ticket.TicketID, b.settings().Forum.ChatID, topic.MessageThreadID); err != nil {
+		return err
+	}
+
+	message := "<b>新工单已创建</b>\n" + ticketSummaryHTML(ticket) + "\n\n<i>在此话题中发送的消息将作为回复转发给用户。</i>"
+
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("分配工单", fmt.Sprintf("assign_ticket_%d", ticket.TicketID)),
+		),
+	)
+
+	return b.SendTopicMessage(topic.MessageThreadID, message, &keyboard)
+}
+
+// SendTopicMessage sends a Telegram HTML message into a topic of the admin forum group
+func (b *Bot) SendTopicMessage(threadID int, text string, keyboard *tgbotapi.InlineKeyboardMarkup) error {
+	chunks := splitHTMLMessage(text, maxMessageLength)
+	for i, chunk := range chunks {
+		params := make(tgbotapi.Params)
+		params.AddNonZero64("chat_id", b.settings().Forum.ChatID)
+		params.AddNonZero("message_thread_id", threadID)
+		params["text"] = chunk
+		params["parse_mode"] = tgbotapi.ModeHTML
+		if keyboard != nil && i == len(chunks)-1 {
+			if err := params.AddInterface("reply_markup", keyboard); err != nil {
+				return err
+			}
+		}
+
+		if _, err := b.makeRequest(b.settings().Forum.ChatID, "sendMessage", params); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MirrorToTicketTopic posts text into the ticket's forum topic, if forum mode is enabled and the topic exists
+func (b *Bot) MirrorToTicketTopic(ticketID int, text string) error {
+	if !b.forumEnabled() {
+		return nil
+	}
+
+	db, err := database.InitializeDB()
+	if err != nil {
+		return fmt.Errorf("[ERROR] Failed to get database connection: %v", err)
+	}
+
+	topic, err := tickets.GetTicketTopic(db, ticketID)
+	if err == gorm.ErrRecordNotFound {
+		// Ticket was created before forum mode was enabled
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("[ERROR] Failed to get ticket topic: %v", err)
+	}
+
+	return b.SendTopicMessage(topic.ThreadID, text, nil)
+}
+
+// UpdateTicketTopic syncs the topic title and icon with the ticket status, closing the topic for closed tickets
+func (b *Bot) UpdateTicketTopic(ticket *tickets.Ticket) error {
+	if !b.forumEnabled() {
+		return nil
+	}
+
+	db, err := database.InitializeDB()
+	if err != nil {
+		return fmt.Errorf("[ERROR] Failed to get database connection: %v", err)
+	}
+
+	topic, err := tickets.GetTicketTopic(db, ticket.TicketID)
+	if err == gorm.ErrRecordNotFound {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("[ERROR] Failed to get ticket topic: %v", err)
+	}
+
+	params := make(tgbotapi.Params)
+	params.AddNonZero64("chat_id", topic.ChatID)
+	params.AddNonZero("message_thread_id", topic.ThreadID)
+	params["name"] = topicName(ticket)
+	params.AddNonEmpty("icon_custom_emoji_id", b.topicIconEmoji(ticket))
+
+	if _, err := b.makeRequest(topic.ChatID, "editForumTopic", params); err != nil {
+		return fmt.Errorf("[ERROR] Failed to edit forum topic: %v", err)
+	}
+
+	if ticket.Status == "closed" {
+		if err := b.SendTopicMessage(topic.ThreadID, "<b>工单已关闭</b>", nil); err != nil {
+			b.logger.Error("Failed to post close notice to forum topic", "ticket_id", ticket.TicketID, "error", err)
+		}
+
+		closeParams := make(tgbotapi.Params)
+		closeParams.AddNonZero64("chat_id", topic.ChatID)
+		closeParams.AddNonZero("message_thread_id", topic.ThreadID)
+		if _, err := b.makeRequest(topic.ChatID, "closeForumTopic", closeParams); err != nil {
+			return fmt.Errorf("[ERROR] Failed to close forum topic: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// HandleForumMessage relays messages posted by admins in a ticket topic back to the ticket creator
+func (b *Bot) HandleForumMessage(message *tgbotapi.Message) error {
+	threadID := b.messageThreadID(message)
+	if threadID == 0 || message.From == nil || message.From.IsBot {
+		return nil
+	}
+	if message.IsCommand() || message.Text == "" {
+		return nil
+	}
+
+	db, err := database.InitializeDB()
+	if err != nil {
+		return fmt.Errorf("[ERROR] Failed to get database connection: %v", err)
+	}
+
+	ticketID, err := tickets.GetTicketIDByTopic(db, message.Chat.ID, threadID)
+	if err == gorm.ErrRecordNotFound {
+		// Not a ticket topic
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("[ERROR] Failed to get ticket by topic: %v", err)
+	}
+
+	adminID, err := database.GetAdminIDByTelegramID(db, b.tenant, message.From.ID)
+	if err == gorm.ErrRecordNotFound {
+		// Only registered admins may reply to users
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("[ERROR] Failed to get admin ID: %v", err)
+	}
+
+	if _, err := tickets.GetTicketByID(db, b.tenant, ticketID); err == gorm.ErrRecordNotFound {
+		// Topic of another tenant's ticket in a shared forum
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("[ERROR] Failed to get ticket: %v", err)
+	}
+
+	if _, err := tickets.AddAdminComment(db, ticketID, adminID, message.Text, message.Chat.ID, message.MessageID); err != nil {
+		return fmt.Errorf("[ERROR] Failed to add admin comment: %v", err)
+	}
+	return nil
+}
@@ -2,17 +2,17 @@ package telegram
 
 import (
 	"fmt"
-	"log"
+	"log/slog"
 	"strings"
 	"telegram-tickets-bot/src/database"
 	"telegram-tickets-bot/src/tickets"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"gorm.io/gorm"
 )
 
-// Store user's current conversation state
-var userStates = make(map[int64]string)
-var ticketData = make(map[int64]*tickets.TicketCreationData)
+// Number of comments shown per page in the ticket view
+const commentsPerPage = 5
 
 // Add new user states
 const (
@@ -20,6 +20,8 @@ const (
 	StateWaitingForTitle   = "waiting_for_title"
 	StateWaitingForDesc    = "waiting_for_description"
 	StateWaitingForComment = "waiting_for_comment"
+
+	StateWaitingForBroadcast = "waiting_for_broadcast"
 )
 
 func (b *Bot) HandleGetMeCommand(message *tgbotapi.Message) error {
@@ -41,7 +43,7 @@ func (b *Bot) HandleGetMeCommand(message *tgbotapi.Message) error {
 	}
 
 	// Check if user is registered, if not, register automatically
-	regularUser, err := database.CheckAndRegisterUser(db, int64(user.ID))
+	regularUser, err := database.CheckAndRegisterUser(db, b.tenant, int64(user.ID))
 	if err != nil {
 		return fmt.Errorf("[ERROR] Failed to check and register user: %v", err)
 	}
@@ -71,7 +73,7 @@ func (b *Bot) HandleGetMeCommand(message *tgbotapi.Message) error {
 		fileID := photos.Photos[0][0].FileID
 		photoMsg := tgbotapi.NewPhoto(message.Chat.ID, tgbotapi.FileID(fileID))
 		photoMsg.Caption = infoText
-		_, err = b.api.Send(photoMsg)
+		_, err = b.send(message.Chat.ID, photoMsg)
 	} else {
 		// User has no profile photo, send text message only
 		err = b.SendMessage(message.Chat.ID, infoText)
@@ -85,7 +87,7 @@ func (b *Bot) HandleGetMeCommand(message *tgbotapi.Message) error {
 }
 
 func (b *Bot) HandleHelpCommand(message *tgbotapi.Message) error {
-	isAdmin, err := database.IsUserAdmin(message.From.ID)
+	isAdmin, err := database.IsUserAdmin(b.tenant, message.From.ID)
 	if err != nil {
 		return fmt.Errorf("[ERROR] Failed to check admin status: %v", err)
 	}
@@ -114,24 +116,25 @@ func (b *Bot) HandleHelpCommand(message *tgbotapi.Message) error {
 		)
 	}
 
-	helpText := "欢迎使用帮助菜单,请选择以下选项:"
+	helpText := b.text("help", "欢迎使用帮助菜单,请选择以下选项:")
 	return b.SendMessageWithInlineKeyboard(message.Chat.ID, helpText, keyboard)
 }
 
-func (b *Bot) HandleCallbackQuery(callbackQuery *tgbotapi.CallbackQuery) error {
+func (b *Bot) HandleCallbackQuery(logger *slog.Logger, callbackQuery *tgbotapi.CallbackQuery) error {
 	chatID := callbackQuery.Message.Chat.ID
 	data := callbackQuery.Data
 
 	switch {
 	case data == "create_ticket":
-		userStates[chatID] = "waiting_for_title"
-		ticketData[chatID] = &tickets.TicketCreationData{}
+		b.clearConversation(chatID)
+		b.setUserState(chatID, StateWaitingForTitle)
+		b.ticketData[chatID] = &tickets.TicketCreationData{}
 		return b.SendMessage(chatID, "请输入工单标题：")
 	case data == "view_tickets":
-		return b.HandleViewTickets(&tgbotapi.Message{
+		return b.HandleViewTickets(logger, &tgbotapi.Message{
 			From: callbackQuery.From,
 			Chat: callbackQuery.Message.Chat,
-		})
+		}, callbackQuery.Message.MessageID)
 	case data == "get_info":
 		userMessage := &tgbotapi.Message{
 			From: callbackQuery.From,
@@ -140,15 +143,20 @@ func (b *Bot) HandleCallbackQuery(callbackQuery *tgbotapi.CallbackQuery) error {
 		}
 		return b.HandleGetMeCommand(userMessage)
 	case data == "confirm_ticket":
-		return b.CreateTicket(chatID)
+		return b.CreateTicket(logger, chatID)
 	case data == "cancel_ticket":
-		delete(userStates, chatID)
-		delete(ticketData, chatID)
+		b.clearConversation(chatID)
 		return b.SendMessage(chatID, "工单创建已取消。")
+	case strings.HasPrefix(data, "notif_"):
+		return b.HandleNotificationCallback(logger, callbackQuery)
+	case strings.HasPrefix(data, "broadcast_"):
+		return b.HandleBroadcastCallback(logger, callbackQuery)
+	case strings.HasPrefix(data, "ticket_page_"):
+		return b.HandleTicketView(logger, callbackQuery)
 	case data[:11] == "view_ticket":
-		return b.HandleTicketView(callbackQuery)
+		return b.HandleTicketView(logger, callbackQuery)
 	case data[:12] == "close_ticket":
-		return b.HandleCloseTicket(callbackQuery)
+		return b.HandleCloseTicket(logger, callbackQuery)
 	case data[:11] == "add_comment":
 		return b.HandleAddComment(callbackQuery)
 	case strings.HasPrefix(data, "assign_ticket_"):
@@ -166,119 +174,154 @@ func (b *Bot) HandleCallbackQuery(callbackQuery *tgbotapi.CallbackQuery) error {
 		if err != nil {
 			return fmt.Errorf("[ERROR] Failed to parse ticket ID: %v", err)
 		}
-		userStates[chatID] = StateWaitingForComment
-		ticketData[chatID] = &tickets.TicketCreationData{TicketID: ticketID}
+		b.clearConversation(chatID)
+		b.setUserState(chatID, StateWaitingForComment)
+		b.ticketData[chatID] = &tickets.TicketCreationData{TicketID: ticketID}
 		return b.SendMessage(chatID, "请输入您的回复：")
 	case data == "view_all_tickets":
-		return b.HandleAdminViewTickets(&tgbotapi.Message{
+		return b.HandleAdminViewTickets(logger, &tgbotapi.Message{
 			From: callbackQuery.From,
 			Chat: callbackQuery.Message.Chat,
-		})
+		}, callbackQuery.Message.MessageID)
 	default:
 		return b.SendMessage(chatID, "Unknown option.")
 	}
 }
 
-func (b *Bot) HandleMessage(message *tgbotapi.Message) error {
+func (b *Bot) HandleMessage(logger *slog.Logger, message *tgbotapi.Message) error {
 	chatID := message.Chat.ID
 	text := message.Text
 
 	// 检查用户是否为管理员
-	isAdmin, err := database.IsUserAdmin(message.From.ID)
+	isAdmin, err := database.IsUserAdmin(b.tenant, message.From.ID)
 	if err != nil {
 		return fmt.Errorf("[ERROR] Failed to check admin status: %v", err)
 	}
 
-	switch userStates[chatID] {
-	case "waiting_for_title":
-		ticketData[chatID].Title = text
-		userStates[chatID] = "waiting_for_description"
+	// Replying to a ticket notification comments on that ticket directly
+	if message.ReplyToMessage != nil {
+		handled, err := b.HandleNotificationReply(logger, message, isAdmin)
+		if handled {
+			return err
+		}
+	}
+
+	switch b.userStates[chatID] {
+	case StateWaitingForTitle:
+		b.ticketData[chatID].Title = text
+		b.setUserState(chatID, StateWaitingForDesc)
 		return b.SendMessage(chatID, "请输入工单描述：")
-	case "waiting_for_description":
-		ticketData[chatID].Description = text
+	case StateWaitingForDesc:
+		b.ticketData[chatID].Description = text
+		b.touchConversation(chatID)
 		return b.ConfirmTicketCreation(chatID)
+	case StateWaitingForBroadcast:
+		return b.HandleBroadcastContent(chatID, text)
 	case StateWaitingForComment:
+		ticketID := b.ticketData[chatID].TicketID
+		save := b.saveUserComment
 		if isAdmin {
-			return b.AddAdminCommentToTicket(chatID, message.From.ID, text, ticketData[chatID].TicketID)
+			save = b.saveAdminComment
+		}
+		// The conversation is kept until the comment is saved, so that it can simply be sent again
+		if err := save(chatID, message.From.ID, message.MessageID, text, ticketID); err != nil {
+			b.touchConversation(chatID)
+			if sendErr := b.SendMessage(chatID, "评论保存失败，请重新发送，或发送 /cancel 取消。"); sendErr != nil {
+				logger.Error("Failed to report failed comment", "error", sendErr)
+			}
+			return err
 		}
-		return b.AddCommentToTicket(chatID, message.From.ID, text)
+		b.clearConversation(chatID)
+		return b.showCommentedTicket(logger, chatID, message.From.ID, ticketID)
 	default:
-		return b.SendMessage(chatID, "我不明白您的意思。请使用 /help 查看可用命令。")
+		return b.SendMessage(chatID, b.text("unknown_message", "我不明白您的意思。请使用 /help 查看可用命令。"))
 	}
 }
 
-func (b *Bot) AddAdminCommentToTicket(chatID int64, telegramUserID int64, content string, ticketID int) error {
+// HandleNotificationReply adds a comment when a message replies to a stored ticket notification.
+// It reports whether the message was a reply to such a notification.
+func (b *Bot) HandleNotificationReply(logger *slog.Logger, message *tgbotapi.Message, isAdmin bool) (bool, error) {
+	chatID := message.Chat.ID
+
 	db, err := database.InitializeDB()
 	if err != nil {
-		return fmt.Errorf("[ERROR] Failed to get database connection: %v", err)
+		return false, fmt.Errorf("[ERROR] Failed to get database connection: %v", err)
 	}
 
-	// Get admin ID
-	adminID, err := database.GetAdminIDByTelegramID(db, telegramUserID)
-	if err != nil {
-		return fmt.Errorf("[ERROR] Failed to get admin ID: %v", err)
+	ticketID, err := tickets.GetTicketIDByMessage(db, b.tenant, chatID, message.ReplyToMessage.MessageID)
+	if err == gorm.ErrRecordNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("[ERROR] Failed to look up replied message: %v", err)
 	}
 
-	// Add admin comment
-	err = tickets.AddAdminComment(db, ticketID, adminID, content)
-	if err != nil {
-		return fmt.Errorf("[ERROR] Failed to add admin comment: %v", err)
+	if message.Text == "" {
+		return true, b.SendMessage(chatID, "目前仅支持文字回复。")
 	}
 
-	// Get ticket information
-	ticket, err := tickets.GetTicketByID(db, ticketID)
+	ticket, err := tickets.GetTicketByID(db, b.tenant, ticketID)
 	if err != nil {
-		return fmt.Errorf("[ERROR] Failed to get ticket: %v", err)
+		return true, fmt.Errorf("[ERROR] Failed to get ticket: %v", err)
+	}
+	if ticket.Status == "closed" {
+		return true, b.SendMessage(chatID, fmt.Sprintf("工单 #%d 已关闭，无法回复。", ticketID))
 	}
 
-	log.Printf("[DEBUG] Fetched ticket: %+v", ticket)
-
-	// Notify the user
-	userMessage := fmt.Sprintf("工单 #%d 有来自 Staff 的新回复：\n%s", ticketID, content)
+	if isAdmin {
+		return true, b.AddAdminCommentToTicket(logger, chatID, message.From.ID, message.MessageID, message.Text, ticketID)
+	}
+	return true, b.AddCommentToTicket(logger, chatID, message.From.ID, message.MessageID, message.Text, ticketID)
+}
 
-	keyboard := tgbotapi.NewInlineKeyboardMarkup(
-		tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonData("查看工单历史", fmt.Sprintf("view_ticket_%d", ticketID)),
-			tgbotapi.NewInlineKeyboardButtonData("回复", fmt.Sprintf("reply_ticket_%d", ticketID)),
-		),
-	)
+// AddAdminCommentToTicket adds the admin's reply written in messageID to the ticket
+func (b *Bot) AddAdminCommentToTicket(logger *slog.Logger, chatID int64, telegramUserID int64, messageID int, content string, ticketID int) error {
+	if err := b.saveAdminComment(chatID, telegramUserID, messageID, content, ticketID); err != nil {
+		return err
+	}
+	return b.showCommentedTicket(logger, chatID, telegramUserID, ticketID)
+}
 
-	// Directly get the user's Telegram ID
-	userTelegramID, err := database.GetTelegramIDByUserID(db, ticket.CreatedBy)
+func (b *Bot) saveAdminComment(chatID int64, telegramUserID int64, messageID int, content string, ticketID int) error {
+	db, err := database.InitializeDB()
 	if err != nil {
-		log.Printf("[ERROR] Failed to get Telegram ID for user %d: %v", ticket.CreatedBy, err)
-		return fmt.Errorf("failed to get user Telegram ID: %v", err)
+		return fmt.Errorf("[ERROR] Failed to get database connection: %v", err)
 	}
 
-	log.Printf("[DEBUG] User %d Telegram ID: %d", ticket.CreatedBy, userTelegramID)
-
-	// Send message using the obtained Telegram ID
-	err = b.SendMessageWithInlineKeyboard(userTelegramID, userMessage, keyboard)
+	// Get admin ID
+	adminID, err := database.GetAdminIDByTelegramID(db, b.tenant, telegramUserID)
 	if err != nil {
-		log.Printf("[ERROR] Failed to notify user using Telegram ID %d for ticket #%d: %v", userTelegramID, ticketID, err)
-		return fmt.Errorf("failed to notify user: %v", err)
+		return fmt.Errorf("[ERROR] Failed to get admin ID: %v", err)
 	}
 
-	log.Printf("[INFO] Successfully notified user %d for ticket #%d using Telegram ID", ticket.CreatedBy, ticketID)
+	// Tickets of other tenants cannot be replied to through this bot
+	if _, err := tickets.GetTicketByID(db, b.tenant, ticketID); err != nil {
+		return fmt.Errorf("[ERROR] Failed to get ticket: %v", err)
+	}
+
+	// Add admin comment
+	if _, err := tickets.AddAdminComment(db, ticketID, adminID, content, chatID, messageID); err != nil {
+		return fmt.Errorf("[ERROR] Failed to add admin comment: %v", err)
+	}
+	return nil
+}
 
-	// Display ticket information
-	log.Printf("[DEBUG] Calling HandleTicketView from AddAdminCommentToTicket with chatID: %d, ticketID: %d, telegramUserID: %d", chatID, ticketID, telegramUserID)
-	err = b.HandleTicketView(&tgbotapi.CallbackQuery{
+// Display the ticket that was just commented on
+func (b *Bot) showCommentedTicket(logger *slog.Logger, chatID int64, telegramUserID int64, ticketID int) error {
+	return b.HandleTicketView(logger, &tgbotapi.CallbackQuery{
 		Message: &tgbotapi.Message{Chat: &tgbotapi.Chat{ID: chatID}},
 		Data:    fmt.Sprintf("view_ticket_%d", ticketID),
 		From:    &tgbotapi.User{ID: telegramUserID},
 	})
-	if err != nil {
-		log.Printf("[ERROR] HandleTicketView failed: %v", err)
-		return err
-	}
-	log.Printf("[DEBUG] HandleTicketView completed successfully")
-	return nil
 }
 
 func (b *Bot) ConfirmTicketCreation(chatID int64) error {
-	data := ticketData[chatID]
-	confirmationText := fmt.Sprintf("请确认工单信息：\n标题：%s\n描述：%s\n\n是否创建工单？", data.Title, data.Description)
+	data := b.ticketData[chatID]
+	fields := htmlField("标题", data.Title)
+	if data.Category != "" {
+		fields += "\n" + htmlField("类别", b.categoryName(data.Category))
+	}
+	confirmationText := fmt.Sprintf("<b>请确认工单信息：</b>\n%s\n<b>描述:</b>\n%s\n\n是否创建工单？",
+		fields, htmlContent(data.Description))
 
 	keyboard := tgbotapi.NewInlineKeyboardMarkup(
 		tgbotapi.NewInlineKeyboardRow(
@@ -287,45 +330,41 @@ func (b *Bot) ConfirmTicketCreation(chatID int64) error {
 		),
 	)
 
-	return b.SendMessageWithInlineKeyboard(chatID, confirmationText, keyboard)
+	return b.SendHTMLWithInlineKeyboard(chatID, confirmationText, keyboard)
 }
 
-func (b *Bot) HandleTicketConfirmation(callbackQuery *tgbotapi.CallbackQuery) error {
+func (b *Bot) HandleTicketConfirmation(logger *slog.Logger, callbackQuery *tgbotapi.CallbackQuery) error {
 	chatID := callbackQuery.Message.Chat.ID
 	data := callbackQuery.Data
 
 	switch data {
 	case "confirm_ticket":
-		return b.CreateTicket(chatID)
+		return b.CreateTicket(logger, chatID)
 	case "cancel_ticket":
-		delete(userStates, chatID)
-		delete(ticketData, chatID)
+		b.clearConversation(chatID)
 		return b.SendMessage(chatID, "工单创建已取消。")
 	default:
 		return b.SendMessage(chatID, "未知的选项。")
 	}
 }
 
-func (b *Bot) CreateTicket(chatID int64) error {
-	data := ticketData[chatID]
+func (b *Bot) CreateTicket(logger *slog.Logger, chatID int64) error {
+	data, ok := b.ticketData[chatID]
+	if !ok || b.userStates[chatID] != StateWaitingForDesc {
+		return b.SendMessage(chatID, "工单草稿已失效，请重新创建工单。")
+	}
 
 	db, err := database.InitializeDB()
 	if err != nil {
 		return fmt.Errorf("[ERROR] Failed to get database connection: %v", err)
 	}
 
-	ticket, err := tickets.CreateTicket(db, chatID, data.Title, data.Description, "normal")
+	ticket, err := tickets.CreateTicket(db, b.tenant, chatID, data.Title, data.Description, "normal", data.Category)
 	if err != nil {
 		return b.SendMessage(chatID, fmt.Sprintf("[ERROR] Failed to create ticket: %v", err))
 	}
 
-	// Notify all administrators
-	if err := b.NotifyAllAdmins(ticket); err != nil {
-		log.Printf("[ERROR] Failed to notify admins: %v", err)
-	}
-
-	delete(userStates, chatID)
-	delete(ticketData, chatID)
+	b.clearConversation(chatID)
 
 	successMsg := fmt.Sprintf("工单创建成功。工单ID: %d", ticket.TicketID)
 	err = b.SendMessage(chatID, successMsg)
@@ -334,13 +373,11 @@ func (b *Bot) CreateTicket(chatID int64) error {
 	}
 
 	// Display details of the newly created ticket
-	return b.HandleTicketView(&tgbotapi.CallbackQuery{
-		Message: &tgbotapi.Message{Chat: &tgbotapi.Chat{ID: chatID}},
-		Data:    fmt.Sprintf("view_ticket_%d", ticket.TicketID),
-	})
+	return b.ShowTicket(logger, chatID, 0, chatID, ticket.TicketID, 0)
 }
 
-func (b *Bot) HandleViewTickets(message *tgbotapi.Message) error {
+// HandleViewTickets lists the user's tickets, replacing editMessageID when navigating from another screen
+func (b *Bot) HandleViewTickets(logger *slog.Logger, message *tgbotapi.Message, editMessageID int) error {
 	chatID := message.Chat.ID
 	telegramID := message.From.ID
 
@@ -349,7 +386,7 @@ func (b *Bot) HandleViewTickets(message *tgbotapi.Message) error {
 		return fmt.Errorf("[ERROR] Failed to get database connection: %v", err)
 	}
 
-	userTickets, err := tickets.GetUserTickets(db, int64(telegramID))
+	userTickets, err := tickets.GetUserTickets(db, b.tenant, int64(telegramID))
 	if err != nil {
 		return fmt.Errorf("[ERROR] Failed to get user tickets: %v", err)
 	}
@@ -366,12 +403,10 @@ func (b *Bot) HandleViewTickets(message *tgbotapi.Message) error {
 		keyboard.InlineKeyboard = append(keyboard.InlineKeyboard, row)
 	}
 
-	return b.SendMessageWithInlineKeyboard(chatID, "您的工单列表：", keyboard)
+	return b.ShowListScreen(logger, chatID, editMessageID, "您的工单列表：", keyboard)
 }
 
-func (b *Bot) HandleTicketView(callbackQuery *tgbotapi.CallbackQuery) error {
-	log.Printf("[DEBUG] Entering HandleTicketView")
-
+func (b *Bot) HandleTicketView(logger *slog.Logger, callbackQuery *tgbotapi.CallbackQuery) error {
 	if callbackQuery == nil {
 		return fmt.Errorf("[ERROR] Callback query is nil")
 	}
@@ -388,43 +423,98 @@ func (b *Bot) HandleTicketView(callbackQuery *tgbotapi.CallbackQuery) error {
 	chatID := callbackQuery.Message.Chat.ID
 	data := callbackQuery.Data
 
-	log.Printf("[DEBUG] HandleTicketView called with chatID: %d, data: %s, From.ID: %d", chatID, data, callbackQuery.From.ID)
-
-	// Extract ticket ID from callback data
-	var ticketID int
-	_, err := fmt.Sscanf(data, "view_ticket_%d", &ticketID)
+	// Extract ticket ID and comment page from callback data
+	var ticketID, page int
+	var err error
+	if strings.HasPrefix(data, "ticket_page_") {
+		_, err = fmt.Sscanf(data, "ticket_page_%d_%d", &ticketID, &page)
+	} else {
+		_, err = fmt.Sscanf(data, "view_ticket_%d", &ticketID)
+	}
 	if err != nil {
 		return fmt.Errorf("[ERROR] Failed to parse ticket ID: %v", err)
 	}
+	if page < 0 {
+		page = 0
+	}
+
+	// Callback data can be forged, so check access like a link to the ticket
+	db, err := database.InitializeDB()
+	if err != nil {
+		return fmt.Errorf("[ERROR] Failed to get database connection: %v", err)
+	}
+	ticket, err := tickets.GetTicketByID(db, b.tenant, ticketID)
+	if err == gorm.ErrRecordNotFound {
+		return b.SendMessage(chatID, "工单不存在或您无权查看。")
+	} else if err != nil {
+		return fmt.Errorf("[ERROR] Failed to get ticket information: %v", err)
+	}
+	allowed, err := b.canAccessTicket(db, ticket, callbackQuery.From.ID)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		logger.Info("User opened a ticket without access", "ticket_id", ticketID)
+		return b.SendMessage(chatID, "工单不存在或您无权查看。")
+	}
 
-	log.Printf("[DEBUG] Parsed ticketID: %d", ticketID)
+	return b.ShowTicket(logger, chatID, callbackQuery.Message.MessageID, callbackQuery.From.ID, ticketID, page)
+}
 
+// ShowTicket displays the ticket view in place of the message editMessageID (or as a new message when it is 0)
+// and remembers the resulting message as a card of the ticket.
+func (b *Bot) ShowTicket(logger *slog.Logger, chatID int64, editMessageID int, viewerID int64, ticketID int, page int) error {
 	db, err := database.InitializeDB()
 	if err != nil {
 		return fmt.Errorf("[ERROR] Failed to get database connection: %v", err)
 	}
 
-	ticket, err := tickets.GetTicketByID(db, ticketID)
+	ticketInfo, keyboard, err := b.renderTicketView(logger, db, ticketID, page, viewerID)
 	if err != nil {
-		return fmt.Errorf("[ERROR] Failed to get ticket information: %v", err)
+		return err
 	}
 
-	log.Printf("[DEBUG] Retrieved ticket: %+v", ticket)
+	messageID, err := b.ShowScreen(logger, chatID, editMessageID, ticketInfo, keyboard)
+	if err != nil {
+		return fmt.Errorf("[ERROR] Failed to send ticket view: %v", err)
+	}
 
-	ticketInfo := fmt.Sprintf("工单 #%d\n标题: %s\n描述: %s\n状态: %s\n优先级: %s\n创建时间: %s",
-		ticket.TicketID, ticket.Title, ticket.Description, ticket.Status, ticket.Priority, ticket.CreatedAt.Format("2006-01-02 15:04:05"))
+	if err := tickets.SaveTicketCard(db, b.tenant, chatID, messageID, ticketID, viewerID, page); err != nil {
+		logger.Error("Failed to remember ticket card", "ticket_id", ticketID, "error", err)
+	}
+	return nil
+}
 
-	log.Printf("[DEBUG] Constructed ticketInfo: %s", ticketInfo)
+// Build the text and keyboard of a ticket view as seen by viewerID
+func (b *Bot) renderTicketView(logger *slog.Logger, db *gorm.DB, ticketID int, page int, viewerID int64) (string, tgbotapi.InlineKeyboardMarkup, error) {
+	var keyboard tgbotapi.InlineKeyboardMarkup
 
-	// 检查用户是否为管理员
-	isAdmin, err := database.IsUserAdmin(callbackQuery.From.ID)
+	ticket, err := tickets.GetTicketByID(db, b.tenant, ticketID)
 	if err != nil {
-		return fmt.Errorf("[ERROR] Failed to check admin status: %v", err)
+		return "", keyboard, fmt.Errorf("[ERROR] Failed to get ticket information: %v", err)
 	}
 
-	log.Printf("[DEBUG] User admin status: %v", isAdmin)
+	fields := htmlField("标题", ticket.Title)
+	if ticket.Category != "" {
+		fields += "\n" + htmlField("类别", b.categoryName(ticket.Category))
+	}
+	if ticket.Channel == tickets.ChannelEmail {
+		fields += "\n" + htmlField("渠道", "邮件")
+	}
+	ticketInfo := fmt.Sprintf("<b>工单 #%d</b>\n%s\n<b>描述:</b>\n%s\n%s\n%s\n%s",
+		ticket.TicketID,
+		fields,
+		htmlContent(ticket.Description),
+		htmlField("状态", ticket.Status),
+		htmlField("优先级", ticket.Priority),
+		htmlField("创建时间", ticket.CreatedAt.Format("2006-01-02 15:04:05")))
+
+	// 检查用户是否为管理员
+	isAdmin, err := database.IsUserAdmin(b.tenant, viewerID)
+	if err != nil {
+		return "", keyboard, fmt.Errorf("[ERROR] Failed to check admin status: %v", err)
+	}
 
-	var keyboard tgbotapi.InlineKeyboardMarkup
 	if ticket.Status == "closed" {
 		keyboard = tgbotapi.NewInlineKeyboardMarkup(
 			tgbotapi.NewInlineKeyboardRow(
@@ -455,66 +545,81 @@ func (b *Bot) HandleTicketView(callbackQuery *tgbotapi.CallbackQuery) error {
 		}
 	}
 
-	log.Printf("[DEBUG] Constructed keyboard: %+v", keyboard)
-
-	// Fetch ticket comments
-	comments, err := tickets.GetTicketComments(db, ticketID)
+	// Fetch the requested page of ticket comments, newest page first
+	comments, total, err := tickets.GetTicketCommentsPage(db, ticketID, page, commentsPerPage)
 	if err != nil {
-		return fmt.Errorf("[ERROR] Failed to fetch ticket comments: %v", err)
+		return "", keyboard, fmt.Errorf("[ERROR] Failed to fetch ticket comments: %v", err)
 	}
 
-	log.Printf("[DEBUG] Retrieved %d comments", len(comments))
+	if total > commentsPerPage {
+		first := int(total) - page*commentsPerPage - len(comments) + 1
+		ticketInfo += fmt.Sprintf("\n\n<i>评论: 共 %d 条, 当前显示第 %d-%d 条</i>", total, first, first+len(comments)-1)
+
+		// Paging buttons go above the action buttons
+		var pagingRow []tgbotapi.InlineKeyboardButton
+		if int64((page+1)*commentsPerPage) < total {
+			pagingRow = append(pagingRow, tgbotapi.NewInlineKeyboardButtonData("« 更早的评论", fmt.Sprintf("ticket_page_%d_%d", ticketID, page+1)))
+		}
+		if page > 0 {
+			pagingRow = append(pagingRow, tgbotapi.NewInlineKeyboardButtonData("更新的评论 »", fmt.Sprintf("ticket_page_%d_%d", ticketID, page-1)))
+		}
+		keyboard.InlineKeyboard = append([][]tgbotapi.InlineKeyboardButton{pagingRow}, keyboard.InlineKeyboard...)
+	}
 
 	// Add comments to ticket information
 	for _, comment := range comments {
-		if comment.AdminID != nil {
-			// Fetch admin information
-			admin, err := database.GetAdminByID(db, *comment.AdminID)
-			if err != nil {
-				log.Printf("[ERROR] Failed to fetch admin information: %v", err)
-				continue
-			}
-			ticketInfo += fmt.Sprintf("\n\n[Staff] %s (Global Comment ID: %d):\n%s\n\nRegards,\n%s\n%s\nTime: %s",
-				admin.FullName,
-				comment.CommentID,
-				comment.Content,
-				admin.FullName,
-				admin.Position,
-				comment.CreatedAt.Format("2006-01-02 15:04:05"))
-		} else if comment.UserID != nil {
-			// Fetch user information
-			user, err := database.GetRegularUserByID(db, *comment.UserID)
-			if err != nil {
-				log.Printf("[ERROR] Failed to fetch user information: %v", err)
-				continue
-			}
+		ticketInfo += b.formatComment(logger, db, comment)
+	}
+
+	return ticketInfo, keyboard, nil
+}
+
+// Render a single comment for the ticket view
+func (b *Bot) formatComment(logger *slog.Logger, db *gorm.DB, comment tickets.TicketComment) string {
+	if comment.AdminID != nil {
+		// Fetch admin information
+		admin, err := database.GetAdminByID(db, *comment.AdminID)
+		if err != nil {
+			logger.Error("Failed to fetch admin information", "admin_id", *comment.AdminID, "error", err)
+			return ""
+		}
+		return fmt.Sprintf("\n\n<b>[Staff] %s</b> (Global Comment ID: %d):\n%s\n\nRegards,\n%s\n%s\n<i>Time: %s%s</i>",
+			escapeHTML(admin.FullName),
+			comment.CommentID,
+			htmlContent(comment.Content),
+			escapeHTML(admin.FullName),
+			escapeHTML(admin.Position),
+			comment.CreatedAt.Format("2006-01-02 15:04:05"),
+			editedMarker(comment))
+	} else if comment.UserID != nil {
+		// Fetch user information
+		user, err := database.GetRegularUserByID(db, *comment.UserID)
+		if err != nil {
+			logger.Error("Failed to fetch user information", "user_id", *comment.UserID, "error", err)
+			return ""
+		}
+		var userFullName string
+		if user.TelegramID == 0 && user.Email != nil {
+			userFullName = *user.Email
+		} else {
 			// Get user's full name from Telegram
-			userFullName, err := b.GetUserFullName(user.TelegramID)
+			userFullName, err = b.GetUserFullName(user.TelegramID)
 			if err != nil {
-				log.Printf("[ERROR] Failed to get user's full name: %v", err)
+				logger.Warn("Failed to get user's full name", "user_id", user.UserID, "error", err)
 				userFullName = "Unknown User"
 			}
-			ticketInfo += fmt.Sprintf("\n\n%s (Global Comment ID: %d):\n%s\nTime: %s",
-				userFullName,
-				comment.CommentID,
-				comment.Content,
-				comment.CreatedAt.Format("2006-01-02 15:04:05"))
 		}
-	}
-
-	log.Printf("[DEBUG] Sending message with inline keyboard")
-	err = b.SendMessageWithInlineKeyboard(chatID, ticketInfo, keyboard)
-	if err != nil {
-		log.Printf("[ERROR] Failed to send message with inline keyboard: %v", err)
-		return fmt.Errorf("[ERROR] Failed to send message with inline keyboard: %v", err)
-	}
-
-	log.Printf("[INFO] Successfully sent ticket view for ticket #%d", ticketID)
-	log.Printf("[DEBUG] Exiting HandleTicketView")
-	return nil
+		return fmt.Sprintf("\n\n<b>%s</b> (Global Comment ID: %d):\n%s\n<i>Time: %s%s</i>",
+			escapeHTML(userFullName),
+			comment.CommentID,
+			htmlContent(comment.Content),
+			comment.CreatedAt.Format("2006-01-02 15:04:05"),
+			editedMarker(comment))
+	}
+	return ""
 }
 
-func (b *Bot) HandleCloseTicket(callbackQuery *tgbotapi.CallbackQuery) error {
+func (b *Bot) HandleCloseTicket(logger *slog.Logger, callbackQuery *tgbotapi.CallbackQuery) error {
 	chatID := callbackQuery.Message.Chat.ID
 	data := callbackQuery.Data
 
@@ -529,24 +634,18 @@ func (b *Bot) HandleCloseTicket(callbackQuery *tgbotapi.CallbackQuery) error {
 		return fmt.Errorf("[ERROR] Failed to get database connection: %v", err)
 	}
 
-	err = tickets.CloseTicket(db, ticketID)
+	// Tickets of other tenants cannot be closed through this bot
+	if _, err := tickets.GetTicketByID(db, b.tenant, ticketID); err != nil {
+		return fmt.Errorf("[ERROR] Failed to get ticket: %v", err)
+	}
+
+	err = tickets.CloseTicket(db, ticketID, callbackQuery.From.ID)
 	if err != nil {
 		return fmt.Errorf("[ERROR] Failed to close ticket: %v", err)
 	}
 
-	// Update inline keyboard, remove "Close ticket" button
-	keyboard := tgbotapi.NewInlineKeyboardMarkup(
-		tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonData("返回列表", "view_tickets"),
-		),
-	)
-
-	// Update message text, show ticket is closed
-	updatedText := fmt.Sprintf("%s\n\n工单已关闭", callbackQuery.Message.Text)
-
-	editMsg := tgbotapi.NewEditMessageTextAndMarkup(chatID, callbackQuery.Message.MessageID, updatedText, keyboard)
-	_, err = b.api.Send(editMsg)
-	if err != nil {
+	// Show the closed ticket in place, without the "Close ticket" button
+	if err := b.ShowTicket(logger, chatID, callbackQuery.Message.MessageID, callbackQuery.From.ID, ticketID, 0); err != nil {
 		return fmt.Errorf("[ERROR] Failed to update message: %v", err)
 	}
 
@@ -564,66 +663,42 @@ func (b *Bot) HandleAddComment(callbackQuery *tgbotapi.CallbackQuery) error {
 		return fmt.Errorf("[ERROR] Failed to parse ticket ID: %v", err)
 	}
 
-	userStates[chatID] = StateWaitingForComment
-	ticketData[chatID] = &tickets.TicketCreationData{TicketID: ticketID}
+	b.clearConversation(chatID)
+	b.setUserState(chatID, StateWaitingForComment)
+	b.ticketData[chatID] = &tickets.TicketCreationData{TicketID: ticketID}
 
 	return b.SendMessage(chatID, "请输入您的评论：")
 }
 
-// AddCommentToTicket adds a comment to the ticket
-func (b *Bot) AddCommentToTicket(chatID int64, telegramUserID int64, content string) error {
-	data := ticketData[chatID]
+// AddCommentToTicket adds the user's comment written in messageID to the ticket
+func (b *Bot) AddCommentToTicket(logger *slog.Logger, chatID int64, telegramUserID int64, messageID int, content string, ticketID int) error {
+	if err := b.saveUserComment(chatID, telegramUserID, messageID, content, ticketID); err != nil {
+		return err
+	}
+	return b.showCommentedTicket(logger, chatID, telegramUserID, ticketID)
+}
 
+func (b *Bot) saveUserComment(chatID int64, telegramUserID int64, messageID int, content string, ticketID int) error {
 	db, err := database.InitializeDB()
 	if err != nil {
 		return fmt.Errorf("[ERROR] Failed to get database connection: %v", err)
 	}
 
 	// Get user ID
-	userID, err := database.GetUserIDByTelegramID(db, telegramUserID)
+	userID, err := database.GetUserIDByTelegramID(db, b.tenant, telegramUserID)
 	if err != nil {
 		return fmt.Errorf("[ERROR] Failed to get user ID: %v", err)
 	}
 
-	// Add comment
-	err = tickets.AddComment(db, data.TicketID, userID, content)
-	if err != nil {
-		return fmt.Errorf("[ERROR] Failed to add comment: %v", err)
-	}
-
-	// Get ticket information
-	ticket, err := tickets.GetTicketByID(db, data.TicketID)
-	if err != nil {
+	// Tickets of other tenants cannot be commented on through this bot
+	if _, err := tickets.GetTicketByID(db, b.tenant, ticketID); err != nil {
 		return fmt.Errorf("[ERROR] Failed to get ticket: %v", err)
 	}
 
-	// Notify assigned admin
-	if ticket.AssignedTo != nil {
-		comment := &tickets.TicketComment{
-			TicketID: data.TicketID,
-			UserID:   &userID,
-			Content:  content,
-		}
-		if err := b.NotifyAssignedAdmin(ticket, comment); err != nil {
-			log.Printf("[ERROR] Failed to notify assigned admin: %v", err)
-		}
-	}
-
-	delete(userStates, chatID)
-	delete(ticketData, chatID)
-
-	// Display ticket information
-	log.Printf("[DEBUG] Calling HandleTicketView from AddCommentToTicket with chatID: %d, ticketID: %d, telegramUserID: %d", chatID, data.TicketID, telegramUserID)
-	err = b.HandleTicketView(&tgbotapi.CallbackQuery{
-		Message: &tgbotapi.Message{Chat: &tgbotapi.Chat{ID: chatID}},
-		Data:    fmt.Sprintf("view_ticket_%d", data.TicketID),
-		From:    &tgbotapi.User{ID: telegramUserID},
-	})
-	if err != nil {
-		log.Printf("[ERROR] HandleTicketView failed: %v", err)
-		return err
+	// Add comment
+	if _, err := tickets.AddComment(db, ticketID, userID, content, chatID, messageID); err != nil {
+		return fmt.Errorf("[ERROR] Failed to add comment: %v", err)
 	}
-	log.Printf("[DEBUG] HandleTicketView completed successfully")
 	return nil
 }
 
@@ -642,9 +717,9 @@ func (b *Bot) HandleAssignTicket(callbackQuery *tgbotapi.CallbackQuery) error {
 		return fmt.Errorf("[ERROR] Failed to get database connection: %v", err)
 	}
 
-	var admins []database.AdminUser
-	if err := db.Find(&admins).Error; err != nil {
-		return fmt.Errorf("[ERROR] Failed to fetch admin users: %v", err)
+	admins, err := database.GetAdmins(db, b.tenant)
+	if err != nil {
+		return err
 	}
 
 	var keyboard tgbotapi.InlineKeyboardMarkup
@@ -666,25 +741,85 @@ func (b *Bot) AssignTicketToAdmin(ticketID int, adminID int) error {
 		return fmt.Errorf("[ERROR] Failed to get database connection: %v", err)
 	}
 
-	if err := db.Model(&tickets.Ticket{}).Where("ticket_id = ?", ticketID).Update("assigned_to", adminID).Error; err != nil {
-		return fmt.Errorf("[ERROR] Failed to assign ticket: %v", err)
+	if _, err := tickets.GetTicketByID(db, b.tenant, ticketID); err != nil {
+		return fmt.Errorf("[ERROR] Failed to get ticket info: %v", err)
 	}
 
-	// Notify the assigned admin
 	admin, err := database.GetAdminByID(db, adminID)
 	if err != nil {
 		return fmt.Errorf("[ERROR] Failed to get admin info: %v", err)
 	}
+	if admin.Tenant != b.tenant {
+		return fmt.Errorf("[ERROR] Admin %d does not belong to tenant %s", adminID, b.tenant)
+	}
+
+	return tickets.AssignTicket(db, ticketID, adminID)
+}
 
-	ticket, err := tickets.GetTicketByID(db, ticketID)
+// NotifyTicketCreator notifies the user who created the ticket about a new staff reply
+func (b *Bot) NotifyTicketCreator(ticket *tickets.Ticket, content string) error {
+	db, err := database.InitializeDB()
 	if err != nil {
-		return fmt.Errorf("[ERROR] Failed to get ticket info: %v", err)
+		return fmt.Errorf("[ERROR] Failed to get database connection: %v", err)
 	}
 
-	message := fmt.Sprintf("工单已分配给您:\n工单ID: %d\n标题: %s\n描述: %s",
-		ticket.TicketID, ticket.Title, ticket.Description)
+	if ticket.Channel == tickets.ChannelEmail {
+		return b.mailTicketCreator(db, ticket, func(address string) error {
+			return b.mailer.SendReply(address, ticket, content)
+		})
+	}
+
+	userMessage := fmt.Sprintf("<b>工单 #%d 有来自 Staff 的新回复：</b>\n%s%s",
+		ticket.TicketID, previousReplyQuote(db, ticket.TicketID), htmlContent(content))
 
-	return b.SendMessage(admin.TelegramID, message)
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("查看工单历史", fmt.Sprintf("view_ticket_%d", ticket.TicketID)),
+			tgbotapi.NewInlineKeyboardButtonData("回复", fmt.Sprintf("reply_ticket_%d", ticket.TicketID)),
+		),
+	)
+
+	// Directly get the user's Telegram ID
+	userTelegramID, err := database.GetTelegramIDByUserID(db, ticket.CreatedBy)
+	if err != nil {
+		return fmt.Errorf("failed to get user Telegram ID: %v", err)
+	}
+
+	// Send message using the obtained Telegram ID
+	err = b.Notify(userTelegramID, database.EventComment, ticket.TicketID, userMessage, keyboard)
+	if err != nil {
+		return fmt.Errorf("failed to notify user: %v", err)
+	}
+	return nil
+}
+
+// Send mail to the creator of an email ticket, who cannot be reached in Telegram
+func (b *Bot) mailTicketCreator(db *gorm.DB, ticket *tickets.Ticket, send func(address string) error) error {
+	if b.mailer == nil {
+		b.logger.Info("Email channel disabled, creator of email ticket not notified", "ticket_id", ticket.TicketID)
+		return nil
+	}
+	user, err := database.GetRegularUserByID(db, ticket.CreatedBy)
+	if err != nil {
+		return fmt.Errorf("[ERROR] Failed to get ticket creator: %v", err)
+	}
+	if user.Email == nil {
+		return fmt.Errorf("[ERROR] Creator of email ticket #%d has no email address", ticket.TicketID)
+	}
+	return send(*user.Email)
+}
+
+// Quote the reply preceding the newest comment of a ticket, or return "" if there is none
+func previousReplyQuote(db *gorm.DB, ticketID int) string {
+	comments, _, err := tickets.GetTicketCommentsPage(db, ticketID, 0, 2)
+	if err != nil {
+		slog.Error("Failed to get previous reply", "ticket_id", ticketID, "error", err)
+		return ""
+	}
+	if len(comments) < 2 {
+		return ""
+	}
+	return htmlQuote(comments[0].Content) + "\n"
 }
 
 // NotifyAssignedAdmin notifies the assigned admin about a new comment
@@ -703,7 +838,8 @@ func (b *Bot) NotifyAssignedAdmin(ticket *tickets.Ticket, comment *tickets.Ticke
 		return fmt.Errorf("[ERROR] Failed to get admin info: %v", err)
 	}
 
-	message := fmt.Sprintf("工单 #%d 有新回复:\n%s", ticket.TicketID, comment.Content)
+	message := fmt.Sprintf("<b>工单 #%d 有新回复:</b>\n%s%s",
+		ticket.TicketID, previousReplyQuote(db, ticket.TicketID), htmlContent(comment.Content))
 
 	keyboard := tgbotapi.NewInlineKeyboardMarkup(
 		tgbotapi.NewInlineKeyboardRow(
@@ -712,14 +848,63 @@ func (b *Bot) NotifyAssignedAdmin(ticket *tickets.Ticket, comment *tickets.Ticke
 		),
 	)
 
-	return b.SendMessageWithInlineKeyboard(admin.TelegramID, message, keyboard)
+	return b.Notify(admin.TelegramID, database.EventComment, ticket.TicketID, message, keyboard)
+}
+
+// NotifyStatusChange tells the ticket creator and the assigned admin about the ticket's new status,
+// except whoever made the change
+func (b *Bot) NotifyStatusChange(ticket *tickets.Ticket, actorTelegramID int64) error {
+	db, err := database.InitializeDB()
+	if err != nil {
+		return fmt.Errorf("[ERROR] Failed to get database connection: %v", err)
+	}
+
+	var recipients []int64
+	if ticket.Channel == tickets.ChannelEmail {
+		err := b.mailTicketCreator(db, ticket, func(address string) error {
+			return b.mailer.SendStatusChange(address, ticket)
+		})
+		if err != nil {
+			b.logger.Error("Failed to mail status change", "ticket_id", ticket.TicketID, "error", err)
+		}
+	} else {
+		creatorTelegramID, err := database.GetTelegramIDByUserID(db, ticket.CreatedBy)
+		if err != nil {
+			return err
+		}
+		if creatorTelegramID != actorTelegramID {
+			recipients = append(recipients, creatorTelegramID)
+		}
+	}
+	if ticket.AssignedTo != nil {
+		admin, err := database.GetAdminByID(db, *ticket.AssignedTo)
+		if err != nil {
+			return err
+		}
+		if admin.TelegramID != actorTelegramID {
+			recipients = append(recipients, admin.TelegramID)
+		}
+	}
+
+	message := fmt.Sprintf("<b>工单 #%d 状态已变更</b>\n%s\n%s",
+		ticket.TicketID, htmlField("标题", ticket.Title), htmlField("状态", ticket.Status))
+
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("查看工单", fmt.Sprintf("view_ticket_%d", ticket.TicketID)),
+		),
+	)
+
+	b.NotifyAll(recipients, database.EventStatusChange, ticket.TicketID, message, keyboard)
+	return nil
 }
 
-func (b *Bot) HandleAdminViewTickets(message *tgbotapi.Message) error {
+// HandleAdminViewTickets lists all tickets for admins, replacing editMessageID when navigating from another screen
+func (b *Bot) HandleAdminViewTickets(logger *slog.Logger, message *tgbotapi.Message, editMessageID int) error {
 	chatID := message.Chat.ID
 
 	// Check if the user is an admin
-	isAdmin, err := database.IsUserAdmin(message.From.ID)
+	isAdmin, err := database.IsUserAdmin(b.tenant, message.From.ID)
 	if err != nil {
 		return fmt.Errorf("[ERROR] Failed to check admin status: %v", err)
 	}
@@ -732,7 +917,7 @@ func (b *Bot) HandleAdminViewTickets(message *tgbotapi.Message) error {
 		return fmt.Errorf("[ERROR] Failed to get database connection: %v", err)
 	}
 
-	allTickets, err := tickets.GetAllTickets(db)
+	allTickets, err := tickets.GetAllTickets(db, b.tenant)
 	if err != nil {
 		return fmt.Errorf("[ERROR] Failed to get tickets: %v", err)
 	}
@@ -749,7 +934,7 @@ func (b *Bot) HandleAdminViewTickets(message *tgbotapi.Message) error {
 		keyboard.InlineKeyboard = append(keyboard.InlineKeyboard, row)
 	}
 
-	return b.SendMessageWithInlineKeyboard(chatID, "所有工单列表：", keyboard)
+	return b.ShowListScreen(logger, chatID, editMessageID, "所有工单列表：", keyboard)
 }
 
 func (b *Bot) GetUserFullName(telegramID int64) (string, error) {
@@ -0,0 +1,95 @@
+package telegram
+
+import (
+	"fmt"
+	"strconv"
+
+	"telegram-tickets-bot/src/database"
+	"telegram-tickets-bot/src/tickets"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"gorm.io/gorm"
+)
+
+// Number of results returned per inline query page
+const inlineResultsPerPage = 20
+
+// HandleInlineQuery answers "@bot <id or keyword>" searches with the tickets the sender can access
+func (b *Bot) HandleInlineQuery(inlineQuery *tgbotapi.InlineQuery) error {
+	db, err := database.InitializeDB()
+	if err != nil {
+		return fmt.Errorf("[ERROR] Failed to get database connection: %v", err)
+	}
+
+	offset, _ := strconv.Atoi(inlineQuery.Offset)
+
+	answer := tgbotapi.InlineConfig{
+		InlineQueryID: inlineQuery.ID,
+		Results:       []interface{}{},
+		IsPersonal:    true,
+	}
+
+	// Admins can access every ticket, regular users only their own
+	isAdmin, err := database.IsUserAdmin(b.tenant, inlineQuery.From.ID)
+	if err != nil {
+		return fmt.Errorf("[ERROR] Failed to check admin status: %v", err)
+	}
+
+	var createdBy *int
+	if !isAdmin {
+		userID, err := database.GetUserIDByTelegramID(db, b.tenant, inlineQuery.From.ID)
+		if err == gorm.ErrRecordNotFound {
+			answer.SwitchPMText = "开始使用工单机器人"
+			answer.SwitchPMParameter = "inline"
+			_, err = b.api.Request(answer)
+			return err
+		} else if err != nil {
+			return fmt.Errorf("[ERROR] Failed to get user ID: %v", err)
+		}
+		createdBy = &userID
+	}
+
+	found, err := tickets.SearchTickets(db, b.tenant, inlineQuery.Query, createdBy, offset, inlineResultsPerPage)
+	if err != nil {
+		return fmt.Errorf("[ERROR] Failed to search tickets: %v", err)
+	}
+
+	for _, ticket := range found {
+		answer.Results = append(answer.Results, b.ticketInlineResult(ticket))
+	}
+	if len(found) == inlineResultsPerPage {
+		answer.NextOffset = strconv.Itoa(offset + inlineResultsPerPage)
+	}
+
+	_, err = b.api.Request(answer)
+	return err
+}
+
+// Build the summary card shared when an inline result is chosen
+func (b *Bot) ticketInlineResult(ticket tickets.Ticket) tgbotapi.InlineQueryResultArticle {
+	summary := fmt.Sprintf("<b>工单 #%d</b>\n%s\n%s\n%s\n%s\n%s",
+		ticket.TicketID,
+		htmlField("标题", ticket.Title),
+		htmlField("状态", ticket.Status),
+		htmlField("优先级", ticket.Priority),
+		htmlField("创建时间", ticket.CreatedAt.Format("2006-01-02 15:04:05")),
+		htmlField("更新时间", ticket.UpdatedAt.Format("2006-01-02 15:04:05")))
+
+	result := tgbotapi.NewInlineQueryResultArticleHTML(strconv.Itoa(ticket.TicketID),
+		fmt.Sprintf("#%d %s", ticket.TicketID, ticket.Title), summary)
+	result.Description = fmt.Sprintf("%s · %s · %s", ticket.Status, ticket.Priority, ticket.UpdatedAt.Format("2006-01-02"))
+
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonURL("在机器人中打开", b.ticketDeepLink(ticket.TicketID)),
+		),
+	)
+	result.ReplyMarkup = &keyboard
+
+	return result
+}
+
+// Link that opens the bot with a ticket_<id> start parameter
+func (b *Bot) ticketDeepLink(ticketID int) string {
+	return fmt.Sprintf("https://t.me/%s?start=ticket_%d", b.api.Self.UserName, ticketID)
+}
@@ -0,0 +1,76 @@
+package telegram
+
+import (
+	"log/slog"
+	"strconv"
+	"strings"
+
+	"telegram-tickets-bot/src/logging"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Return a logger carrying the update's context: its ID, chat, handler and, where known, ticket. It is passed
+// down to the handlers, so that everything logged while handling the update can be traced back to it.
+func (b *Bot) updateLogger(update tgbotapi.Update, handler string) *slog.Logger {
+	args := []any{"update_id", update.UpdateID}
+	if chatID := updateChatID(update); chatID != 0 {
+		args = append(args, "chat_id", chatID)
+	}
+	args = append(args, "handler", handler)
+	if ticketID := b.updateTicketID(update, handler); ticketID != 0 {
+		args = append(args, "ticket_id", ticketID)
+	}
+	return b.logger.With(args...)
+}
+
+func updateChatID(update tgbotapi.Update) int64 {
+	switch {
+	case update.Message != nil:
+		return update.Message.Chat.ID
+	case update.EditedMessage != nil:
+		return update.EditedMessage.Chat.ID
+	case update.CallbackQuery != nil && update.CallbackQuery.Message != nil:
+		return update.CallbackQuery.Message.Chat.ID
+	case update.CallbackQuery != nil:
+		return update.CallbackQuery.From.ID
+	case update.InlineQuery != nil:
+		return update.InlineQuery.From.ID
+	default:
+		return 0
+	}
+}
+
+// Return the ticket an update is about: the one in a button's callback data, or the one the chat is replying to
+func (b *Bot) updateTicketID(update tgbotapi.Update, handler string) int {
+	if update.CallbackQuery != nil {
+		// Callback data of ticket buttons continues with the ticket ID, e.g. view_ticket_42 or ticket_page_42_1
+		rest := strings.TrimPrefix(update.CallbackQuery.Data, strings.TrimPrefix(handler, "callback:")+"_")
+		digits, _, _ := strings.Cut(rest, "_")
+		ticketID, _ := strconv.Atoi(digits)
+		return ticketID
+	}
+	if update.Message != nil && update.Message.Chat.IsPrivate() {
+		if data, ok := b.ticketData[update.Message.Chat.ID]; ok {
+			return data.TicketID
+		}
+	}
+	return 0
+}
+
+// Describe what an update says, redacted unless content logging is enabled
+func updateContent(update tgbotapi.Update) slog.Attr {
+	switch {
+	case update.Message != nil:
+		return logging.Content("text", update.Message.Text+update.Message.Caption)
+	case update.EditedMessage != nil:
+		return logging.Content("text", update.EditedMessage.Text+update.EditedMessage.Caption)
+	case update.InlineQuery != nil:
+		return logging.Content("query", update.InlineQuery.Query)
+	case update.CallbackQuery != nil:
+		// Callback data is made by the bot itself and carries no user content
+		return slog.String("data", update.CallbackQuery.Data)
+	default:
+		return slog.Attr{}
+	}
+}
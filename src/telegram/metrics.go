@@ -0,0 +1,159 @@
+package telegram
+
+import (
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"telegram-tickets-bot/src/metrics"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	updatesProcessed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ticketsbot_updates_total",
+		Help: "Telegram updates processed, by type.",
+	}, []string{"tenant", "type"})
+	handlerDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "ticketsbot_handler_duration_seconds",
+		Help:    "Time spent handling an update, by handler.",
+		Buckets: metrics.DefaultBuckets,
+	}, []string{"tenant", "handler"})
+	handlerErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ticketsbot_handler_errors_total",
+		Help: "Updates whose handler returned an error, by handler.",
+	}, []string{"tenant", "handler"})
+	telegramRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "ticketsbot_telegram_request_duration_seconds",
+		Help:    "Time spent on Telegram Bot API calls, by method.",
+		Buckets: metrics.DefaultBuckets,
+	}, []string{"tenant", "method"})
+	telegramRequestFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ticketsbot_telegram_request_failures_total",
+		Help: "Telegram Bot API calls that failed or were rejected, by method.",
+	}, []string{"tenant", "method"})
+	conversationsInProgress = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ticketsbot_conversations_in_progress",
+		Help: "Chats in the middle of a multi-step conversation, by state.",
+	}, []string{"tenant", "state"})
+)
+
+// State reported for admins who are choosing the audience of a broadcast or confirming it
+const stateBroadcastDraft = "broadcast_draft"
+
+var conversationMetricStates = []string{
+	StateWaitingForTitle, StateWaitingForDesc, StateWaitingForComment, StateWaitingForBroadcast, stateBroadcastDraft,
+}
+
+// Callback handlers, named by the fixed part of their callback data, so that IDs do not become label values
+var callbackHandlers = []string{
+	"create_ticket", "view_tickets", "view_all_tickets", "get_info", "confirm_ticket", "cancel_ticket",
+	"notif_event", "notif_quiet", "broadcast_audience", "broadcast_confirm", "broadcast_cancel",
+	"ticket_page", "view_ticket", "close_ticket", "add_comment", "assign_ticket", "assign_to", "reply_ticket",
+}
+
+// Name the handler of an update for the handler metrics
+func handlerName(update tgbotapi.Update, inForum bool) string {
+	switch {
+	case update.Message != nil && inForum:
+		return "forum_message"
+	case update.Message != nil && update.Message.IsCommand():
+		return "command:" + commandName(update.Message.Command())
+	case update.Message != nil:
+		return "message"
+	case update.EditedMessage != nil:
+		return "edited_message"
+	case update.InlineQuery != nil:
+		return "inline_query"
+	case update.CallbackQuery != nil:
+		return "callback:" + callbackName(update.CallbackQuery.Data)
+	default:
+		return "other"
+	}
+}
+
+func updateType(update tgbotapi.Update) string {
+	switch {
+	case update.Message != nil:
+		return "message"
+	case update.EditedMessage != nil:
+		return "edited_message"
+	case update.InlineQuery != nil:
+		return "inline_query"
+	case update.CallbackQuery != nil:
+		return "callback_query"
+	default:
+		return "other"
+	}
+}
+
+func commandName(command string) string {
+	if command == "start" {
+		return command
+	}
+	for _, known := range adminMenuCommands {
+		if known.Command == command {
+			return command
+		}
+	}
+	return "unknown"
+}
+
+func callbackName(data string) string {
+	for _, name := range callbackHandlers {
+		if data == name || strings.HasPrefix(data, name+"_") {
+			return name
+		}
+	}
+	return "unknown"
+}
+
+// Record an update and how long its handler took
+func (b *Bot) recordUpdate(update tgbotapi.Update, handler string, start time.Time, err error) {
+	updatesProcessed.WithLabelValues(b.tenant, updateType(update)).Inc()
+	handlerDuration.WithLabelValues(b.tenant, handler).Observe(time.Since(start).Seconds())
+	if err != nil {
+		handlerErrors.WithLabelValues(b.tenant, handler).Inc()
+	}
+}
+
+// Report the conversations in progress. Called on the goroutine running HandleUpdates, which owns the state.
+func (b *Bot) recordConversations() {
+	counts := make(map[string]int)
+	for _, state := range b.userStates {
+		if state != StateNone {
+			counts[state]++
+		}
+	}
+	for chatID := range b.broadcastDrafts {
+		if b.userStates[chatID] == StateNone {
+			counts[stateBroadcastDraft]++
+		}
+	}
+	for _, state := range conversationMetricStates {
+		conversationsInProgress.WithLabelValues(b.tenant, state).Set(float64(counts[state]))
+	}
+}
+
+// HTTP client of the Bot API that times every call
+type instrumentedClient struct {
+	tenant string
+	client *http.Client
+}
+
+func (c *instrumentedClient) Do(request *http.Request) (*http.Response, error) {
+	// Bot API URLs end in the method name, e.g. /bot<token>/sendMessage
+	method := path.Base(request.URL.Path)
+	start := time.Now()
+
+	response, err := c.client.Do(request)
+	telegramRequestDuration.WithLabelValues(c.tenant, method).Observe(time.Since(start).Seconds())
+	if err != nil || response.StatusCode != http.StatusOK {
+		telegramRequestFailures.WithLabelValues(c.tenant, method).Inc()
+	}
+	return response, err
+}
@@ -1,15 +1,47 @@
 package telegram
 
-import tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+import (
+	"log/slog"
 
-func (b *Bot) HandleCommand(message *tgbotapi.Message) error {
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// HandleCommand runs a command. A conversation left pending by it is kept, and the chat is reminded of it.
+func (b *Bot) HandleCommand(logger *slog.Logger, message *tgbotapi.Message) error {
+	chatID := message.Chat.ID
+	pending := b.userStates[chatID]
+
+	if err := b.dispatchCommand(logger, message); err != nil {
+		return err
+	}
+	if pending != StateNone && b.userStates[chatID] == pending {
+		return b.remindPendingConversation(chatID)
+	}
+	return nil
+}
+
+func (b *Bot) dispatchCommand(logger *slog.Logger, message *tgbotapi.Message) error {
 	switch message.Command() {
+	case "cancel":
+		return b.HandleCancelCommand(message)
+	case "notifications":
+		return b.HandleNotificationsCommand(logger, message)
 	case "getme":
 		return b.HandleGetMeCommand(message)
-	case "help", "start":
+	case "start":
+		return b.HandleStartCommand(logger, message)
+	case "help":
 		return b.HandleHelpCommand(message)
 	case "tickets":
-		return b.HandleAdminViewTickets(message)
+		return b.HandleAdminViewTickets(logger, message, 0)
+	case "digest":
+		return b.HandleDigestCommand(message)
+	case "broadcast":
+		return b.HandleBroadcastCommand(message)
+	case "apitoken":
+		return b.HandleAPITokenCommand(message)
+	case "webhooks":
+		return b.HandleWebhooksCommand(message)
 	default:
 		return b.SendMessage(message.Chat.ID, "未知命令,请尝试 /help 获取帮助。")
 	}
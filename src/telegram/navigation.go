@@ -0,0 +1,100 @@
+package telegram
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"unicode/utf16"
+
+	"telegram-tickets-bot/src/database"
+	"telegram-tickets-bot/src/tickets"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Number of recent cards refreshed when a ticket changes
+const maxRefreshedCards = 20
+
+func isNotModifiedError(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "message is not modified")
+}
+
+func isMessageGoneError(err error) bool {
+	return err != nil && (strings.Contains(err.Error(), "message to edit not found") ||
+		strings.Contains(err.Error(), "message can't be edited"))
+}
+
+// ShowScreen replaces the text (Telegram HTML) and keyboard of editMessageID with a new screen. When there is no message
+// to edit, the text does not fit into one message, or editing fails, a new message is sent instead.
+// It returns the ID of the message now showing the screen.
+func (b *Bot) ShowScreen(logger *slog.Logger, chatID int64, editMessageID int, text string, keyboard tgbotapi.InlineKeyboardMarkup) (int, error) {
+	if editMessageID != 0 && len(utf16.Encode([]rune(text))) <= maxMessageLength {
+		editMsg := tgbotapi.NewEditMessageTextAndMarkup(chatID, editMessageID, text, keyboard)
+		editMsg.ParseMode = tgbotapi.ModeHTML
+		_, err := b.send(chatID, editMsg)
+		if err == nil || isNotModifiedError(err) {
+			return editMessageID, nil
+		}
+		logger.Warn("Failed to edit message, sending a new one", "chat_id", chatID, "message_id", editMessageID, "error", err)
+	}
+
+	sent, err := b.sendText(chatID, text, tgbotapi.ModeHTML, keyboard)
+	if err != nil {
+		return 0, err
+	}
+	return sent.MessageID, nil
+}
+
+// ShowListScreen shows a ticket list in place of editMessageID; the message no longer displays a ticket card
+func (b *Bot) ShowListScreen(logger *slog.Logger, chatID int64, editMessageID int, text string, keyboard tgbotapi.InlineKeyboardMarkup) error {
+	if editMessageID != 0 {
+		db, err := database.InitializeDB()
+		if err != nil {
+			return fmt.Errorf("[ERROR] Failed to get database connection: %v", err)
+		}
+		if err := tickets.DeleteTicketMessage(db, b.tenant, chatID, editMessageID); err != nil {
+			logger.Error("Failed to forget ticket card", "message_id", editMessageID, "error", err)
+		}
+	}
+
+	_, err := b.ShowScreen(logger, chatID, editMessageID, text, keyboard)
+	return err
+}
+
+// RefreshTicketCards re-renders every recent card of the ticket at the page it shows, so that stale views
+// show its current state
+func (b *Bot) RefreshTicketCards(ticketID int) error {
+	db, err := database.InitializeDB()
+	if err != nil {
+		return fmt.Errorf("[ERROR] Failed to get database connection: %v", err)
+	}
+
+	cards, err := tickets.GetTicketCards(db, ticketID, maxRefreshedCards)
+	if err != nil {
+		return err
+	}
+
+	for _, card := range cards {
+		text, keyboard, err := b.renderTicketView(b.logger, db, ticketID, card.Page, card.ViewerID)
+		if err != nil {
+			return err
+		}
+		if len(utf16.Encode([]rune(text))) > maxMessageLength {
+			// Too long to fit into the card; it will be re-sent when opened again
+			continue
+		}
+
+		editMsg := tgbotapi.NewEditMessageTextAndMarkup(card.ChatID, card.MessageID, text, keyboard)
+		editMsg.ParseMode = tgbotapi.ModeHTML
+		_, err = b.send(card.ChatID, editMsg)
+		if isMessageGoneError(err) {
+			if err := tickets.DeleteTicketMessage(db, b.tenant, card.ChatID, card.MessageID); err != nil {
+				b.logger.Error("Failed to forget ticket card", "chat_id", card.ChatID, "ticket_id", ticketID, "error", err)
+			}
+		} else if err != nil && !isNotModifiedError(err) {
+			b.logger.Error("Failed to refresh ticket card", "chat_id", card.ChatID, "message_id", card.MessageID, "ticket_id", ticketID, "error", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,350 @@
+package telegram
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"telegram-tickets-bot/src/database"
+	"telegram-tickets-bot/src/tickets"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// How often pending notifications are checked for delivery
+const digestCheckInterval = time.Minute
+
+// Events offered on the settings screen, in display order
+var (
+	adminNotificationEvents = []string{
+		database.EventNewTicket, database.EventAssignment, database.EventComment,
+		database.EventStatusChange, database.EventSLAWarning,
+	}
+	userNotificationEvents = []string{database.EventComment, database.EventStatusChange}
+)
+
+var notificationEventLabels = map[string]string{
+	database.EventNewTicket:    "新工单",
+	database.EventAssignment:   "工单分配",
+	database.EventComment:      "新回复",
+	database.EventStatusChange: "状态变更",
+	database.EventSLAWarning:   "SLA 预警",
+}
+
+var notificationModeLabels = map[string]string{
+	database.NotifyInstant: "即时",
+	database.NotifyDigest:  "摘要",
+	database.NotifyOff:     "关闭",
+}
+
+// Next mode when the button of an event is pressed
+var nextNotificationMode = map[string]string{
+	database.NotifyInstant: database.NotifyDigest,
+	database.NotifyDigest:  database.NotifyOff,
+	database.NotifyOff:     database.NotifyInstant,
+}
+
+// Quiet hours the settings screen cycles through; nil turns them off
+var quietHoursPresets = []*database.QuietHours{
+	nil,
+	{StartHour: 22, EndHour: 8},
+	{StartHour: 23, EndHour: 7},
+	{StartHour: 0, EndHour: 8},
+}
+
+func quietHoursLabel(quiet *database.QuietHours) string {
+	if quiet == nil {
+		return "关闭"
+	}
+	return fmt.Sprintf("%02d:00-%02d:00", quiet.StartHour, quiet.EndHour)
+}
+
+// Notify delivers a notification to telegramID according to their preferences for event: right away,
+// with the next digest, or not at all. Instant notifications arriving during the recipient's quiet hours
+// are held until the quiet hours end. ticketID is 0 for notifications not about a single ticket.
+func (b *Bot) Notify(telegramID int64, event string, ticketID int, text string, keyboard tgbotapi.InlineKeyboardMarkup) error {
+	db, err := database.InitializeDB()
+	if err != nil {
+		return fmt.Errorf("[ERROR] Failed to get database connection: %v", err)
+	}
+
+	mode, err := database.GetNotificationMode(db, b.tenant, telegramID, event)
+	if err != nil {
+		return err
+	}
+
+	switch mode {
+	case database.NotifyOff:
+		return nil
+	case database.NotifyDigest:
+		return database.AddPendingNotification(db, &database.PendingNotification{
+			Tenant: b.tenant, TelegramID: telegramID, Event: event, TicketID: ticketID, Content: text,
+		})
+	}
+
+	quiet, err := database.GetQuietHours(db, b.tenant, telegramID)
+	if err != nil {
+		return err
+	}
+	if quiet != nil && quiet.Contains(time.Now()) {
+		return database.AddPendingNotification(db, &database.PendingNotification{
+			Tenant: b.tenant, TelegramID: telegramID, Event: event, TicketID: ticketID, Content: text, Held: true,
+		})
+	}
+
+	if ticketID == 0 {
+		return b.SendHTMLWithInlineKeyboard(telegramID, text, keyboard)
+	}
+	return b.SendTicketNotification(telegramID, ticketID, text+"\n\n<i>(直接回复此消息即可回复工单)</i>", keyboard)
+}
+
+// NotifyAll notifies every recipient in parallel and logs the failures
+func (b *Bot) NotifyAll(telegramIDs []int64, event string, ticketID int, text string, keyboard tgbotapi.InlineKeyboardMarkup) {
+	var wg sync.WaitGroup
+	for _, telegramID := range telegramIDs {
+		wg.Add(1)
+		go func(telegramID int64) {
+			defer wg.Done()
+			if err := b.Notify(telegramID, event, ticketID, text, keyboard); err != nil {
+				b.logger.Error("Failed to notify", "chat_id", telegramID, "event", event, "ticket_id", ticketID, "error", err)
+			}
+		}(telegramID)
+	}
+	wg.Wait()
+}
+
+// StartNotificationDigests delivers digests, held notifications and SLA warnings in the background
+func (b *Bot) StartNotificationDigests() {
+	go func() {
+		for {
+			time.Sleep(digestCheckInterval)
+			if err := b.WarnSLABreaches(); err != nil {
+				b.logger.Error("Failed to send SLA warnings", "error", err)
+			}
+			if err := b.FlushNotifications(); err != nil {
+				b.logger.Error("Failed to deliver notification digests", "error", err)
+			}
+		}
+	}()
+}
+
+// WarnSLABreaches warns about every ticket that has waited on staff longer than the SLA allows, once per wait:
+// the assigned admin, or all admins while the ticket is unassigned
+func (b *Bot) WarnSLABreaches() error {
+	db, err := database.InitializeDB()
+	if err != nil {
+		return fmt.Errorf("[ERROR] Failed to get database connection: %v", err)
+	}
+
+	slaHours := b.settings().Digest.SLAHours
+	breaches, err := tickets.GetTicketsDueForSLAWarning(db, b.tenant, time.Now().Add(-time.Duration(slaHours)*time.Hour))
+	if err != nil || len(breaches) == 0 {
+		return err
+	}
+
+	admins, err := database.GetAdmins(db, b.tenant)
+	if err != nil {
+		return err
+	}
+
+	for _, ticket := range breaches {
+		var recipients []int64
+		for _, admin := range admins {
+			if ticket.AssignedTo == nil || *ticket.AssignedTo == admin.AdminID {
+				recipients = append(recipients, admin.TelegramID)
+			}
+		}
+
+		message := fmt.Sprintf("<b>SLA 预警</b>\n工单已等待 Staff 回复 %s，超过 SLA 规定的 %d 小时。\n%s",
+			escapeHTML(formatAge(time.Since(ticket.WaitingSince))), slaHours, ticketSummaryHTML(&ticket.Ticket))
+		keyboard := tgbotapi.NewInlineKeyboardMarkup(
+			tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData("查看工单", fmt.Sprintf("view_ticket_%d", ticket.TicketID)),
+			),
+		)
+		b.NotifyAll(recipients, database.EventSLAWarning, ticket.TicketID, message, keyboard)
+
+		if err := tickets.MarkSLAWarned(db, ticket.TicketID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FlushNotifications sends each recipient outside their quiet hours a digest of their pending notifications,
+// once their oldest notification has waited for the digest interval or a held notification is due
+func (b *Bot) FlushNotifications() error {
+	db, err := database.InitializeDB()
+	if err != nil {
+		return fmt.Errorf("[ERROR] Failed to get database connection: %v", err)
+	}
+
+	pending, err := database.GetPendingNotifications(db, b.tenant)
+	if err != nil {
+		return err
+	}
+
+	interval := time.Duration(b.settings().Notifications.DigestInterval) * time.Minute
+	now := time.Now()
+
+	for start := 0; start < len(pending); {
+		end := start
+		for end < len(pending) && pending[end].TelegramID == pending[start].TelegramID {
+			end++
+		}
+		group := pending[start:end]
+		start = end
+
+		telegramID := group[0].TelegramID
+		quiet, err := database.GetQuietHours(db, b.tenant, telegramID)
+		if err != nil {
+			return err
+		}
+		if quiet != nil && quiet.Contains(now) {
+			continue
+		}
+
+		due := now.Sub(group[0].CreatedAt) >= interval
+		ids := make([]int, 0, len(group))
+		for _, notification := range group {
+			due = due || notification.Held
+			ids = append(ids, notification.NotificationID)
+		}
+		if !due {
+			continue
+		}
+
+		err = b.SendHTMLMessage(telegramID, digestText(group))
+		var apiErr *tgbotapi.Error
+		if errors.As(err, &apiErr) && apiErr.Code == 403 {
+			// The recipient blocked the bot; the notifications can never be delivered
+			if err := database.MarkUserBlocked(db, b.tenant, telegramID); err != nil {
+				b.logger.Error("Failed to mark user blocked", "chat_id", telegramID, "error", err)
+			}
+		} else if err != nil {
+			b.logger.Error("Failed to send notification digest", "chat_id", telegramID, "error", err)
+			continue
+		}
+
+		if err := database.DeletePendingNotifications(db, ids); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func digestText(notifications []database.PendingNotification) string {
+	var text strings.Builder
+	fmt.Fprintf(&text, "<b>通知摘要</b>（%d 条）", len(notifications))
+	for _, notification := range notifications {
+		fmt.Fprintf(&text, "\n\n<i>%s</i>\n%s", notification.CreatedAt.Format("01-02 15:04"), notification.Content)
+	}
+	return text.String()
+}
+
+// HandleNotificationsCommand shows the notification settings screen
+func (b *Bot) HandleNotificationsCommand(logger *slog.Logger, message *tgbotapi.Message) error {
+	return b.showNotificationSettings(logger, message.Chat.ID, 0, message.From.ID)
+}
+
+func (b *Bot) showNotificationSettings(logger *slog.Logger, chatID int64, editMessageID int, telegramID int64) error {
+	db, err := database.InitializeDB()
+	if err != nil {
+		return fmt.Errorf("[ERROR] Failed to get database connection: %v", err)
+	}
+
+	isAdmin, err := database.IsUserAdmin(b.tenant, telegramID)
+	if err != nil {
+		return fmt.Errorf("[ERROR] Failed to check admin status: %v", err)
+	}
+	events := userNotificationEvents
+	if isAdmin {
+		events = adminNotificationEvents
+	}
+
+	modes, err := database.GetNotificationModes(db, b.tenant, telegramID)
+	if err != nil {
+		return err
+	}
+	quiet, err := database.GetQuietHours(db, b.tenant, telegramID)
+	if err != nil {
+		return err
+	}
+
+	keyboard := tgbotapi.NewInlineKeyboardMarkup()
+	for _, event := range events {
+		mode, ok := modes[event]
+		if !ok {
+			mode = database.NotifyInstant
+		}
+		label := fmt.Sprintf("%s：%s", notificationEventLabels[event], notificationModeLabels[mode])
+		keyboard.InlineKeyboard = append(keyboard.InlineKeyboard, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(label, "notif_event_"+event),
+		))
+	}
+	keyboard.InlineKeyboard = append(keyboard.InlineKeyboard, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("免打扰："+quietHoursLabel(quiet), "notif_quiet"),
+	))
+
+	text := fmt.Sprintf("<b>通知设置</b>\n点击按钮切换接收方式：即时 → 摘要 → 关闭。\n"+
+		"摘要通知每 %d 分钟合并发送一次，免打扰时段内的即时通知将在时段结束后发送。",
+		b.settings().Notifications.DigestInterval)
+
+	_, err = b.ShowScreen(logger, chatID, editMessageID, text, keyboard)
+	return err
+}
+
+// HandleNotificationCallback cycles the mode of an event or the quiet hours and redraws the settings screen
+func (b *Bot) HandleNotificationCallback(logger *slog.Logger, callbackQuery *tgbotapi.CallbackQuery) error {
+	chatID := callbackQuery.Message.Chat.ID
+	telegramID := callbackQuery.From.ID
+	data := callbackQuery.Data
+
+	db, err := database.InitializeDB()
+	if err != nil {
+		return fmt.Errorf("[ERROR] Failed to get database connection: %v", err)
+	}
+
+	switch {
+	case strings.HasPrefix(data, "notif_event_"):
+		event := strings.TrimPrefix(data, "notif_event_")
+		if _, ok := notificationEventLabels[event]; !ok {
+			return b.SendMessage(chatID, "未知的选项。")
+		}
+		mode, err := database.GetNotificationMode(db, b.tenant, telegramID, event)
+		if err != nil {
+			return err
+		}
+		if err := database.SetNotificationMode(db, b.tenant, telegramID, event, nextNotificationMode[mode]); err != nil {
+			return err
+		}
+	case data == "notif_quiet":
+		quiet, err := database.GetQuietHours(db, b.tenant, telegramID)
+		if err != nil {
+			return err
+		}
+		next := 0
+		for i, preset := range quietHoursPresets {
+			if quietHoursLabel(preset) == quietHoursLabel(quiet) {
+				next = (i + 1) % len(quietHoursPresets)
+				break
+			}
+		}
+		var selected *database.QuietHours
+		if preset := quietHoursPresets[next]; preset != nil {
+			copied := *preset
+			selected = &copied
+		}
+		if err := database.SetQuietHours(db, b.tenant, telegramID, selected); err != nil {
+			return err
+		}
+	default:
+		return b.SendMessage(chatID, "未知的选项。")
+	}
+
+	return b.showNotificationSettings(logger, chatID, callbackQuery.Message.MessageID, telegramID)
+}
@@ -0,0 +1,306 @@
+package telegram
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Telegram limits: about 30 messages per second overall, one message per second in a private chat
+// and 20 messages per minute in a group. Small bursts are tolerated.
+const (
+	globalRate         = 30.0
+	globalBurst        = 30.0
+	privateChatRate    = 1.0
+	groupChatRate      = 20.0 / 60.0
+	chatBurst          = 3.0
+	maxDeliveryTries   = 5
+	maxFloodWaitTries  = 10
+	retryBaseDelay     = time.Second
+	idleChatExpiration = time.Minute
+)
+
+// DeliveryResult is the outcome of a queued outbound request
+type DeliveryResult struct {
+	ChatID   int64
+	Message  tgbotapi.Message
+	Attempts int
+	Err      error
+}
+
+// Delivery tracks the queued parts of one outgoing text
+type Delivery []<-chan DeliveryResult
+
+// Wait blocks until every part is delivered and returns the first failure, or the result of the last part
+func (d Delivery) Wait() DeliveryResult {
+	var result DeliveryResult
+	var failure *DeliveryResult
+	for _, ch := range d {
+		result = <-ch
+		if result.Err != nil && failure == nil {
+			r := result
+			failure = &r
+		}
+	}
+	if failure != nil {
+		return *failure
+	}
+	return result
+}
+
+type outboundJob struct {
+	chatID     int64
+	do         func() (tgbotapi.Message, error)
+	attempts   int
+	floodWaits int
+	done       chan DeliveryResult
+	// Called with the final error, nil on success, before the chat's next request starts
+	settled func(err error)
+}
+
+// Token bucket refilled at rate tokens per second up to burst
+type rateBucket struct {
+	rate    float64
+	burst   float64
+	tokens  float64
+	updated time.Time
+}
+
+func newRateBucket(rate float64, burst float64, now time.Time) *rateBucket {
+	return &rateBucket{rate: rate, burst: burst, tokens: burst, updated: now}
+}
+
+func (r *rateBucket) refill(now time.Time) {
+	r.tokens = math.Min(r.burst, r.tokens+now.Sub(r.updated).Seconds()*r.rate)
+	r.updated = now
+}
+
+// Return how long to wait until a token is available
+func (r *rateBucket) wait(now time.Time) time.Duration {
+	r.refill(now)
+	if r.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - r.tokens) / r.rate * float64(time.Second))
+}
+
+func (r *rateBucket) take(now time.Time) {
+	r.refill(now)
+	r.tokens--
+}
+
+type chatSendState struct {
+	bucket   *rateBucket
+	busy     bool
+	retryAt  time.Time
+	lastUsed time.Time
+}
+
+// Outbound queue that sends requests in order per chat while respecting Telegram's rate limits
+type outboundQueue struct {
+	mu      sync.Mutex
+	pending []*outboundJob
+	chats   map[int64]*chatSendState
+	global  *rateBucket
+	wake    chan struct{}
+}
+
+func newOutboundQueue() *outboundQueue {
+	q := &outboundQueue{
+		chats:  make(map[int64]*chatSendState),
+		global: newRateBucket(globalRate, globalBurst, time.Now()),
+		wake:   make(chan struct{}, 1),
+	}
+	go q.run()
+	return q
+}
+
+// Queue a request for chatID; the result is delivered on the returned channel
+func (q *outboundQueue) enqueue(chatID int64, do func() (tgbotapi.Message, error)) <-chan DeliveryResult {
+	return q.enqueueSettled(chatID, do, nil)
+}
+
+// Queue a request like enqueue and call settled with its final error once it is no longer retried
+func (q *outboundQueue) enqueueSettled(chatID int64, do func() (tgbotapi.Message, error), settled func(err error)) <-chan DeliveryResult {
+	job := &outboundJob{chatID: chatID, do: do, settled: settled, done: make(chan DeliveryResult, 1)}
+
+	q.mu.Lock()
+	q.pending = append(q.pending, job)
+	q.mu.Unlock()
+
+	q.signal()
+	return job.done
+}
+
+func (q *outboundQueue) signal() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (q *outboundQueue) run() {
+	for {
+		q.mu.Lock()
+		wait := q.dispatch(time.Now())
+		q.mu.Unlock()
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-q.wake:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+func (q *outboundQueue) chat(chatID int64, now time.Time) *chatSendState {
+	state, ok := q.chats[chatID]
+	if !ok {
+		rate := privateChatRate
+		if chatID < 0 {
+			rate = groupChatRate
+		}
+		state = &chatSendState{bucket: newRateBucket(rate, chatBurst, now)}
+		q.chats[chatID] = state
+	}
+	return state
+}
+
+// Start every job that may be sent now and return how long to wait before the next one could be.
+// Only the first pending job of a chat is considered, so each chat's messages keep their order.
+func (q *outboundQueue) dispatch(now time.Time) time.Duration {
+	wait := time.Hour
+	blocked := make(map[int64]bool)
+	remaining := make([]*outboundJob, 0, len(q.pending))
+
+	for _, job := range q.pending {
+		if blocked[job.chatID] {
+			remaining = append(remaining, job)
+			continue
+		}
+
+		chat := q.chat(job.chatID, now)
+		delay := time.Duration(0)
+		if chat.busy {
+			delay = time.Hour
+		} else if now.Before(chat.retryAt) {
+			delay = chat.retryAt.Sub(now)
+		} else if d := chat.bucket.wait(now); d > 0 {
+			delay = d
+		} else if d := q.global.wait(now); d > 0 {
+			delay = d
+		}
+
+		if delay > 0 {
+			blocked[job.chatID] = true
+			remaining = append(remaining, job)
+			if delay < wait {
+				wait = delay
+			}
+			continue
+		}
+
+		chat.bucket.take(now)
+		q.global.take(now)
+		chat.busy = true
+		chat.lastUsed = now
+		blocked[job.chatID] = true
+		go q.execute(job)
+	}
+	q.pending = remaining
+
+	// Forget idle chats whose rate limits have long recovered
+	for chatID, chat := range q.chats {
+		if !chat.busy && !blocked[chatID] && now.Sub(chat.lastUsed) > idleChatExpiration && now.After(chat.retryAt) {
+			delete(q.chats, chatID)
+		}
+	}
+
+	return wait
+}
+
+func (q *outboundQueue) execute(job *outboundJob) {
+	message, err := job.do()
+	job.attempts++
+
+	retryAfter, retry := retryDelay(job, err)
+	if !retry && job.settled != nil {
+		// While the chat is busy its next request cannot start, so that request sees the outcome
+		job.settled(err)
+	}
+
+	q.mu.Lock()
+	chat := q.chat(job.chatID, time.Now())
+	chat.busy = false
+	if retry {
+		chat.retryAt = time.Now().Add(retryAfter)
+		// Put the job back in front so the chat's remaining messages stay in order
+		q.pending = append([]*outboundJob{job}, q.pending...)
+	}
+	q.mu.Unlock()
+
+	if !retry {
+		job.done <- DeliveryResult{ChatID: job.chatID, Message: message, Attempts: job.attempts, Err: err}
+	}
+	q.signal()
+}
+
+// Decide whether a failed request is retried and after how long. Flood control errors are retried
+// after the requested retry_after; server and network errors with exponential backoff.
+// Other failures are final.
+func retryDelay(job *outboundJob, err error) (time.Duration, bool) {
+	if err == nil {
+		return 0, false
+	}
+
+	var apiErr *tgbotapi.Error
+	if errors.As(err, &apiErr) {
+		if apiErr.Code == 429 && job.floodWaits < maxFloodWaitTries {
+			job.floodWaits++
+			if apiErr.RetryAfter <= 0 {
+				return retryBaseDelay, true
+			}
+			return time.Duration(apiErr.RetryAfter) * time.Second, true
+		}
+		if apiErr.Code < 500 {
+			return 0, false
+		}
+	} else if urlErr := (*url.Error)(nil); !errors.As(err, &urlErr) {
+		// Neither a server nor a network error, e.g. an undecodable response
+		return 0, false
+	}
+
+	failures := job.attempts - job.floodWaits
+	if failures >= maxDeliveryTries {
+		return 0, false
+	}
+	return retryBaseDelay * time.Duration(1<<(failures-1)), true
+}
+
+// Send a request to chatID through the outbound queue and wait for the result
+func (b *Bot) send(chatID int64, c tgbotapi.Chattable) (tgbotapi.Message, error) {
+	result := <-b.queue.enqueue(chatID, func() (tgbotapi.Message, error) {
+		return b.api.Send(c)
+	})
+	return result.Message, result.Err
+}
+
+// Call a raw Bot API method concerning chatID through the outbound queue and wait for the response
+func (b *Bot) makeRequest(chatID int64, endpoint string, params tgbotapi.Params) (*tgbotapi.APIResponse, error) {
+	var resp *tgbotapi.APIResponse
+	result := <-b.queue.enqueue(chatID, func() (tgbotapi.Message, error) {
+		var err error
+		resp, err = b.api.MakeRequest(endpoint, params)
+		return tgbotapi.Message{}, err
+	})
+	if result.Err != nil {
+		return nil, fmt.Errorf("%s: %w", endpoint, result.Err)
+	}
+	return resp, nil
+}
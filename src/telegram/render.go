@@ -0,0 +1,159 @@
+package telegram
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf16"
+
+	"telegram-tickets-bot/src/tickets"
+)
+
+// Room left in every chunk for the tags that have to be closed and reopened around a split
+const htmlSplitReserve = 256
+
+// Maximum length of a quoted previous reply
+const maxQuoteLength = 300
+
+var htmlEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+var (
+	codeFencePattern = regexp.MustCompile("(?s)```[\\w+-]*\\n?(.*?)```")
+	logLinePattern   = regexp.MustCompile(`^\s*(\d{4}[-/]\d{2}[-/]\d{2}|\[?(TRACE|DEBUG|INFO|WARN|WARNING|ERROR|FATAL)\]?\b|at \S+\(|Traceback|File ")`)
+	htmlTagPattern   = regexp.MustCompile(`<(/?)([a-z-]+)[^>]*>`)
+)
+
+// Escape text for Telegram HTML parse mode. All user-supplied content must go through this.
+func escapeHTML(text string) string {
+	return htmlEscaper.Replace(text)
+}
+
+// Render a bold label followed by an escaped value
+func htmlField(label string, value string) string {
+	return "<b>" + escapeHTML(label) + ":</b> " + escapeHTML(value)
+}
+
+// Render user-supplied content. ``` fenced blocks and pasted logs are shown as code blocks.
+func htmlContent(content string) string {
+	if looksLikeLog(content) {
+		return "<pre>" + escapeHTML(content) + "</pre>"
+	}
+
+	var result strings.Builder
+	last := 0
+	for _, match := range codeFencePattern.FindAllStringSubmatchIndex(content, -1) {
+		result.WriteString(escapeHTML(content[last:match[0]]))
+		result.WriteString("<pre>" + escapeHTML(strings.TrimRight(content[match[2]:match[3]], "\n")) + "</pre>")
+		last = match[1]
+	}
+	result.WriteString(escapeHTML(content[last:]))
+	return result.String()
+}
+
+// Render a previous reply as a quote, shortened to maxQuoteLength characters
+func htmlQuote(content string) string {
+	return "<blockquote>" + escapeHTML(truncateText(content, maxQuoteLength)) + "</blockquote>"
+}
+
+func truncateText(text string, maxRunes int) string {
+	runes := []rune(text)
+	if len(runes) <= maxRunes {
+		return text
+	}
+	return string(runes[:maxRunes-1]) + "…"
+}
+
+// Render the ID, title and description of a ticket for notifications
+func ticketSummaryHTML(ticket *tickets.Ticket) string {
+	return htmlField("工单ID", fmt.Sprint(ticket.TicketID)) + "\n" +
+		htmlField("标题", ticket.Title) + "\n" +
+		"<b>描述:</b>\n" + htmlContent(ticket.Description)
+}
+
+// Report whether most lines of a multi-line text look like log output or a stack trace
+func looksLikeLog(content string) bool {
+	lines := strings.Split(strings.TrimSpace(content), "\n")
+	if len(lines) < 3 {
+		return false
+	}
+
+	matched := 0
+	for _, line := range lines {
+		if logLinePattern.MatchString(line) {
+			matched++
+		}
+	}
+	return matched*2 >= len(lines)
+}
+
+// Split Telegram HTML into chunks that fit into a single message. Splits never happen inside a tag
+// or entity, and tags left open at a split are closed at the end of the chunk and reopened in the next.
+func splitHTMLMessage(text string, limit int) []string {
+	var chunks []string
+	var openTags []string
+	for _, part := range splitText(text, limit-htmlSplitReserve, true) {
+		chunk := strings.Join(openTags, "") + part
+
+		for _, match := range htmlTagPattern.FindAllStringSubmatch(part, -1) {
+			if match[1] == "" {
+				openTags = append(openTags, match[0])
+			} else if len(openTags) > 0 {
+				openTags = openTags[:len(openTags)-1]
+			}
+		}
+		for i := len(openTags) - 1; i >= 0; i-- {
+			name := htmlTagPattern.FindStringSubmatch(openTags[i])[2]
+			chunk += "</" + name + ">"
+		}
+
+		chunks = append(chunks, chunk)
+	}
+	return chunks
+}
+
+// Split text into chunks of at most limit UTF-16 code units, preferring to break at line ends.
+// In HTML mode a chunk never ends inside a tag or an entity.
+func splitText(text string, limit int, html bool) []string {
+	var chunks []string
+	for {
+		length, cut, lastNewline, lastSafe := 0, -1, -1, -1
+		inTag, inEntity := false, false
+		for i, r := range text {
+			if !inTag && !inEntity {
+				lastSafe = i
+			}
+			length += utf16.RuneLen(r)
+			if length > limit {
+				cut = i
+				break
+			}
+
+			switch {
+			case r == '\n':
+				lastNewline = i
+			case html && r == '<':
+				inTag = true
+			case html && r == '>':
+				inTag = false
+			case html && r == '&':
+				inEntity = true
+			case html && r == ';':
+				inEntity = false
+			}
+		}
+		if cut < 0 {
+			return append(chunks, text)
+		}
+
+		if lastNewline > 0 {
+			chunks = append(chunks, text[:lastNewline])
+			text = text[lastNewline+1:]
+			continue
+		}
+		if html && lastSafe > 0 {
+			cut = lastSafe
+		}
+		chunks = append(chunks, text[:cut])
+		text = text[cut:]
+	}
+}
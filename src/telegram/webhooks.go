@@ -0,0 +1,131 @@
+package telegram
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"telegram-tickets-bot/src/database"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"gorm.io/gorm"
+)
+
+// Deliveries listed by /webhooks
+const webhookLogLength = 15
+
+var deliveryStatusLabels = map[string]string{
+	database.DeliveryPending:   "⏳ 等待投递",
+	database.DeliveryDelivered: "✅ 已送达",
+	database.DeliveryFailed:    "❌ 失败",
+}
+
+func webhooksUsage() string {
+	return "/webhooks - 查看订阅和最近的投递记录\n" +
+		"/webhooks show &lt;ID&gt; - 查看投递详情\n" +
+		"/webhooks retry &lt;ID&gt; - 重新投递失败的记录"
+}
+
+// HandleWebhooksCommand shows admins the tenant's webhook subscriptions and delivery log, and retries failed deliveries
+func (b *Bot) HandleWebhooksCommand(message *tgbotapi.Message) error {
+	chatID := message.Chat.ID
+
+	isAdmin, err := database.IsUserAdmin(b.tenant, message.From.ID)
+	if err != nil {
+		return fmt.Errorf("[ERROR] Failed to check admin status: %v", err)
+	}
+	if !isAdmin {
+		return b.SendMessage(chatID, "对不起，只有管理员可以使用此命令。")
+	}
+
+	db, err := database.InitializeDB()
+	if err != nil {
+		return fmt.Errorf("[ERROR] Failed to get database connection: %v", err)
+	}
+
+	args := strings.Fields(message.CommandArguments())
+	if len(args) == 0 {
+		return b.showWebhookLog(db, chatID)
+	}
+	if len(args) != 2 || (args[0] != "show" && args[0] != "retry") {
+		return b.SendHTMLMessage(chatID, webhooksUsage())
+	}
+	deliveryID, err := strconv.Atoi(strings.TrimPrefix(args[1], "#"))
+	if err != nil {
+		return b.SendHTMLMessage(chatID, webhooksUsage())
+	}
+
+	if args[0] == "retry" {
+		retried, err := database.RetryWebhookDelivery(db, b.tenant, deliveryID)
+		if err != nil {
+			return err
+		}
+		if !retried {
+			return b.SendMessage(chatID, fmt.Sprintf("投递 #%d 不存在或未失败。", deliveryID))
+		}
+		return b.SendMessage(chatID, fmt.Sprintf("投递 #%d 已重新加入队列。", deliveryID))
+	}
+
+	delivery, err := database.GetWebhookDelivery(db, b.tenant, deliveryID)
+	if err == gorm.ErrRecordNotFound {
+		return b.SendMessage(chatID, fmt.Sprintf("投递 #%d 不存在。", deliveryID))
+	} else if err != nil {
+		return fmt.Errorf("[ERROR] Failed to get webhook delivery: %v", err)
+	}
+
+	fields := []string{
+		htmlField("事件", delivery.Event),
+		htmlField("地址", delivery.URL),
+		htmlField("状态", deliveryStatusLabels[delivery.Status]),
+		htmlField("尝试次数", strconv.Itoa(delivery.Attempts)),
+		htmlField("创建时间", delivery.CreatedAt.Format("2006-01-02 15:04:05")),
+	}
+	if delivery.ResponseCode != 0 {
+		fields = append(fields, htmlField("响应状态", strconv.Itoa(delivery.ResponseCode)))
+	}
+	if delivery.NextAttemptAt != nil && delivery.Status == database.DeliveryPending {
+		fields = append(fields, htmlField("下次尝试", delivery.NextAttemptAt.Format("2006-01-02 15:04:05")))
+	}
+	if delivery.LastError != "" {
+		fields = append(fields, htmlField("错误", delivery.LastError))
+	}
+	text := fmt.Sprintf("<b>Webhook 投递 #%d</b>\n%s\n\n<pre>%s</pre>",
+		delivery.DeliveryID, strings.Join(fields, "\n"), escapeHTML(truncateText(delivery.Payload, 3000)))
+	return b.SendHTMLMessage(chatID, text)
+}
+
+func (b *Bot) showWebhookLog(db *gorm.DB, chatID int64) error {
+	var text strings.Builder
+	text.WriteString("<b>Webhook 订阅</b>")
+
+	hooks := b.webhooks.Hooks()
+	if len(hooks) == 0 {
+		text.WriteString("\n未配置 Webhook。")
+	}
+	for _, hook := range hooks {
+		events := "全部事件"
+		if len(hook.Events) > 0 {
+			events = strings.Join(hook.Events, ", ")
+		}
+		fmt.Fprintf(&text, "\n%s\n  %s", escapeHTML(hook.URL), escapeHTML(events))
+	}
+
+	deliveries, err := database.GetRecentWebhookDeliveries(db, b.tenant, webhookLogLength)
+	if err != nil {
+		return err
+	}
+	if len(deliveries) > 0 {
+		text.WriteString("\n\n<b>最近的投递</b>")
+	}
+	for _, delivery := range deliveries {
+		fmt.Fprintf(&text, "\n#%d %s %s · %s · %d 次",
+			delivery.DeliveryID, escapeHTML(delivery.Event), deliveryStatusLabels[delivery.Status],
+			delivery.CreatedAt.Format("01-02 15:04"), delivery.Attempts)
+		if delivery.ResponseCode != 0 {
+			fmt.Fprintf(&text, " · HTTP %d", delivery.ResponseCode)
+		}
+	}
+
+	text.WriteString("\n\n" + webhooksUsage())
+	return b.SendHTMLMessage(chatID, text.String())
+}
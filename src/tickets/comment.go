@@ -2,44 +2,63 @@ package tickets
 
 import (
 	"fmt"
+	"log/slog"
 	"time"
 
+	"telegram-tickets-bot/src/database"
+
 	"gorm.io/gorm"
 )
 
 type TicketComment struct {
-	CommentID int       `gorm:"primaryKey;column:comment_id"`
-	TicketID  int       `gorm:"column:ticket_id"`
-	UserID    *int      `gorm:"column:user_id"`
-	AdminID   *int      `gorm:"column:admin_id"`
-	Content   string    `gorm:"column:content"`
-	CreatedAt time.Time `gorm:"column:created_at"`
+	CommentID int   `gorm:"primaryKey;column:comment_id"`
+	TicketID  int   `gorm:"column:ticket_id"`
+	UserID    *int  `gorm:"column:user_id"`
+	AdminID   *int  `gorm:"column:admin_id"`
+	ChatID    int64 `gorm:"column:chat_id"`
+	// Telegram message the comment was written in, 0 if unknown
+	MessageID int        `gorm:"column:message_id"`
+	Content   string     `gorm:"column:content"`
+	CreatedAt time.Time  `gorm:"column:created_at"`
+	EditedAt  *time.Time `gorm:"column:edited_at"`
 }
 
 func (TicketComment) TableName() string {
 	return "ticket_comments"
 }
 
-func AddComment(db *gorm.DB, ticketID int, userID int, content string) error {
-	nextCommentID, err := getNextCommentID(db)
-	if err != nil {
-		return err
-	}
+// TicketCommentEdit keeps the content a comment had before an edit
+type TicketCommentEdit struct {
+	EditID    int       `gorm:"primaryKey;column:edit_id"`
+	CommentID int       `gorm:"column:comment_id"`
+	Content   string    `gorm:"column:content"`
+	EditedAt  time.Time `gorm:"column:edited_at"`
+}
+
+func (TicketCommentEdit) TableName() string {
+	return "ticket_comment_edits"
+}
 
+// AddComment adds a user comment written in the Telegram message messageID of chatID
+func AddComment(db *gorm.DB, ticketID int, userID int, content string, chatID int64, messageID int) (*TicketComment, error) {
 	comment := TicketComment{
-		CommentID: nextCommentID,
 		TicketID:  ticketID,
 		UserID:    &userID,
+		ChatID:    chatID,
+		MessageID: messageID,
 		Content:   content,
 		CreatedAt: time.Now(),
 	}
 
 	result := db.Create(&comment)
 	if result.Error != nil {
-		return fmt.Errorf("[ERROR] Failed to add comment: %v", result.Error)
+		return nil, fmt.Errorf("[ERROR] Failed to add comment: %v", result.Error)
 	}
 
-	return nil
+	if ticket, ok := loadEventTicket(db, ticketID); ok {
+		publish(CommentAdded{Ticket: ticket, Comment: &comment})
+	}
+	return &comment, nil
 }
 
 func GetTicketComments(db *gorm.DB, ticketID int) ([]TicketComment, error) {
@@ -51,37 +70,109 @@ func GetTicketComments(db *gorm.DB, ticketID int) ([]TicketComment, error) {
 	return comments, nil
 }
 
-func AddAdminComment(db *gorm.DB, ticketID int, adminID int, content string) error {
-	nextCommentID, err := getNextCommentID(db)
+// GetTicketCommentsPage returns one page of a ticket's comments in chronological order together with
+// the total number of comments. Page 0 holds the most recent comments, higher pages go back in time.
+func GetTicketCommentsPage(db *gorm.DB, ticketID int, page int, perPage int) ([]TicketComment, int64, error) {
+	var total int64
+	if err := db.Model(&TicketComment{}).Where("ticket_id = ?", ticketID).Count(&total).Error; err != nil {
+		return nil, 0, fmt.Errorf("[ERROR] Failed to count ticket comments: %v", err)
+	}
+
+	var comments []TicketComment
+	err := db.Where("ticket_id = ?", ticketID).
+		Order("created_at DESC").Order("comment_id DESC").
+		Offset(page * perPage).Limit(perPage).
+		Find(&comments).Error
 	if err != nil {
-		return err
+		return nil, 0, fmt.Errorf("[ERROR] Failed to get ticket comments: %v", err)
 	}
 
+	for i, j := 0, len(comments)-1; i < j; i, j = i+1, j-1 {
+		comments[i], comments[j] = comments[j], comments[i]
+	}
+	return comments, total, nil
+}
+
+// AddAdminComment adds an admin comment written in the Telegram message messageID of chatID
+func AddAdminComment(db *gorm.DB, ticketID int, adminID int, content string, chatID int64, messageID int) (*TicketComment, error) {
 	comment := TicketComment{
-		CommentID: nextCommentID,
 		TicketID:  ticketID,
 		UserID:    nil,
 		AdminID:   &adminID,
+		ChatID:    chatID,
+		MessageID: messageID,
 		Content:   content,
 		CreatedAt: time.Now(),
 	}
 
 	if err := db.Create(&comment).Error; err != nil {
-		return fmt.Errorf("[ERROR] Failed to create comment: %v", err)
+		return nil, fmt.Errorf("[ERROR] Failed to create comment: %v", err)
 	}
 	// Update the ticket's updated_at time
 	if err := db.Model(&Ticket{}).Where("ticket_id = ?", ticketID).Update("updated_at", time.Now()).Error; err != nil {
-		return fmt.Errorf("[ERROR] Failed to update ticket: %v", err)
+		return nil, fmt.Errorf("[ERROR] Failed to update ticket: %v", err)
 	}
 
-	return nil
+	if ticket, ok := loadEventTicket(db, ticketID); ok {
+		if admin, err := database.GetAdminByID(db, adminID); err != nil {
+			slog.Error("Failed to load comment author for event", "ticket_id", ticketID, "error", err)
+		} else {
+			publish(CommentAdded{Ticket: ticket, Comment: &comment, Admin: admin})
+		}
+	}
+	return &comment, nil
+}
+
+// GetCommentByMessage returns the comment of the tenant's tickets written in the given Telegram message
+func GetCommentByMessage(db *gorm.DB, tenant string, chatID int64, messageID int) (*TicketComment, error) {
+	var comment TicketComment
+	err := db.Joins("JOIN tickets ON tickets.ticket_id = ticket_comments.ticket_id").
+		Where("tickets.tenant = ? AND ticket_comments.chat_id = ? AND ticket_comments.message_id = ?", tenant, chatID, messageID).
+		First(&comment).Error
+	if err != nil {
+		return nil, err
+	}
+	return &comment, nil
 }
 
-func getNextCommentID(db *gorm.DB) (int, error) {
-	var maxCommentID int
-	err := db.Model(&TicketComment{}).Select("COALESCE(MAX(comment_id), 0)").Scan(&maxCommentID).Error
+// EditComment replaces the content of a comment, keeping the previous content in the edit history
+func EditComment(db *gorm.DB, comment *TicketComment, content string) error {
+	now := time.Now()
+	previous := comment.Content
+	err := db.Transaction(func(tx *gorm.DB) error {
+		edit := TicketCommentEdit{
+			CommentID: comment.CommentID,
+			Content:   comment.Content,
+			EditedAt:  now,
+		}
+		if err := tx.Create(&edit).Error; err != nil {
+			return fmt.Errorf("[ERROR] Failed to save comment edit: %v", err)
+		}
+
+		updates := map[string]interface{}{"content": content, "edited_at": now}
+		if err := tx.Model(&TicketComment{}).Where("comment_id = ?", comment.CommentID).Updates(updates).Error; err != nil {
+			return fmt.Errorf("[ERROR] Failed to edit comment: %v", err)
+		}
+
+		comment.Content = content
+		comment.EditedAt = &now
+		return nil
+	})
 	if err != nil {
-		return 0, fmt.Errorf("[ERROR] Failed to get max comment_id: %v", err)
+		return err
+	}
+
+	if ticket, ok := loadEventTicket(db, comment.TicketID); ok {
+		publish(CommentEdited{Ticket: ticket, Comment: comment, Previous: previous})
+	}
+	return nil
+}
+
+// GetCommentEdits returns the earlier versions of a comment, oldest first
+func GetCommentEdits(db *gorm.DB, commentID int) ([]TicketCommentEdit, error) {
+	var edits []TicketCommentEdit
+	if err := db.Where("comment_id = ?", commentID).Order("edit_id ASC").Find(&edits).Error; err != nil {
+		return nil, fmt.Errorf("[ERROR] Failed to get comment edits: %v", err)
 	}
-	return maxCommentID + 1, nil
+	return edits, nil
 }
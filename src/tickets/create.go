@@ -8,12 +8,21 @@ import (
 	"gorm.io/gorm"
 )
 
+// Channels a ticket can come in through; replies to the creator go back through the same channel
+const (
+	ChannelTelegram = "telegram"
+	ChannelEmail    = "email"
+)
+
 type Ticket struct {
 	TicketID    int       `gorm:"primaryKey;column:ticket_id"`
+	Tenant      string    `gorm:"column:tenant"`
 	Title       string    `gorm:"column:title"`
 	Description string    `gorm:"column:description"`
 	Status      string    `gorm:"column:status"`
 	Priority    string    `gorm:"column:priority"`
+	Category    string    `gorm:"column:category"`
+	Channel     string    `gorm:"column:channel"`
 	CreatedBy   int       `gorm:"column:created_by"`
 	AssignedTo  *int      `gorm:"column:assigned_to"`
 	CreatedAt   time.Time `gorm:"column:created_at"`
@@ -23,6 +32,7 @@ type Ticket struct {
 type TicketCreationData struct {
 	Title       string
 	Description string
+	Category    string
 	TicketID    int
 }
 
@@ -30,36 +40,52 @@ func (Ticket) TableName() string {
 	return "tickets"
 }
 
-func CreateTicket(db *gorm.DB, telegramID int64, title string, description string, priority string) (*Ticket, error) {
+func CreateTicket(db *gorm.DB, tenant string, telegramID int64, title string, description string, priority string, category string) (*Ticket, error) {
 	// Check if the user is registered, if not, automatically register them
-	user, err := database.CheckAndRegisterUser(db, telegramID)
+	user, err := database.CheckAndRegisterUser(db, tenant, telegramID)
 	if err != nil {
 		return nil, fmt.Errorf("[ERROR] Failed to check and register user: %v", err)
 	}
 
-	// Get the maximum ticket_id
-	var maxTicketID int
-	err = db.Model(&Ticket{}).Select("COALESCE(MAX(ticket_id), 0)").Scan(&maxTicketID).Error
+	return insertTicket(db, &Ticket{
+		Tenant:      tenant,
+		Title:       title,
+		Description: description,
+		Priority:    priority,
+		Category:    category,
+		Channel:     ChannelTelegram,
+		CreatedBy:   user.UserID,
+	})
+}
+
+// CreateEmailTicket creates a ticket for a user who wrote to support by email, registering them if needed
+func CreateEmailTicket(db *gorm.DB, tenant string, email string, title string, description string) (*Ticket, error) {
+	user, err := database.CheckAndRegisterEmailUser(db, tenant, email)
 	if err != nil {
-		return nil, fmt.Errorf("[ERROR] Failed to get maximum ticket_id: %v", err)
+		return nil, err
 	}
 
-	// Create a new ticket
-	ticket := Ticket{
-		TicketID:    maxTicketID + 1,
+	return insertTicket(db, &Ticket{
+		Tenant:      tenant,
 		Title:       title,
 		Description: description,
-		Status:      "open",
-		Priority:    priority,
+		Priority:    "normal",
+		Channel:     ChannelEmail,
 		CreatedBy:   user.UserID,
-		CreatedAt:   time.Now(),
-		UpdatedAt:   time.Now(),
-	}
+	})
+}
+
+// Save a new open ticket; the database assigns its ID, as the bots and the API create tickets concurrently
+func insertTicket(db *gorm.DB, ticket *Ticket) (*Ticket, error) {
+	ticket.Status = "open"
+	ticket.CreatedAt = time.Now()
+	ticket.UpdatedAt = time.Now()
 
-	result := db.Create(&ticket)
+	result := db.Create(ticket)
 	if result.Error != nil {
 		return nil, fmt.Errorf("[ERROR] Failed to create ticket: %v", result.Error)
 	}
 
-	return &ticket, nil
+	publish(TicketCreated{Ticket: ticket})
+	return ticket, nil
 }
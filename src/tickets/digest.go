@@ -0,0 +1,111 @@
+package tickets
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Orders open tickets by priority, then by age
+const priorityOrder = "CASE tickets.priority WHEN 'urgent' THEN 0 WHEN 'high' THEN 1 WHEN 'normal' THEN 2 ELSE 3 END, tickets.created_at ASC"
+
+// WaitingTicket is an open ticket whose last message came from the user, so it is waiting on staff
+type WaitingTicket struct {
+	Ticket
+	// Time of the user's unanswered message, or of the ticket's creation
+	WaitingSince time.Time `gorm:"column:waiting_since"`
+}
+
+// GetOpenTicketsAssignedTo returns the tenant's open tickets assigned to the admin, by priority and age
+func GetOpenTicketsAssignedTo(db *gorm.DB, tenant string, adminID int) ([]Ticket, error) {
+	var tickets []Ticket
+	err := db.Where("tickets.tenant = ? AND tickets.status <> ? AND tickets.assigned_to = ?", tenant, "closed", adminID).
+		Order(priorityOrder).Find(&tickets).Error
+	if err != nil {
+		return nil, fmt.Errorf("[ERROR] Failed to get assigned tickets: %v", err)
+	}
+	return tickets, nil
+}
+
+// GetUnassignedTickets returns the tenant's open tickets without an assigned admin, by priority and age
+func GetUnassignedTickets(db *gorm.DB, tenant string) ([]Ticket, error) {
+	var tickets []Ticket
+	err := db.Where("tickets.tenant = ? AND tickets.status <> ? AND tickets.assigned_to IS NULL", tenant, "closed").
+		Order(priorityOrder).Find(&tickets).Error
+	if err != nil {
+		return nil, fmt.Errorf("[ERROR] Failed to get unassigned tickets: %v", err)
+	}
+	return tickets, nil
+}
+
+// Time since which a ticket waits on staff: the user's last comment, or the ticket's creation
+const waitingSince = "COALESCE((SELECT MAX(u.created_at) FROM ticket_comments u WHERE u.ticket_id = tickets.ticket_id AND u.user_id IS NOT NULL), tickets.created_at)"
+
+// Query the tenant's open tickets that no admin has answered since the user's last comment, longest waiting first
+func waitingOnStaff(db *gorm.DB, tenant string) *gorm.DB {
+	lastUserComment := "(SELECT MAX(u.comment_id) FROM ticket_comments u WHERE u.ticket_id = tickets.ticket_id AND u.user_id IS NOT NULL)"
+
+	return db.Model(&Ticket{}).
+		Select("tickets.*, "+waitingSince+" AS waiting_since").
+		Where("tickets.tenant = ? AND tickets.status <> ?", tenant, "closed").
+		Where("NOT EXISTS (SELECT 1 FROM ticket_comments a WHERE a.ticket_id = tickets.ticket_id AND a.admin_id IS NOT NULL AND a.comment_id > COALESCE(" + lastUserComment + ", 0))").
+		Order("waiting_since ASC")
+}
+
+// GetTicketsWaitingOnStaff returns the tenant's open tickets that no admin has answered since the user's
+// last comment, longest waiting first
+func GetTicketsWaitingOnStaff(db *gorm.DB, tenant string) ([]WaitingTicket, error) {
+	var tickets []WaitingTicket
+	if err := waitingOnStaff(db, tenant).Scan(&tickets).Error; err != nil {
+		return nil, fmt.Errorf("[ERROR] Failed to get tickets waiting on staff: %v", err)
+	}
+	return tickets, nil
+}
+
+// GetTicketsDueForSLAWarning returns the tenant's tickets that have waited on staff since before the deadline
+// and were not warned about in their current wait yet
+func GetTicketsDueForSLAWarning(db *gorm.DB, tenant string, deadline time.Time) ([]WaitingTicket, error) {
+	var tickets []WaitingTicket
+	err := waitingOnStaff(db, tenant).
+		Where(waitingSince+" < ?", deadline).
+		Where("tickets.sla_warned_at IS NULL OR tickets.sla_warned_at < " + waitingSince).
+		Scan(&tickets).Error
+	if err != nil {
+		return nil, fmt.Errorf("[ERROR] Failed to get tickets due for an SLA warning: %v", err)
+	}
+	return tickets, nil
+}
+
+// MarkSLAWarned records that the ticket's SLA warning was sent. The ticket's update time is kept, as the
+// ticket itself did not change.
+func MarkSLAWarned(db *gorm.DB, ticketID int) error {
+	if err := db.Model(&Ticket{}).Where("ticket_id = ?", ticketID).UpdateColumn("sla_warned_at", time.Now()).Error; err != nil {
+		return fmt.Errorf("[ERROR] Failed to mark SLA warning of ticket %d: %v", ticketID, err)
+	}
+	return nil
+}
+
+// CountTicketsCreatedSince returns how many tickets of the tenant were created since the given time
+func CountTicketsCreatedSince(db *gorm.DB, tenant string, since time.Time) (int64, error) {
+	var count int64
+	if err := db.Model(&Ticket{}).Where("tenant = ? AND created_at >= ?", tenant, since).Count(&count).Error; err != nil {
+		return 0, fmt.Errorf("[ERROR] Failed to count created tickets: %v", err)
+	}
+	return count, nil
+}
+
+// CountTicketsClosedSince returns how many tickets of the tenant were closed since the given time, according
+// to their history; a ticket closed more than once counts once
+func CountTicketsClosedSince(db *gorm.DB, tenant string, since time.Time) (int64, error) {
+	var count int64
+	err := db.Model(&TicketHistory{}).
+		Joins("JOIN tickets ON tickets.ticket_id = ticket_history.ticket_id").
+		Where("tickets.tenant = ? AND ticket_history.action = ? AND ticket_history.created_at >= ?", tenant, HistoryClosed, since).
+		Distinct("ticket_history.ticket_id").
+		Count(&count).Error
+	if err != nil {
+		return 0, fmt.Errorf("[ERROR] Failed to count closed tickets: %v", err)
+	}
+	return count, nil
+}
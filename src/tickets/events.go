@@ -0,0 +1,111 @@
+package tickets
+
+import (
+	"log/slog"
+	"sync"
+
+	"telegram-tickets-bot/src/database"
+
+	"gorm.io/gorm"
+)
+
+// Event is a change to a ticket, published on the bus after it was saved
+type Event interface {
+	// EventTicket returns the ticket as it is after the change
+	EventTicket() *Ticket
+}
+
+// TicketCreated is published when a ticket is opened through any channel
+type TicketCreated struct {
+	Ticket *Ticket
+}
+
+// CommentAdded is published for every new comment; Admin is nil for comments by the ticket creator
+type CommentAdded struct {
+	Ticket  *Ticket
+	Comment *TicketComment
+	Admin   *database.AdminUser
+}
+
+// CommentEdited is published when a comment's content was replaced; Previous is its content before
+type CommentEdited struct {
+	Ticket   *Ticket
+	Comment  *TicketComment
+	Previous string
+}
+
+// TicketAssigned is published when a ticket was assigned to Admin
+type TicketAssigned struct {
+	Ticket *Ticket
+	Admin  *database.AdminUser
+}
+
+// StatusChanged is published when a ticket was closed or reopened. ActorTelegramID is the Telegram user
+// who made the change, 0 for changes made outside Telegram.
+type StatusChanged struct {
+	Ticket          *Ticket
+	ActorTelegramID int64
+}
+
+func (e TicketCreated) EventTicket() *Ticket  { return e.Ticket }
+func (e CommentAdded) EventTicket() *Ticket   { return e.Ticket }
+func (e CommentEdited) EventTicket() *Ticket  { return e.Ticket }
+func (e TicketAssigned) EventTicket() *Ticket { return e.Ticket }
+func (e StatusChanged) EventTicket() *Ticket  { return e.Ticket }
+
+// Subscriber handles the events of all tenants. Each subscriber runs in its own goroutine and receives the
+// events in the order they were published, so saving a change never waits for its subscribers.
+type Subscriber func(event Event)
+
+// Number of events a subscriber may fall behind before publishing waits for it
+const subscriberBacklog = 1024
+
+var (
+	subscribersMu sync.RWMutex
+	subscribers   []chan Event
+	// Events published but not yet handled by every subscriber
+	undelivered sync.WaitGroup
+)
+
+// Subscribe adds a subscriber that receives every event published from now on
+func Subscribe(subscriber Subscriber) {
+	events := make(chan Event, subscriberBacklog)
+	go func() {
+		for event := range events {
+			subscriber(event)
+			undelivered.Done()
+		}
+	}()
+
+	subscribersMu.Lock()
+	defer subscribersMu.Unlock()
+	subscribers = append(subscribers, events)
+}
+
+// Queue the event for every subscriber
+func publish(event Event) {
+	subscribersMu.RLock()
+	current := subscribers
+	subscribersMu.RUnlock()
+
+	undelivered.Add(len(current))
+	for _, events := range current {
+		events <- event
+	}
+}
+
+// Flush waits until every event published so far was handled, e.g. before the process exits
+func Flush() {
+	undelivered.Wait()
+}
+
+// Load the ticket a change was saved to, so the event carries its current state. A failure is only
+// logged: the change itself was saved and is not undone because it cannot be announced.
+func loadEventTicket(db *gorm.DB, ticketID int) (*Ticket, bool) {
+	var ticket Ticket
+	if err := db.Where("ticket_id = ?", ticketID).First(&ticket).Error; err != nil {
+		slog.Error("Failed to load ticket for event", "ticket_id", ticketID, "error", err)
+		return nil, false
+	}
+	return &ticket, true
+}
@@ -1,29 +1,90 @@
 package tickets
 
 import (
+	"strconv"
+	"strings"
+
 	"gorm.io/gorm"
 )
 
-func GetUserTickets(db *gorm.DB, telegramID int64) ([]Ticket, error) {
+func GetUserTickets(db *gorm.DB, tenant string, telegramID int64) ([]Ticket, error) {
 	var tickets []Ticket
 	err := db.Joins("JOIN regular_users ON tickets.created_by = regular_users.user_id").
-		Where("regular_users.telegram_id = ?", telegramID).
+		Where("tickets.tenant = ? AND regular_users.telegram_id = ?", tenant, telegramID).
 		Find(&tickets).Error
 	return tickets, err
 }
 
-func GetTicketByID(db *gorm.DB, ticketID int) (*Ticket, error) {
+// GetTicketByID returns the ticket if it belongs to the tenant
+func GetTicketByID(db *gorm.DB, tenant string, ticketID int) (*Ticket, error) {
 	var ticket Ticket
-	err := db.First(&ticket, ticketID).Error
+	err := db.Where("tenant = ?", tenant).First(&ticket, ticketID).Error
 	if err != nil {
 		return nil, err
 	}
 	return &ticket, nil
 }
 
-func GetAllTickets(db *gorm.DB) ([]Ticket, error) {
+func GetAllTickets(db *gorm.DB, tenant string) ([]Ticket, error) {
+	var tickets []Ticket
+	if err := db.Where("tenant = ?", tenant).Order("created_at desc").Find(&tickets).Error; err != nil {
+		return nil, err
+	}
+	return tickets, nil
+}
+
+// TicketFilter selects tickets for ListTickets; zero fields match every ticket
+type TicketFilter struct {
+	Status     string
+	Priority   string
+	Category   string
+	AssignedTo *int
+	// Only tickets not assigned to any admin
+	Unassigned bool
+	CreatedBy  *int
+	// Ticket ID, optionally prefixed with #, or keyword in the title or description
+	Query string
+}
+
+// SearchTickets finds the tenant's tickets by ID or by keyword in the title or description.
+// When createdBy is set only tickets created by that user are returned.
+func SearchTickets(db *gorm.DB, tenant string, query string, createdBy *int, offset int, limit int) ([]Ticket, error) {
+	return ListTickets(db, tenant, TicketFilter{Query: query, CreatedBy: createdBy}, offset, limit)
+}
+
+// ListTickets returns the tenant's tickets matching the filter, most recently updated first
+func ListTickets(db *gorm.DB, tenant string, filter TicketFilter, offset int, limit int) ([]Ticket, error) {
+	tx := db.Model(&Ticket{}).Where("tenant = ?", tenant)
+	if filter.Status != "" {
+		tx = tx.Where("status = ?", filter.Status)
+	}
+	if filter.Priority != "" {
+		tx = tx.Where("priority = ?", filter.Priority)
+	}
+	if filter.Category != "" {
+		tx = tx.Where("category = ?", filter.Category)
+	}
+	if filter.AssignedTo != nil {
+		tx = tx.Where("assigned_to = ?", *filter.AssignedTo)
+	}
+	if filter.Unassigned {
+		tx = tx.Where("assigned_to IS NULL")
+	}
+	if filter.CreatedBy != nil {
+		tx = tx.Where("created_by = ?", *filter.CreatedBy)
+	}
+
+	query := strings.TrimPrefix(strings.TrimSpace(filter.Query), "#")
+	if ticketID, err := strconv.Atoi(query); err == nil {
+		tx = tx.Where("ticket_id = ?", ticketID)
+	} else if query != "" {
+		escaper := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+		pattern := "%" + escaper.Replace(query) + "%"
+		tx = tx.Where("title LIKE ? OR description LIKE ?", pattern, pattern)
+	}
+
 	var tickets []Ticket
-	if err := db.Order("created_at desc").Find(&tickets).Error; err != nil {
+	if err := tx.Order("updated_at desc").Offset(offset).Limit(limit).Find(&tickets).Error; err != nil {
 		return nil, err
 	}
 	return tickets, nil
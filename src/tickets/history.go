@@ -0,0 +1,112 @@
+package tickets
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"telegram-tickets-bot/src/database"
+
+	"gorm.io/gorm"
+)
+
+// Actions recorded in a ticket's history
+const (
+	HistoryCreated       = "created"
+	HistoryCommented     = "commented"
+	HistoryCommentEdited = "comment_edited"
+	HistoryAssigned      = "assigned"
+	HistoryClosed        = "closed"
+	HistoryReopened      = "reopened"
+)
+
+// TicketHistory is one entry of a ticket's audit trail. UserID and AdminID name who made the change,
+// both are nil when it is unknown, e.g. for changes made through the REST API. For assignments AdminID
+// is the assignee and Details their name at the time.
+type TicketHistory struct {
+	HistoryID int       `gorm:"primaryKey;column:history_id"`
+	TicketID  int       `gorm:"column:ticket_id"`
+	UserID    *int      `gorm:"column:user_id"`
+	AdminID   *int      `gorm:"column:admin_id"`
+	Action    string    `gorm:"column:action"`
+	Details   string    `gorm:"column:details"`
+	CreatedAt time.Time `gorm:"column:created_at"`
+}
+
+func (TicketHistory) TableName() string {
+	return "ticket_history"
+}
+
+func init() {
+	Subscribe(recordHistory)
+}
+
+// GetTicketHistory returns the history of a ticket, oldest first
+func GetTicketHistory(db *gorm.DB, ticketID int) ([]TicketHistory, error) {
+	var history []TicketHistory
+	if err := db.Where("ticket_id = ?", ticketID).Order("history_id ASC").Find(&history).Error; err != nil {
+		return nil, fmt.Errorf("[ERROR] Failed to get ticket history: %v", err)
+	}
+	return history, nil
+}
+
+// Describe an event as a history entry
+func historyEntry(db *gorm.DB, event Event) TicketHistory {
+	ticket := event.EventTicket()
+	entry := TicketHistory{TicketID: ticket.TicketID, CreatedAt: time.Now()}
+
+	switch e := event.(type) {
+	case TicketCreated:
+		entry.Action = HistoryCreated
+		entry.UserID = &ticket.CreatedBy
+	case CommentAdded:
+		entry.Action = HistoryCommented
+		entry.UserID = e.Comment.UserID
+		entry.AdminID = e.Comment.AdminID
+		entry.Details = fmt.Sprintf("comment %d", e.Comment.CommentID)
+	case CommentEdited:
+		entry.Action = HistoryCommentEdited
+		entry.UserID = e.Comment.UserID
+		entry.AdminID = e.Comment.AdminID
+		entry.Details = fmt.Sprintf("comment %d", e.Comment.CommentID)
+	case TicketAssigned:
+		entry.Action = HistoryAssigned
+		entry.AdminID = &e.Admin.AdminID
+		entry.Details = e.Admin.FullName
+	case StatusChanged:
+		entry.Action = HistoryReopened
+		if ticket.Status == "closed" {
+			entry.Action = HistoryClosed
+		}
+		entry.UserID, entry.AdminID = historyActor(db, ticket.Tenant, e.ActorTelegramID)
+	}
+	return entry
+}
+
+// Return the user or admin of the tenant with the Telegram ID, nil for both if there is none
+func historyActor(db *gorm.DB, tenant string, telegramID int64) (*int, *int) {
+	if telegramID == 0 {
+		return nil, nil
+	}
+	if adminID, err := database.GetAdminIDByTelegramID(db, tenant, telegramID); err == nil {
+		return nil, &adminID
+	}
+	if userID, err := database.GetUserIDByTelegramID(db, tenant, telegramID); err == nil {
+		return &userID, nil
+	}
+	return nil, nil
+}
+
+func recordHistory(event Event) {
+	db, err := database.InitializeDB()
+	if err != nil {
+		slog.Error("Failed to get database connection", "error", err)
+		return
+	}
+
+	entry := historyEntry(db, event)
+	if err := db.Create(&entry).Error; err != nil {
+		slog.Error("Failed to record ticket history", "tenant", event.EventTicket().Tenant,
+			"ticket_id", entry.TicketID, "action", entry.Action, "error", err)
+	}
+}
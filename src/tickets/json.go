@@ -0,0 +1,61 @@
+package tickets
+
+import (
+	"time"
+)
+
+// TicketJSON is a ticket as represented in API responses and webhook payloads
+type TicketJSON struct {
+	ID          int       `json:"id"`
+	Title       string    `json:"title"`
+	Description string    `json:"description"`
+	Status      string    `json:"status"`
+	Priority    string    `json:"priority"`
+	Category    string    `json:"category"`
+	Channel     string    `json:"channel"`
+	CreatedBy   int       `json:"created_by"`
+	AssignedTo  *int      `json:"assigned_to"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	// Only set when a single ticket is requested
+	Comments []CommentJSON `json:"comments,omitempty"`
+}
+
+// CommentJSON is a ticket comment as represented in API responses and webhook payloads
+type CommentJSON struct {
+	ID        int        `json:"id"`
+	TicketID  int        `json:"ticket_id"`
+	UserID    *int       `json:"user_id"`
+	AdminID   *int       `json:"admin_id"`
+	Content   string     `json:"content"`
+	CreatedAt time.Time  `json:"created_at"`
+	EditedAt  *time.Time `json:"edited_at"`
+}
+
+func NewTicketJSON(ticket *Ticket) TicketJSON {
+	return TicketJSON{
+		ID:          ticket.TicketID,
+		Title:       ticket.Title,
+		Description: ticket.Description,
+		Status:      ticket.Status,
+		Priority:    ticket.Priority,
+		Category:    ticket.Category,
+		Channel:     ticket.Channel,
+		CreatedBy:   ticket.CreatedBy,
+		AssignedTo:  ticket.AssignedTo,
+		CreatedAt:   ticket.CreatedAt,
+		UpdatedAt:   ticket.UpdatedAt,
+	}
+}
+
+func NewCommentJSON(comment *TicketComment) CommentJSON {
+	return CommentJSON{
+		ID:        comment.CommentID,
+		TicketID:  comment.TicketID,
+		UserID:    comment.UserID,
+		AdminID:   comment.AdminID,
+		Content:   comment.Content,
+		CreatedAt: comment.CreatedAt,
+		EditedAt:  comment.EditedAt,
+	}
+}
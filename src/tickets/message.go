@@ -0,0 +1,97 @@
+package tickets
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Kinds of messages linked to a ticket
+const (
+	TicketMessageNotification = "notification"
+	TicketMessageCard         = "card"
+)
+
+// TicketMessage links a message sent by the bot (a notification or a ticket card) to the ticket it belongs to.
+// Message IDs are only unique per bot and chat, so the tenant is part of the key.
+type TicketMessage struct {
+	Tenant    string `gorm:"primaryKey;column:tenant"`
+	ChatID    int64  `gorm:"primaryKey;column:chat_id"`
+	MessageID int    `gorm:"primaryKey;column:message_id"`
+	TicketID  int    `gorm:"column:ticket_id"`
+	Kind      string `gorm:"column:kind"`
+	ViewerID  int64  `gorm:"column:viewer_id"`
+	// Comment page shown by a card
+	Page      int       `gorm:"column:page"`
+	CreatedAt time.Time `gorm:"column:created_at"`
+}
+
+func (TicketMessage) TableName() string {
+	return "ticket_messages"
+}
+
+func SaveTicketMessage(db *gorm.DB, tenant string, chatID int64, messageID int, ticketID int) error {
+	message := TicketMessage{
+		Tenant:    tenant,
+		ChatID:    chatID,
+		MessageID: messageID,
+		TicketID:  ticketID,
+		Kind:      TicketMessageNotification,
+		CreatedAt: time.Now(),
+	}
+
+	if err := db.Create(&message).Error; err != nil {
+		return fmt.Errorf("[ERROR] Failed to save ticket message: %v", err)
+	}
+	return nil
+}
+
+// SaveTicketCard records that a message currently displays the page of the ticket view as seen by viewerID
+func SaveTicketCard(db *gorm.DB, tenant string, chatID int64, messageID int, ticketID int, viewerID int64, page int) error {
+	card := TicketMessage{
+		Tenant:    tenant,
+		ChatID:    chatID,
+		MessageID: messageID,
+		TicketID:  ticketID,
+		Kind:      TicketMessageCard,
+		ViewerID:  viewerID,
+		Page:      page,
+		CreatedAt: time.Now(),
+	}
+
+	if err := db.Save(&card).Error; err != nil {
+		return fmt.Errorf("[ERROR] Failed to save ticket card: %v", err)
+	}
+	return nil
+}
+
+// GetTicketCards returns the most recent messages displaying the ticket view
+func GetTicketCards(db *gorm.DB, ticketID int, limit int) ([]TicketMessage, error) {
+	var cards []TicketMessage
+	err := db.Where("ticket_id = ? AND kind = ?", ticketID, TicketMessageCard).
+		Order("created_at DESC").Limit(limit).
+		Find(&cards).Error
+	if err != nil {
+		return nil, fmt.Errorf("[ERROR] Failed to get ticket cards: %v", err)
+	}
+	return cards, nil
+}
+
+func DeleteTicketMessage(db *gorm.DB, tenant string, chatID int64, messageID int) error {
+	if err := db.Where("tenant = ? AND chat_id = ? AND message_id = ?", tenant, chatID, messageID).Delete(&TicketMessage{}).Error; err != nil {
+		return fmt.Errorf("[ERROR] Failed to delete ticket message: %v", err)
+	}
+	return nil
+}
+
+// GetTicketIDByMessage returns the ticket of a stored notification; cards and lists are not replied to
+func GetTicketIDByMessage(db *gorm.DB, tenant string, chatID int64, messageID int) (int, error) {
+	var message TicketMessage
+	err := db.Where("tenant = ? AND chat_id = ? AND message_id = ? AND kind = ?", tenant, chatID, messageID, TicketMessageNotification).
+		First(&message).Error
+	if err != nil {
+		return 0, err
+	}
+	return message.TicketID, nil
+}
@@ -0,0 +1,86 @@
+package tickets
+
+import (
+	"log/slog"
+
+	"telegram-tickets-bot/src/database"
+	"telegram-tickets-bot/src/metrics"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"gorm.io/gorm"
+)
+
+var (
+	openTickets = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ticketsbot_open_tickets",
+		Help: "Tickets that are not closed, by status and priority.",
+	}, []string{"tenant", "status", "priority"})
+	ticketEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ticketsbot_ticket_events_total",
+		Help: "Ticket events published, by event.",
+	}, []string{"tenant", "event"})
+)
+
+func init() {
+	metrics.OnCollect(collectOpenTickets)
+	Subscribe(countEvent)
+}
+
+func countEvent(event Event) {
+	var name string
+	switch e := event.(type) {
+	case TicketCreated:
+		name = "ticket_created"
+	case CommentAdded:
+		name = "comment_added"
+	case CommentEdited:
+		name = "comment_edited"
+	case TicketAssigned:
+		name = "ticket_assigned"
+	case StatusChanged:
+		name = "ticket_reopened"
+		if e.Ticket.Status == "closed" {
+			name = "ticket_closed"
+		}
+	}
+	ticketEvents.WithLabelValues(event.EventTicket().Tenant, name).Inc()
+}
+
+// OpenTicketCount is the number of tickets of a tenant with one status and priority
+type OpenTicketCount struct {
+	Tenant   string
+	Status   string
+	Priority string
+	Count    int
+}
+
+// CountOpenTickets counts the tickets of all tenants that are not closed
+func CountOpenTickets(db *gorm.DB) ([]OpenTicketCount, error) {
+	var counts []OpenTicketCount
+	err := db.Model(&Ticket{}).
+		Select("tenant, status, priority, COUNT(*) AS count").
+		Where("status <> ?", "closed").
+		Group("tenant, status, priority").
+		Scan(&counts).Error
+	return counts, err
+}
+
+func collectOpenTickets() {
+	db, err := database.InitializeDB()
+	if err != nil {
+		slog.Error("Failed to get database connection", "error", err)
+		return
+	}
+
+	counts, err := CountOpenTickets(db)
+	if err != nil {
+		slog.Error("Failed to count open tickets", "error", err)
+		return
+	}
+
+	openTickets.Reset()
+	for _, count := range counts {
+		openTickets.WithLabelValues(count.Tenant, count.Status, count.Priority).Set(float64(count.Count))
+	}
+}
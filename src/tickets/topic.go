@@ -0,0 +1,49 @@
+package tickets
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type TicketTopic struct {
+	TicketID  int       `gorm:"primaryKey;column:ticket_id"`
+	ChatID    int64     `gorm:"column:chat_id"`
+	ThreadID  int       `gorm:"column:thread_id"`
+	CreatedAt time.Time `gorm:"column:created_at"`
+}
+
+func (TicketTopic) TableName() string {
+	return "ticket_topics"
+}
+
+func SaveTicketTopic(db *gorm.DB, ticketID int, chatID int64, threadID int) error {
+	topic := TicketTopic{
+		TicketID:  ticketID,
+		ChatID:    chatID,
+		ThreadID:  threadID,
+		CreatedAt: time.Now(),
+	}
+
+	if err := db.Create(&topic).Error; err != nil {
+		return fmt.Errorf("[ERROR] Failed to save ticket topic: %v", err)
+	}
+	return nil
+}
+
+func GetTicketTopic(db *gorm.DB, ticketID int) (*TicketTopic, error) {
+	var topic TicketTopic
+	if err := db.Where("ticket_id = ?", ticketID).First(&topic).Error; err != nil {
+		return nil, err
+	}
+	return &topic, nil
+}
+
+func GetTicketIDByTopic(db *gorm.DB, chatID int64, threadID int) (int, error) {
+	var topic TicketTopic
+	if err := db.Where("chat_id = ? AND thread_id = ?", chatID, threadID).First(&topic).Error; err != nil {
+		return 0, err
+	}
+	return topic.TicketID, nil
+}
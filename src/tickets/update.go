@@ -0,0 +1,53 @@
+package tickets
+
+import (
+	"fmt"
+	"log/slog"
+
+	"telegram-tickets-bot/src/database"
+
+	"gorm.io/gorm"
+)
+
+// SetTicketStatus changes the status of the ticket, e.g. to reopen a closed ticket. Setting the status the
+// ticket already has changes nothing and publishes no event.
+// actorTelegramID is the Telegram user making the change, 0 for changes made outside Telegram.
+func SetTicketStatus(db *gorm.DB, ticketID int, status string, actorTelegramID int64) error {
+	// MySQL counts only changed rows as affected, so existence is checked separately
+	var count int64
+	if err := db.Model(&Ticket{}).Where("ticket_id = ?", ticketID).Count(&count).Error; err != nil {
+		return fmt.Errorf("[ERROR] Failed to get ticket: %v", err)
+	}
+	if count == 0 {
+		return fmt.Errorf("[WARNING] Ticket not found")
+	}
+
+	result := db.Model(&Ticket{}).Where("ticket_id = ? AND status <> ?", ticketID, status).Update("status", status)
+	if result.Error != nil {
+		return fmt.Errorf("[ERROR] Failed to update ticket status: %v", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		// Already in that status, e.g. closed twice at the same time
+		return nil
+	}
+
+	if ticket, ok := loadEventTicket(db, ticketID); ok {
+		publish(StatusChanged{Ticket: ticket, ActorTelegramID: actorTelegramID})
+	}
+	return nil
+}
+
+func AssignTicket(db *gorm.DB, ticketID int, adminID int) error {
+	if err := db.Model(&Ticket{}).Where("ticket_id = ?", ticketID).Update("assigned_to", adminID).Error; err != nil {
+		return fmt.Errorf("[ERROR] Failed to assign ticket: %v", err)
+	}
+
+	if ticket, ok := loadEventTicket(db, ticketID); ok {
+		if admin, err := database.GetAdminByID(db, adminID); err != nil {
+			slog.Error("Failed to load assignee for event", "ticket_id", ticketID, "error", err)
+		} else {
+			publish(TicketAssigned{Ticket: ticket, Admin: admin})
+		}
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package web
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	sessionCookie = "ttb_session"
+	// How long a Telegram login stays valid for signing in
+	maxLoginAge = 24 * time.Hour
+)
+
+// Verify the data the Telegram Login Widget passed to the auth URL and return the Telegram ID it signs.
+// See https://core.telegram.org/widgets/login#checking-authorization
+func verifyLogin(values url.Values, botToken string, now time.Time) (int64, error) {
+	hash := values.Get("hash")
+	if hash == "" {
+		return 0, fmt.Errorf("missing hash")
+	}
+
+	var fields []string
+	for key := range values {
+		if key != "hash" {
+			fields = append(fields, key+"="+values.Get(key))
+		}
+	}
+	sort.Strings(fields)
+
+	secret := sha256.Sum256([]byte(botToken))
+	mac := hmac.New(sha256.New, secret[:])
+	mac.Write([]byte(strings.Join(fields, "\n")))
+	if !hmac.Equal([]byte(hex.EncodeToString(mac.Sum(nil))), []byte(hash)) {
+		return 0, fmt.Errorf("invalid hash")
+	}
+
+	authDate, err := strconv.ParseInt(values.Get("auth_date"), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid auth_date")
+	}
+	if now.Sub(time.Unix(authDate, 0)) > maxLoginAge {
+		return 0, fmt.Errorf("login expired")
+	}
+
+	telegramID, err := strconv.ParseInt(values.Get("id"), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid id")
+	}
+	return telegramID, nil
+}
+
+// Sessions are kept in a cookie signed with a key derived from the bot token, so they need no storage
+func (s *Server) sign(payload string) string {
+	key := sha256.Sum256([]byte("dashboard-session:" + s.cfg.Telegram.BotToken))
+	mac := hmac.New(sha256.New, key[:])
+	mac.Write([]byte(payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Sign the admin in by setting the session cookie
+func (s *Server) startSession(w http.ResponseWriter, r *http.Request, telegramID int64) {
+	expires := time.Now().Add(time.Duration(s.cfg.Web.SessionHours) * time.Hour)
+	payload := fmt.Sprintf("%d.%d", telegramID, expires.Unix())
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    payload + "." + s.sign(payload),
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   isHTTPS(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func (s *Server) endSession(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   isHTTPS(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// Return the Telegram ID of the signed in admin and the session cookie, or 0 if there is no valid session
+func (s *Server) session(r *http.Request) (int64, string) {
+	cookie, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return 0, ""
+	}
+
+	payload, signature, ok := cutLast(cookie.Value, ".")
+	if !ok || !hmac.Equal([]byte(signature), []byte(s.sign(payload))) {
+		return 0, ""
+	}
+	rawID, rawExpires, ok := strings.Cut(payload, ".")
+	if !ok {
+		return 0, ""
+	}
+	expires, err := strconv.ParseInt(rawExpires, 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return 0, ""
+	}
+	telegramID, err := strconv.ParseInt(rawID, 10, 64)
+	if err != nil {
+		return 0, ""
+	}
+	return telegramID, cookie.Value
+}
+
+// Token that forms must send back, tying them to the session against cross-site request forgery
+func (s *Server) csrfToken(sessionValue string) string {
+	return s.sign("csrf:" + sessionValue)
+}
+
+func cutLast(value string, separator string) (string, string, bool) {
+	i := strings.LastIndex(value, separator)
+	if i < 0 {
+		return "", "", false
+	}
+	return value[:i], value[i+len(separator):], true
+}
+
+func isHTTPS(r *http.Request) bool {
+	return r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https"
+}
@@ -0,0 +1,213 @@
+package web
+
+import (
+	"crypto/hmac"
+	"embed"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"telegram-tickets-bot/src/config"
+	"telegram-tickets-bot/src/database"
+
+	"gorm.io/gorm"
+)
+
+//go:embed templates/*.html
+var templateFiles embed.FS
+
+// Pages rendered inside templates/layout.html
+var pages = []string{"login", "queue", "ticket", "error"}
+
+// Server is the web dashboard of one tenant, where its admins work the ticket queue.
+// Admins sign in with the Telegram Login Widget of the tenant's bot.
+type Server struct {
+	cfg         *config.Tenant
+	botUsername string
+	templates   map[string]*template.Template
+	mux         *http.ServeMux
+}
+
+// Signed in admin of a request
+type session struct {
+	Admin *database.AdminUser
+	// Token that the admin's forms carry
+	CSRF string
+}
+
+// Handler of a request made by a signed in admin
+type sessionHandler func(w http.ResponseWriter, r *http.Request, sess *session)
+
+// Data passed to the layout; Data is the page's own data
+type pageData struct {
+	Title   string
+	Session *session
+	Data    interface{}
+}
+
+func NewServer(cfg *config.Tenant, botUsername string) (*Server, error) {
+	s := &Server{
+		cfg:         cfg,
+		botUsername: botUsername,
+		templates:   make(map[string]*template.Template),
+		mux:         http.NewServeMux(),
+	}
+
+	funcs := template.FuncMap{
+		"formatTime": func(t time.Time) string {
+			return t.Format("2006-01-02 15:04")
+		},
+		"categoryName": s.categoryName,
+	}
+	for _, page := range pages {
+		parsed, err := template.New("layout.html").Funcs(funcs).
+			ParseFS(templateFiles, "templates/layout.html", "templates/"+page+".html")
+		if err != nil {
+			return nil, fmt.Errorf("[ERROR] Failed to parse dashboard template %s: %v", page, err)
+		}
+		s.templates[page] = parsed
+	}
+
+	s.mux.HandleFunc("GET /login", s.handleLogin)
+	s.mux.HandleFunc("GET /auth", s.handleAuth)
+	s.mux.HandleFunc("POST /logout", s.authenticate(s.handleLogout))
+	s.mux.HandleFunc("GET /{$}", s.authenticate(s.handleQueue))
+	s.mux.HandleFunc("GET /tickets/{id}", s.authenticate(s.handleTicket))
+	s.mux.HandleFunc("POST /tickets/{id}/reply", s.authenticate(s.handleReply))
+	s.mux.HandleFunc("POST /tickets/{id}/assign", s.authenticate(s.handleAssign))
+	s.mux.HandleFunc("POST /tickets/{id}/status", s.authenticate(s.handleSetStatus))
+	return s, nil
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// ListenAndServe serves the dashboard on the tenant's listen address until the server fails
+func (s *Server) ListenAndServe() error {
+	server := &http.Server{
+		Addr:              s.cfg.Web.Listen,
+		Handler:           s,
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       30 * time.Second,
+		WriteTimeout:      30 * time.Second,
+	}
+	slog.Info("Admin dashboard listening", "tenant", s.cfg.Key, "addr", s.cfg.Web.Listen)
+	return server.ListenAndServe()
+}
+
+// Require a signed in admin of the tenant, and a valid CSRF token for form submissions
+func (s *Server) authenticate(next sessionHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		telegramID, cookie := s.session(r)
+		if telegramID == 0 {
+			http.Redirect(w, r, "/login", http.StatusSeeOther)
+			return
+		}
+
+		db, err := database.InitializeDB()
+		if err != nil {
+			s.renderServerError(w, fmt.Errorf("[ERROR] Failed to get database connection: %v", err))
+			return
+		}
+
+		// Admins removed from admin_users lose access at once, whatever their session says
+		adminID, err := database.GetAdminIDByTelegramID(db, s.cfg.Key, telegramID)
+		if err == gorm.ErrRecordNotFound {
+			s.endSession(w, r)
+			http.Redirect(w, r, "/login", http.StatusSeeOther)
+			return
+		} else if err != nil {
+			s.renderServerError(w, fmt.Errorf("[ERROR] Failed to check admin status: %v", err))
+			return
+		}
+		admin, err := database.GetAdminByID(db, adminID)
+		if err != nil {
+			s.renderServerError(w, err)
+			return
+		}
+
+		sess := &session{Admin: admin, CSRF: s.csrfToken(cookie)}
+		if r.Method == http.MethodPost && !hmac.Equal([]byte(r.PostFormValue("csrf")), []byte(sess.CSRF)) {
+			s.renderError(w, http.StatusForbidden, "表单已过期，请返回后重试。")
+			return
+		}
+		next(w, r, sess)
+	}
+}
+
+func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
+	scheme := "http"
+	if isHTTPS(r) {
+		scheme = "https"
+	}
+	s.render(w, http.StatusOK, "login", "登录", nil, map[string]string{
+		"BotUsername": s.botUsername,
+		"AuthURL":     fmt.Sprintf("%s://%s/auth", scheme, r.Host),
+	})
+}
+
+// Sign in an admin redirected here by the Telegram Login Widget
+func (s *Server) handleAuth(w http.ResponseWriter, r *http.Request) {
+	telegramID, err := verifyLogin(r.URL.Query(), s.cfg.Telegram.BotToken, time.Now())
+	if err != nil {
+		slog.Warn("Rejected dashboard login", "tenant", s.cfg.Key, "error", err)
+		s.renderError(w, http.StatusUnauthorized, "Telegram 登录验证失败，请重新登录。")
+		return
+	}
+
+	isAdmin, err := database.IsUserAdmin(s.cfg.Key, telegramID)
+	if err != nil {
+		s.renderServerError(w, fmt.Errorf("[ERROR] Failed to check admin status: %v", err))
+		return
+	}
+	if !isAdmin {
+		slog.Warn("Dashboard login by non-admin", "tenant", s.cfg.Key, "chat_id", telegramID)
+		s.renderError(w, http.StatusForbidden, "只有管理员可以使用工单后台。")
+		return
+	}
+
+	s.startSession(w, r, telegramID)
+	slog.Info("Admin signed in to the dashboard", "tenant", s.cfg.Key, "chat_id", telegramID)
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+func (s *Server) handleLogout(w http.ResponseWriter, r *http.Request, sess *session) {
+	s.endSession(w, r)
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
+
+func (s *Server) render(w http.ResponseWriter, status int, page string, title string, sess *session, data interface{}) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := s.templates[page].Execute(w, pageData{Title: title, Session: sess, Data: data}); err != nil {
+		slog.Error("Failed to render dashboard page", "page", page, "error", err)
+	}
+}
+
+func (s *Server) renderError(w http.ResponseWriter, status int, message string) {
+	s.render(w, status, "error", "错误", nil, message)
+}
+
+// Log the cause of an internal error without showing it to the admin
+func (s *Server) renderServerError(w http.ResponseWriter, err error) {
+	slog.Error("Dashboard request failed", "tenant", s.cfg.Key, "error", err)
+	s.renderError(w, http.StatusInternalServerError, "服务器内部错误，请稍后重试。")
+}
+
+// Return the tenant's categories in effect, which may change with a configuration reload
+func (s *Server) categories() []config.Category {
+	return config.CurrentTenant(s.cfg.Key).Categories
+}
+
+// Return the display name of a ticket category
+func (s *Server) categoryName(key string) string {
+	for _, category := range s.categories() {
+		if category.Key == key {
+			return category.Name
+		}
+	}
+	return key
+}
@@ -0,0 +1,388 @@
+package web
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"telegram-tickets-bot/src/config"
+	"telegram-tickets-bot/src/database"
+	"telegram-tickets-bot/src/tickets"
+
+	"gorm.io/gorm"
+)
+
+// Tickets shown per page of the queue
+const queuePageSize = 50
+
+var (
+	ticketStatuses   = []string{"open", "closed"}
+	ticketPriorities = []string{"low", "normal", "high", "urgent"}
+)
+
+// Filters of the queue as given in the query string
+type queueFilter struct {
+	// Ticket status, "all" for any; open by default
+	Status   string
+	Priority string
+	Category string
+	// "me", "none" or an admin ID; empty for any
+	Assigned string
+	Query    string
+	Page     int
+}
+
+type queueRow struct {
+	Ticket   tickets.Ticket
+	Assignee string
+}
+
+type queuePage struct {
+	Filter     queueFilter
+	Rows       []queueRow
+	Statuses   []string
+	Priorities []string
+	Categories []config.Category
+	Admins     []database.AdminUser
+	PrevURL    string
+	NextURL    string
+}
+
+// Query string of the filter showing the given page
+func (f queueFilter) url(page int) string {
+	values := url.Values{}
+	for key, value := range map[string]string{
+		"status": f.Status, "priority": f.Priority, "category": f.Category, "assigned": f.Assigned, "q": f.Query,
+	} {
+		if value != "" {
+			values.Set(key, value)
+		}
+	}
+	if page > 0 {
+		values.Set("page", strconv.Itoa(page))
+	}
+	return "/?" + values.Encode()
+}
+
+func (s *Server) handleQueue(w http.ResponseWriter, r *http.Request, sess *session) {
+	query := r.URL.Query()
+	filter := queueFilter{
+		Status:   query.Get("status"),
+		Priority: query.Get("priority"),
+		Category: query.Get("category"),
+		Assigned: query.Get("assigned"),
+		Query:    strings.TrimSpace(query.Get("q")),
+	}
+	if filter.Status == "" {
+		filter.Status = "open"
+	}
+	filter.Page, _ = strconv.Atoi(query.Get("page"))
+	if filter.Page < 0 {
+		filter.Page = 0
+	}
+
+	ticketFilter := tickets.TicketFilter{
+		Priority: filter.Priority,
+		Category: filter.Category,
+		Query:    filter.Query,
+	}
+	if filter.Status != "all" {
+		ticketFilter.Status = filter.Status
+	}
+	switch filter.Assigned {
+	case "":
+	case "me":
+		ticketFilter.AssignedTo = &sess.Admin.AdminID
+	case "none":
+		ticketFilter.Unassigned = true
+	default:
+		adminID, err := strconv.Atoi(filter.Assigned)
+		if err != nil {
+			s.renderError(w, http.StatusBadRequest, "无效的处理人。")
+			return
+		}
+		ticketFilter.AssignedTo = &adminID
+	}
+
+	db, err := database.InitializeDB()
+	if err != nil {
+		s.renderServerError(w, fmt.Errorf("[ERROR] Failed to get database connection: %v", err))
+		return
+	}
+
+	// Fetch one ticket more than shown to learn whether there is a next page
+	found, err := tickets.ListTickets(db, s.cfg.Key, ticketFilter, filter.Page*queuePageSize, queuePageSize+1)
+	if err != nil {
+		s.renderServerError(w, fmt.Errorf("[ERROR] Failed to list tickets: %v", err))
+		return
+	}
+	admins, err := database.GetAdmins(db, s.cfg.Key)
+	if err != nil {
+		s.renderServerError(w, err)
+		return
+	}
+
+	page := queuePage{
+		Filter:     filter,
+		Statuses:   ticketStatuses,
+		Priorities: ticketPriorities,
+		Categories: s.categories(),
+		Admins:     admins,
+	}
+	if filter.Page > 0 {
+		page.PrevURL = filter.url(filter.Page - 1)
+	}
+	if len(found) > queuePageSize {
+		found = found[:queuePageSize]
+		page.NextURL = filter.url(filter.Page + 1)
+	}
+	for _, ticket := range found {
+		page.Rows = append(page.Rows, queueRow{Ticket: ticket, Assignee: assigneeName(admins, ticket.AssignedTo)})
+	}
+
+	s.render(w, http.StatusOK, "queue", "工单队列", sess, page)
+}
+
+func assigneeName(admins []database.AdminUser, adminID *int) string {
+	if adminID == nil {
+		return ""
+	}
+	for _, admin := range admins {
+		if admin.AdminID == *adminID {
+			return admin.FullName
+		}
+	}
+	return fmt.Sprintf("#%d", *adminID)
+}
+
+type commentView struct {
+	Author    string
+	Staff     bool
+	Content   string
+	CreatedAt time.Time
+	Edited    bool
+}
+
+type historyView struct {
+	CreatedAt time.Time
+	Actor     string
+	Action    string
+}
+
+// Descriptions of the actions in a ticket's history
+var historyActions = map[string]string{
+	tickets.HistoryCreated:       "创建了工单",
+	tickets.HistoryCommented:     "添加了回复",
+	tickets.HistoryCommentEdited: "编辑了回复",
+	tickets.HistoryAssigned:      "分配了工单",
+	tickets.HistoryClosed:        "关闭了工单",
+	tickets.HistoryReopened:      "重新打开了工单",
+}
+
+type ticketPage struct {
+	Ticket   *tickets.Ticket
+	Creator  string
+	Assignee string
+	// 0 if the ticket is unassigned
+	AssigneeID int
+	Comments   []commentView
+	History    []historyView
+	Admins     []database.AdminUser
+}
+
+// Load the ticket named in the path if it belongs to the tenant, rendering the error page otherwise
+func (s *Server) loadTicket(w http.ResponseWriter, r *http.Request, db *gorm.DB) (*tickets.Ticket, bool) {
+	ticketID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		s.renderError(w, http.StatusNotFound, "工单不存在。")
+		return nil, false
+	}
+
+	ticket, err := tickets.GetTicketByID(db, s.cfg.Key, ticketID)
+	if err == gorm.ErrRecordNotFound {
+		s.renderError(w, http.StatusNotFound, "工单不存在。")
+		return nil, false
+	} else if err != nil {
+		s.renderServerError(w, fmt.Errorf("[ERROR] Failed to get ticket: %v", err))
+		return nil, false
+	}
+	return ticket, true
+}
+
+func (s *Server) handleTicket(w http.ResponseWriter, r *http.Request, sess *session) {
+	db, err := database.InitializeDB()
+	if err != nil {
+		s.renderServerError(w, fmt.Errorf("[ERROR] Failed to get database connection: %v", err))
+		return
+	}
+
+	ticket, ok := s.loadTicket(w, r, db)
+	if !ok {
+		return
+	}
+	admins, err := database.GetAdmins(db, s.cfg.Key)
+	if err != nil {
+		s.renderServerError(w, err)
+		return
+	}
+	comments, err := tickets.GetTicketComments(db, ticket.TicketID)
+	if err != nil {
+		s.renderServerError(w, fmt.Errorf("[ERROR] Failed to fetch ticket comments: %v", err))
+		return
+	}
+	history, err := tickets.GetTicketHistory(db, ticket.TicketID)
+	if err != nil {
+		s.renderServerError(w, err)
+		return
+	}
+
+	creator, err := database.GetRegularUserByID(db, ticket.CreatedBy)
+	if err != nil {
+		s.renderServerError(w, fmt.Errorf("[ERROR] Failed to get ticket creator: %v", err))
+		return
+	}
+	creatorName := fmt.Sprintf("Telegram 用户 %d", creator.TelegramID)
+	if creator.Email != nil {
+		creatorName = *creator.Email
+	}
+
+	page := ticketPage{
+		Ticket:   ticket,
+		Creator:  creatorName,
+		Assignee: assigneeName(admins, ticket.AssignedTo),
+		Admins:   admins,
+	}
+	if ticket.AssignedTo != nil {
+		page.AssigneeID = *ticket.AssignedTo
+	}
+	for _, comment := range comments {
+		view := commentView{Author: creatorName, Content: comment.Content, CreatedAt: comment.CreatedAt, Edited: comment.EditedAt != nil}
+		if comment.AdminID != nil {
+			view.Author = assigneeName(admins, comment.AdminID)
+			view.Staff = true
+		}
+		page.Comments = append(page.Comments, view)
+	}
+	for _, entry := range history {
+		view := historyView{CreatedAt: entry.CreatedAt, Actor: "系统", Action: historyActions[entry.Action]}
+		switch {
+		case entry.Action == tickets.HistoryAssigned:
+			// The admin of an assignment is the assignee
+			view.Actor = ""
+			view.Action = "工单已分配给 " + assigneeName(admins, entry.AdminID)
+		case entry.AdminID != nil:
+			view.Actor = assigneeName(admins, entry.AdminID)
+		case entry.UserID != nil:
+			view.Actor = creatorName
+		}
+		page.History = append(page.History, view)
+	}
+
+	s.render(w, http.StatusOK, "ticket", fmt.Sprintf("工单 #%d", ticket.TicketID), sess, page)
+}
+
+func ticketURL(ticketID int) string {
+	return fmt.Sprintf("/tickets/%d", ticketID)
+}
+
+// Send a staff reply to the ticket creator through the bot
+func (s *Server) handleReply(w http.ResponseWriter, r *http.Request, sess *session) {
+	content := strings.TrimSpace(r.PostFormValue("content"))
+
+	db, err := database.InitializeDB()
+	if err != nil {
+		s.renderServerError(w, fmt.Errorf("[ERROR] Failed to get database connection: %v", err))
+		return
+	}
+
+	ticket, ok := s.loadTicket(w, r, db)
+	if !ok {
+		return
+	}
+	if content == "" {
+		http.Redirect(w, r, ticketURL(ticket.TicketID), http.StatusSeeOther)
+		return
+	}
+	if ticket.Status == "closed" {
+		s.renderError(w, http.StatusConflict, "工单已关闭，无法回复。")
+		return
+	}
+
+	if _, err := tickets.AddAdminComment(db, ticket.TicketID, sess.Admin.AdminID, content, 0, 0); err != nil {
+		s.renderServerError(w, err)
+		return
+	}
+
+	http.Redirect(w, r, ticketURL(ticket.TicketID), http.StatusSeeOther)
+}
+
+func (s *Server) handleAssign(w http.ResponseWriter, r *http.Request, sess *session) {
+	adminID, err := strconv.Atoi(r.PostFormValue("admin_id"))
+	if err != nil {
+		s.renderError(w, http.StatusBadRequest, "请选择处理人。")
+		return
+	}
+
+	db, err := database.InitializeDB()
+	if err != nil {
+		s.renderServerError(w, fmt.Errorf("[ERROR] Failed to get database connection: %v", err))
+		return
+	}
+
+	ticket, ok := s.loadTicket(w, r, db)
+	if !ok {
+		return
+	}
+	admins, err := database.GetAdmins(db, s.cfg.Key)
+	if err != nil {
+		s.renderServerError(w, err)
+		return
+	}
+	var assignee *database.AdminUser
+	for i := range admins {
+		if admins[i].AdminID == adminID {
+			assignee = &admins[i]
+		}
+	}
+	if assignee == nil {
+		s.renderError(w, http.StatusBadRequest, "处理人不存在。")
+		return
+	}
+
+	if err := tickets.AssignTicket(db, ticket.TicketID, assignee.AdminID); err != nil {
+		s.renderServerError(w, err)
+		return
+	}
+
+	http.Redirect(w, r, ticketURL(ticket.TicketID), http.StatusSeeOther)
+}
+
+func (s *Server) handleSetStatus(w http.ResponseWriter, r *http.Request, sess *session) {
+	status := r.PostFormValue("status")
+	if status != "open" && status != "closed" {
+		s.renderError(w, http.StatusBadRequest, "无效的状态。")
+		return
+	}
+
+	db, err := database.InitializeDB()
+	if err != nil {
+		s.renderServerError(w, fmt.Errorf("[ERROR] Failed to get database connection: %v", err))
+		return
+	}
+
+	ticket, ok := s.loadTicket(w, r, db)
+	if !ok {
+		return
+	}
+	if ticket.Status != status {
+		if err := tickets.SetTicketStatus(db, ticket.TicketID, status, sess.Admin.TelegramID); err != nil {
+			s.renderServerError(w, err)
+			return
+		}
+	}
+
+	http.Redirect(w, r, ticketURL(ticket.TicketID), http.StatusSeeOther)
+}
@@ -0,0 +1,314 @@
+package webhooks
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"telegram-tickets-bot/src/config"
+	"telegram-tickets-bot/src/database"
+	"telegram-tickets-bot/src/tickets"
+)
+
+// Ticket events delivered to webhooks
+const (
+	EventTicketCreated  = "ticket.created"
+	EventTicketAssigned = "ticket.assigned"
+	EventTicketClosed   = "ticket.closed"
+	EventTicketReopened = "ticket.reopened"
+	EventCommentAdded   = "comment.added"
+	EventCommentEdited  = "comment.edited"
+)
+
+var Events = []string{
+	EventTicketCreated, EventTicketAssigned, EventTicketClosed,
+	EventTicketReopened, EventCommentAdded, EventCommentEdited,
+}
+
+const (
+	// How often due deliveries are looked for
+	pollInterval = 5 * time.Second
+	// Deliveries handled per poll
+	pollBatchSize = 20
+	// Attempts before a delivery is marked as failed
+	maxAttempts = 8
+	// Wait before the first retry; it doubles with every further attempt up to maxBackoff
+	initialBackoff = 30 * time.Second
+	maxBackoff     = time.Hour
+	requestTimeout = 10 * time.Second
+	// Maximum length of the error or response body kept in the delivery log
+	maxLoggedError = 500
+)
+
+// Payload is the JSON body of a delivery
+type Payload struct {
+	Event      string    `json:"event"`
+	Tenant     string    `json:"tenant"`
+	OccurredAt time.Time `json:"occurred_at"`
+	Data       Data      `json:"data"`
+}
+
+// Data of an event; the comment is only set for comment events
+type Data struct {
+	Ticket  tickets.TicketJSON   `json:"ticket"`
+	Comment *tickets.CommentJSON `json:"comment,omitempty"`
+}
+
+// Dispatcher delivers the ticket events of one tenant to its webhooks
+type Dispatcher struct {
+	tenant string
+	hooks  []config.Webhook
+	client *http.Client
+}
+
+// NewDispatcher checks the tenant's webhook subscriptions
+func NewDispatcher(cfg *config.Tenant) (*Dispatcher, error) {
+	known := make(map[string]bool)
+	for _, event := range Events {
+		known[event] = true
+	}
+	for _, hook := range cfg.Webhooks {
+		for _, event := range hook.Events {
+			if !known[event] {
+				return nil, fmt.Errorf("[ERROR] Unknown webhook event %q of tenant %q", event, cfg.Key)
+			}
+		}
+	}
+
+	return &Dispatcher{
+		tenant: cfg.Key,
+		hooks:  cfg.Webhooks,
+		client: &http.Client{Timeout: requestTimeout},
+	}, nil
+}
+
+// Hooks returns the tenant's webhook subscriptions
+func (d *Dispatcher) Hooks() []config.Webhook {
+	return d.hooks
+}
+
+func subscribed(hook config.Webhook, event string) bool {
+	if len(hook.Events) == 0 {
+		return true
+	}
+	for _, subscribedEvent := range hook.Events {
+		if subscribedEvent == event {
+			return true
+		}
+	}
+	return false
+}
+
+// HandleEvent is the dispatcher's subscriber on the ticket event bus; it queues the tenant's events for its webhooks
+func (d *Dispatcher) HandleEvent(event tickets.Event) {
+	ticket := event.EventTicket()
+	if ticket.Tenant != d.tenant {
+		return
+	}
+
+	var name string
+	var comment *tickets.TicketComment
+	switch e := event.(type) {
+	case tickets.TicketCreated:
+		name = EventTicketCreated
+	case tickets.CommentAdded:
+		name, comment = EventCommentAdded, e.Comment
+	case tickets.CommentEdited:
+		name, comment = EventCommentEdited, e.Comment
+	case tickets.TicketAssigned:
+		name = EventTicketAssigned
+	case tickets.StatusChanged:
+		name = EventTicketReopened
+		if ticket.Status == "closed" {
+			name = EventTicketClosed
+		}
+	default:
+		return
+	}
+
+	if err := d.Publish(name, ticket, comment); err != nil {
+		slog.Error("Failed to publish event to webhooks", "tenant", d.tenant, "event", name, "ticket_id", ticket.TicketID, "error", err)
+	}
+}
+
+// Publish queues the event for every webhook subscribed to it. comment is nil for ticket events.
+func (d *Dispatcher) Publish(event string, ticket *tickets.Ticket, comment *tickets.TicketComment) error {
+	var hooks []config.Webhook
+	for _, hook := range d.hooks {
+		if subscribed(hook, event) {
+			hooks = append(hooks, hook)
+		}
+	}
+	if len(hooks) == 0 {
+		return nil
+	}
+
+	payload := Payload{
+		Event:      event,
+		Tenant:     d.tenant,
+		OccurredAt: time.Now(),
+		Data:       Data{Ticket: tickets.NewTicketJSON(ticket)},
+	}
+	if comment != nil {
+		commentJSON := tickets.NewCommentJSON(comment)
+		payload.Data.Comment = &commentJSON
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("[ERROR] Failed to encode webhook payload: %v", err)
+	}
+
+	db, err := database.InitializeDB()
+	if err != nil {
+		return fmt.Errorf("[ERROR] Failed to get database connection: %v", err)
+	}
+
+	for _, hook := range hooks {
+		delivery := &database.WebhookDelivery{Tenant: d.tenant, URL: hook.URL, Event: event, Payload: string(body)}
+		if err := database.CreateWebhookDelivery(db, delivery); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Start delivers queued events in the background
+func (d *Dispatcher) Start() {
+	go func() {
+		for {
+			time.Sleep(pollInterval)
+			if err := d.deliverDue(); err != nil {
+				slog.Error("Failed to deliver webhooks", "tenant", d.tenant, "error", err)
+			}
+		}
+	}()
+}
+
+func (d *Dispatcher) deliverDue() error {
+	db, err := database.InitializeDB()
+	if err != nil {
+		return fmt.Errorf("[ERROR] Failed to get database connection: %v", err)
+	}
+
+	deliveries, err := database.GetDueWebhookDeliveries(db, d.tenant, pollBatchSize)
+	if err != nil {
+		return err
+	}
+
+	for i := range deliveries {
+		delivery := &deliveries[i]
+		d.attempt(delivery)
+		if err := database.SaveWebhookDeliveryAttempt(db, delivery); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Make one attempt at the delivery and record its outcome in it
+func (d *Dispatcher) attempt(delivery *database.WebhookDelivery) {
+	delivery.Attempts++
+	delivery.ResponseCode = 0
+
+	var hook *config.Webhook
+	for i := range d.hooks {
+		if d.hooks[i].URL == delivery.URL {
+			hook = &d.hooks[i]
+		}
+	}
+	if hook == nil {
+		delivery.Status = database.DeliveryFailed
+		delivery.LastError = "webhook no longer configured"
+		delivery.NextAttemptAt = nil
+		return
+	}
+
+	err := d.post(hook, delivery)
+	if err == nil {
+		now := time.Now()
+		delivery.Status = database.DeliveryDelivered
+		delivery.LastError = ""
+		delivery.NextAttemptAt = nil
+		delivery.DeliveredAt = &now
+		return
+	}
+
+	delivery.LastError = truncate(err.Error(), maxLoggedError)
+	if delivery.Attempts >= maxAttempts {
+		delivery.Status = database.DeliveryFailed
+		delivery.NextAttemptAt = nil
+		slog.Error("Webhook delivery failed for good", "tenant", d.tenant, "delivery_id", delivery.DeliveryID, "url", delivery.URL, "attempts", delivery.Attempts, "error", err)
+		return
+	}
+	next := time.Now().Add(backoff(delivery.Attempts))
+	delivery.NextAttemptAt = &next
+}
+
+func (d *Dispatcher) post(hook *config.Webhook, delivery *database.WebhookDelivery) error {
+	timestamp := time.Now().Unix()
+	body := []byte(delivery.Payload)
+
+	request, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", "telegram-tickets-bot")
+	request.Header.Set("X-Webhook-Event", delivery.Event)
+	request.Header.Set("X-Webhook-Delivery", strconv.Itoa(delivery.DeliveryID))
+	request.Header.Set("X-Webhook-Timestamp", strconv.FormatInt(timestamp, 10))
+	request.Header.Set("X-Webhook-Signature", Sign(hook.Secret, timestamp, body))
+
+	response, err := d.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	delivery.ResponseCode = response.StatusCode
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		excerpt, _ := io.ReadAll(io.LimitReader(response.Body, maxLoggedError))
+		if len(excerpt) == 0 {
+			return fmt.Errorf("HTTP %d", response.StatusCode)
+		}
+		return fmt.Errorf("HTTP %d: %s", response.StatusCode, excerpt)
+	}
+	return nil
+}
+
+// Sign returns the signature header of a delivery: the hex HMAC-SHA256 of "<timestamp>.<body>" keyed with the
+// webhook's secret. Receivers should recompute it and reject old timestamps to prevent replays.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Wait after the given number of failed attempts
+func backoff(attempts int) time.Duration {
+	wait := initialBackoff
+	for i := 1; i < attempts && wait < maxBackoff; i++ {
+		wait *= 2
+	}
+	if wait > maxBackoff {
+		wait = maxBackoff
+	}
+	return wait
+}
+
+func truncate(text string, limit int) string {
+	runes := []rune(text)
+	if len(runes) <= limit {
+		return text
+	}
+	return string(runes[:limit])
+}